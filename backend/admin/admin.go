@@ -0,0 +1,191 @@
+// Package admin runs the maintenance commands of the backend binary, like creating users or backing up the datab.
+// They go through the model package, so the same rules apply as in the HTTP API.
+package admin
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"social-network/backend/config"
+	"social-network/backend/datab"
+	"social-network/backend/model"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Usage lists the commands
+const Usage = `Commands, run instead of the server:
+  user create -email E -nickname N -first F -last L [-password P] [-role R]
+  user disable|enable <user>
+  user delete [-yes] <user>
+  user reset-password [-password P] <user>
+  user promote [-role admin|moderator|user] <user>
+  session list [-user U]
+  session revoke <fingerprint> | -user U
+  group list
+  group show <group ID>
+  group delete [-yes] <group ID>
+  migrate [status | baseline <version>]
+  vacuum
+  backup <file>
+  stats
+
+<user> is a user ID, email or nickname. A password is generated and printed when none is given.
+`
+
+// errUsage means the command was called wrong; the usage is printed with it
+var errUsage = errors.New("usage")
+
+// env is what a command works with
+type env struct {
+	cfg config.Config
+	db  *sql.DB
+	out io.Writer
+}
+
+type command func(e *env, args []string) error
+
+var commands = map[string]map[string]command{
+	"user": {
+		"create":         createUser,
+		"disable":        disableUser,
+		"enable":         enableUser,
+		"delete":         deleteUser,
+		"reset-password": resetPassword,
+		"promote":        promoteUser,
+	},
+	"session": {
+		"list":   listSessions,
+		"revoke": revokeSessions,
+	},
+	"group": {
+		"list":   listGroups,
+		"show":   showGroup,
+		"delete": deleteGroup,
+	},
+	"migrate": {"": migrate},
+	"vacuum":  {"": vacuum},
+	"backup":  {"": backup},
+	"stats":   {"": stats},
+}
+
+// Run runs the command of args, writing its output to out and errors to errOut, and returns the exit code
+func Run(cfg config.Config, args []string, out, errOut io.Writer) int {
+	run, args := find(args)
+	if run == nil {
+		fmt.Fprint(errOut, Usage)
+		return 2
+	}
+
+	// Only migrate may start from an empty datab, the other commands would create one by mistake
+	if _, err := os.Stat(cfg.DBPath); err != nil && args[0] != "migrate" {
+		fmt.Fprintf(errOut, "No datab at %s: %v\n", cfg.DBPath, err)
+		return 1
+	}
+	db, err := datab.ConnectDB(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(errOut, "Failed to connect to the datab: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+	// Without FTS5 the search triggers would make writes to users and groups fail
+	if _, err := datab.SetupSearch(db, cfg.MigrationsDir); err != nil {
+		fmt.Fprintf(errOut, "Failed to set up the search indexes: %v\n", err)
+		return 1
+	}
+
+	err = run(&env{cfg: cfg, db: db, out: out}, args[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprint(errOut, Usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(errOut, "%s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// find returns the command named by the first one or two args, and the args from the name of the command on
+func find(args []string) (command, []string) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	subcommands, ok := commands[args[0]]
+	if !ok {
+		return nil, nil
+	}
+	if run, ok := subcommands[""]; ok {
+		return run, args
+	}
+	if len(args) < 2 || subcommands[args[1]] == nil {
+		return nil, nil
+	}
+	return subcommands[args[1]], append([]string{args[0] + " " + args[1]}, args[2:]...)
+}
+
+// parse parses the flags of a command, which can come before, between or after its other args, and returns
+// the other args
+func parse(flags *flag.FlagSet, args []string) ([]string, error) {
+	flags.SetOutput(io.Discard)
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, fmt.Errorf("%w: %v", errUsage, err)
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// parseOne parses the flags of a command taking exactly one other arg, and returns that arg
+func parseOne(flags *flag.FlagSet, args []string) (string, error) {
+	positional, err := parse(flags, args)
+	if err != nil {
+		return "", err
+	}
+	if len(positional) != 1 {
+		return "", errUsage
+	}
+	return positional[0], nil
+}
+
+// findUser looks the user up by ID, email or nickname
+func findUser(db *sql.DB, credential string) (*model.User, error) {
+	var user *model.User
+	var err error
+	if userID, convErr := strconv.Atoi(credential); convErr == nil {
+		user, err = model.GetUserByID(db, userID)
+	} else {
+		user, err = model.GetUserByCredential(db, credential)
+	}
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no user %q", credential)
+	}
+	return user, err
+}
+
+// table writes aligned columns to the output, under the header if there is one, once flushed
+func (e *env) table(header ...string) *tabwriter.Writer {
+	w := tabwriter.NewWriter(e.out, 0, 4, 2, ' ', 0)
+	if len(header) > 0 {
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+	}
+	return w
+}
+
+func row(w io.Writer, columns ...interface{}) {
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = fmt.Sprint(column)
+	}
+	fmt.Fprintln(w, strings.Join(values, "\t"))
+}
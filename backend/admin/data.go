@@ -0,0 +1,311 @@
+package admin
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"social-network/backend/datab"
+	"social-network/backend/logging"
+	"social-network/backend/model"
+	"strconv"
+	"time"
+)
+
+// listSessions shows the sessions by the fingerprint the logs use for them, so their IDs, which work like
+// passwords, stay in the datab
+func listSessions(e *env, args []string) error {
+	flags := flag.NewFlagSet("session list", flag.ContinueOnError)
+	credential := flags.String("user", "", "")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return errUsage
+	}
+
+	userID := 0
+	if *credential != "" {
+		user, err := findUser(e.db, *credential)
+		if err != nil {
+			return err
+		}
+		userID = user.UserID
+	}
+	sessions, err := model.ListSessions(e.db, userID)
+	if err != nil {
+		return err
+	}
+
+	w := e.table("SESSION", "USER", "NICKNAME", "EMAIL", "EXPIRES")
+	for _, session := range sessions {
+		row(w, logging.Fingerprint(session.SessionID), session.UserID, session.Nickname, session.Email,
+			session.ExpiresAt.Local().Format(time.DateTime))
+	}
+	return w.Flush()
+}
+
+func revokeSessions(e *env, args []string) error {
+	flags := flag.NewFlagSet("session revoke", flag.ContinueOnError)
+	credential := flags.String("user", "", "")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *credential != "" && len(positional) == 0:
+		user, err := findUser(e.db, *credential)
+		if err != nil {
+			return err
+		}
+		ended, err := model.DeleteUserSessions(e.db, user.UserID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.out, "Ended %d sessions of user %d %s\n", ended, user.UserID, user.Nickname)
+		return nil
+
+	case *credential == "" && len(positional) == 1:
+		sessions, err := model.ListSessions(e.db, 0)
+		if err != nil {
+			return err
+		}
+		for _, session := range sessions {
+			if logging.Fingerprint(session.SessionID) == positional[0] {
+				if err := model.DeleteSession(e.db, session.SessionID); err != nil {
+					return err
+				}
+				fmt.Fprintf(e.out, "Ended session %s of user %d %s\n", positional[0], session.UserID, session.Nickname)
+				return nil
+			}
+		}
+		return fmt.Errorf("no session %s", positional[0])
+	}
+	return errUsage
+}
+
+func listGroups(e *env, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	groups, err := model.GetGroups(e.db)
+	if err != nil {
+		return err
+	}
+
+	w := e.table("ID", "NAME", "CREATOR", "MEMBERS")
+	for _, group := range groups {
+		members, err := model.GetGroupMembers(e.db, group.GroupID)
+		if err != nil {
+			return err
+		}
+		row(w, group.GroupID, group.Name, group.CreatorUserID, len(members))
+	}
+	return w.Flush()
+}
+
+func showGroup(e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	group, err := findGroup(e, args[0])
+	if err != nil {
+		return err
+	}
+	members, err := model.GetGroupMembers(e.db, group.GroupID)
+	if err != nil {
+		return err
+	}
+	content, err := model.GetGroupContent(e.db, group.GroupID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(e.out, "Group %d %s\n%s\n\n", group.GroupID, group.Name, group.Description)
+	fmt.Fprintf(e.out, "Posts: %d, events: %d, chat messages: %d\n\n", content.Posts, content.Events, content.Messages)
+	w := e.table("MEMBER", "NAME", "")
+	for _, member := range members {
+		role := ""
+		if member.UserID == group.CreatorUserID {
+			role = "creator"
+		}
+		row(w, member.UserID, member.FirstName+" "+member.LastName, role)
+	}
+	return w.Flush()
+}
+
+func deleteGroup(e *env, args []string) error {
+	flags := flag.NewFlagSet("group delete", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "")
+	id, err := parseOne(flags, args)
+	if err != nil {
+		return err
+	}
+	group, err := findGroup(e, id)
+	if err != nil {
+		return err
+	}
+	if !*yes {
+		content, err := model.GetGroupContent(e.db, group.GroupID)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("this deletes group %d %s with its %d posts, %d events and %d chat messages; run again with -yes to do it",
+			group.GroupID, group.Name, content.Posts, content.Events, content.Messages)
+	}
+	if err := model.DeleteGroup(e.db, group.GroupID); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.out, "Deleted group %d %s\n", group.GroupID, group.Name)
+	return nil
+}
+
+func findGroup(e *env, id string) (*model.Group, error) {
+	if _, err := strconv.Atoi(id); err != nil {
+		return nil, fmt.Errorf("%q is not a group ID", id)
+	}
+	group, err := model.GetGroupByID(e.db, id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no group %s", id)
+	}
+	return group, err
+}
+
+func migrate(e *env, args []string) error {
+	switch {
+	case len(args) == 1 && args[0] == "status":
+		migrations, err := datab.Migrations(e.db, e.cfg.MigrationsDir)
+		if err != nil && !errors.Is(err, datab.ErrNoMigrationHistory) {
+			return err
+		}
+		w := e.table("MIGRATION", "APPLIED")
+		for _, migration := range migrations {
+			applied := "pending"
+			if migration.AppliedAt != nil {
+				applied = migration.AppliedAt.Local().Format(time.DateTime)
+			} else if err != nil {
+				applied = "unknown"
+			}
+			row(w, migration.Name, applied)
+		}
+		if flushErr := w.Flush(); flushErr != nil {
+			return flushErr
+		}
+		return err
+
+	case len(args) == 2 && args[0] == "baseline":
+		recorded, err := datab.BaselineMigrations(e.db, e.cfg.MigrationsDir, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.out, "Recorded %d migrations up to %s as applied\n", recorded, args[1])
+		return nil
+
+	case len(args) == 0:
+		if err := datab.CreateTables(e.db, e.cfg.SchemaPath); err != nil {
+			return err
+		}
+		applied, err := datab.Migrate(e.db, e.cfg.MigrationsDir)
+		for _, migration := range applied {
+			fmt.Fprintf(e.out, "Applied %s\n", migration.Name)
+		}
+		if errors.Is(err, datab.ErrNoMigrationHistory) {
+			return fmt.Errorf("%w; if every migration is applied, record them with migrate baseline <last version>", err)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(e.out, "The datab is up to date")
+		}
+		return err
+	}
+	return errUsage
+}
+
+func vacuum(e *env, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	before, err := fileSize(e.cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	if _, err := e.db.Exec(`VACUUM`); err != nil {
+		return err
+	}
+	after, err := fileSize(e.cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.out, "Vacuumed %s from %s to %s\n", e.cfg.DBPath, formatSize(before), formatSize(after))
+	return nil
+}
+
+// backup copies the datab with VACUUM INTO, which is consistent while the server keeps writing to it
+func backup(e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	path := args[0]
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if _, err := e.db.Exec(`VACUUM INTO ?`, path); err != nil {
+		return err
+	}
+	size, err := fileSize(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.out, "Backed up %s to %s, %s\n", e.cfg.DBPath, path, formatSize(size))
+	return nil
+}
+
+func stats(e *env, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	counts, err := model.GetStats(e.db)
+	if err != nil {
+		return err
+	}
+	size, err := fileSize(e.cfg.DBPath)
+	if err != nil {
+		return err
+	}
+
+	w := e.table()
+	row(w, "Users", counts.Users)
+	row(w, "Suspended users", counts.SuspendedUsers)
+	row(w, "Moderators", counts.Moderators)
+	row(w, "Admins", counts.Admins)
+	row(w, "Active sessions", counts.ActiveSessions)
+	row(w, "Posts", counts.Posts)
+	row(w, "Comments", counts.Comments)
+	row(w, "Groups", counts.Groups)
+	row(w, "Events", counts.Events)
+	row(w, "Messages", counts.Messages)
+	row(w, "Group messages", counts.GroupMessages)
+	row(w, "Open reports", counts.OpenReports)
+	row(w, "Datab size", formatSize(size))
+	return w.Flush()
+}
+
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func formatSize(bytes int64) string {
+	switch {
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(bytes)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", bytes)
+}
@@ -0,0 +1,174 @@
+package admin
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"social-network/backend/model"
+)
+
+func createUser(e *env, args []string) error {
+	flags := flag.NewFlagSet("user create", flag.ContinueOnError)
+	user := model.User{}
+	flags.StringVar(&user.Email, "email", "", "")
+	flags.StringVar(&user.Nickname, "nickname", "", "")
+	flags.StringVar(&user.FirstName, "first", "", "")
+	flags.StringVar(&user.LastName, "last", "", "")
+	password := flags.String("password", "", "")
+	role := flags.String("role", model.RoleUser, "")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return errUsage
+	}
+
+	if err := checkRole(*role); err != nil {
+		return err
+	}
+
+	generated := *password == ""
+	if generated {
+		if *password, err = generatePassword(); err != nil {
+			return err
+		}
+	}
+	if err := model.CreateUser(e.db, &user, *password); err != nil {
+		return err
+	}
+	if *role != model.RoleUser {
+		if err := model.SetUserRole(e.db, model.ConsoleUserID, user.UserID, *role); err != nil {
+			return fmt.Errorf("created user %d but couldn't make them %s: %w", user.UserID, *role, err)
+		}
+	}
+
+	fmt.Fprintf(e.out, "Created user %d %s <%s> as %s\n", user.UserID, user.Nickname, user.Email, *role)
+	if generated {
+		fmt.Fprintf(e.out, "Password: %s\n", *password)
+	}
+	return nil
+}
+
+func disableUser(e *env, args []string) error {
+	user, err := e.userArg("user disable", args)
+	if err != nil {
+		return err
+	}
+	if err := model.SuspendUser(e.db, model.ConsoleUserID, user.UserID); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.out, "Suspended user %d %s and ended their sessions\n", user.UserID, user.Nickname)
+	return nil
+}
+
+func enableUser(e *env, args []string) error {
+	user, err := e.userArg("user enable", args)
+	if err != nil {
+		return err
+	}
+	if err := model.UnsuspendUser(e.db, model.ConsoleUserID, user.UserID); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.out, "Lifted the suspension of user %d %s\n", user.UserID, user.Nickname)
+	return nil
+}
+
+func deleteUser(e *env, args []string) error {
+	flags := flag.NewFlagSet("user delete", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "")
+	credential, err := parseOne(flags, args)
+	if err != nil {
+		return err
+	}
+	user, err := findUser(e.db, credential)
+	if err != nil {
+		return err
+	}
+	if !*yes {
+		return fmt.Errorf("this deletes user %d %s <%s> with their posts, comments, messages and the groups they created; run again with -yes to do it",
+			user.UserID, user.Nickname, user.Email)
+	}
+	if err := model.DeleteUser(e.db, user.UserID); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.out, "Deleted user %d %s\n", user.UserID, user.Nickname)
+	return nil
+}
+
+func resetPassword(e *env, args []string) error {
+	flags := flag.NewFlagSet("user reset-password", flag.ContinueOnError)
+	password := flags.String("password", "", "")
+	credential, err := parseOne(flags, args)
+	if err != nil {
+		return err
+	}
+	user, err := findUser(e.db, credential)
+	if err != nil {
+		return err
+	}
+
+	generated := *password == ""
+	if generated {
+		if *password, err = generatePassword(); err != nil {
+			return err
+		}
+	}
+	if err := model.SetPassword(e.db, user.UserID, *password); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(e.out, "Set the password of user %d %s and ended their sessions\n", user.UserID, user.Nickname)
+	if generated {
+		fmt.Fprintf(e.out, "Password: %s\n", *password)
+	}
+	return nil
+}
+
+func promoteUser(e *env, args []string) error {
+	flags := flag.NewFlagSet("user promote", flag.ContinueOnError)
+	role := flags.String("role", model.RoleAdmin, "")
+	credential, err := parseOne(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := checkRole(*role); err != nil {
+		return err
+	}
+	user, err := findUser(e.db, credential)
+	if err != nil {
+		return err
+	}
+	if err := model.SetUserRole(e.db, model.ConsoleUserID, user.UserID, *role); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.out, "User %d %s is now %s, was %s\n", user.UserID, user.Nickname, *role, user.Role)
+	return nil
+}
+
+// checkRole fails for a role that isn't one of the three, before anything is changed
+func checkRole(role string) error {
+	if !model.IsRole(role) {
+		return fmt.Errorf("unknown role %q, use %s, %s or %s", role, model.RoleAdmin, model.RoleModerator, model.RoleUser)
+	}
+	return nil
+}
+
+// userArg parses the args of a command taking nothing but a user, and looks the user up
+func (e *env) userArg(name string, args []string) (*model.User, error) {
+	credential, err := parseOne(flag.NewFlagSet(name, flag.ContinueOnError), args)
+	if err != nil {
+		return nil, err
+	}
+	return findUser(e.db, credential)
+}
+
+// generatePassword returns 16 random characters
+func generatePassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
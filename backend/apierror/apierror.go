@@ -0,0 +1,61 @@
+// Package apierror writes API errors as JSON: {"code": ..., "message": ..., "details": ...}
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Error is the body of every error response. Code is a stable identifier for clients to check, Message is for
+// people, and Details holds extra data like which field was wrong.
+type Error struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+	// Legacy is the message again, for clients written against the old {"error": ...} responses
+	Legacy string `json:"error"`
+}
+
+// Codes for the statuses the API answers with; Write takes other codes too
+var codes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "gone",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusTooManyRequests:       "too_many_requests",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// CodeFor returns the code of a status
+func CodeFor(status int) string {
+	if code, ok := codes[status]; ok {
+		return code
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// Write answers with the status and an error body
+func Write(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	if code == "" {
+		code = CodeFor(status)
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Error{Code: code, Message: message, Details: details, Legacy: message})
+}
+
+// HTTPError is a drop-in for http.Error, with the code taken from the status
+func HTTPError(w http.ResponseWriter, message string, status int) {
+	Write(w, status, "", message, nil)
+}
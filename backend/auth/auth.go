@@ -2,69 +2,104 @@ package auth
 
 import (
 	"database/sql"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
+	"social-network/backend/apierror"
+	"social-network/backend/logging"
 	"social-network/backend/model"
 )
 
-func AuthMiddleware(db *sql.DB, next http.HandlerFunc) http.HandlerFunc {
+// AuthMiddleware only lets requests with a valid session through, and extends the session by sessionTTL
+func AuthMiddleware(db *sql.DB, sessionTTL time.Duration, security SecurityConfig, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("AuthMiddleware invoked")
+		ctx := r.Context()
 
 		// Clean up expired sessions
-		_, cleanupErr := db.Exec("DELETE FROM Sessions WHERE ExpiresAt < ?", time.Now())
+		_, cleanupErr := db.ExecContext(ctx, "DELETE FROM Sessions WHERE ExpiresAt < ?", time.Now())
 		if cleanupErr != nil {
-			log.Printf("Error cleaning up sessions: %v", cleanupErr)
+			slog.ErrorContext(ctx, "Error cleaning up sessions", "error", cleanupErr)
 		}
 
-		log.Println("Clean-up query executed")
-
 		// Retrieve session_id cookie
 		cookie, err := r.Cookie("session_id")
 		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
 		sessionID := cookie.Value
 
 		// Validate the session
-		isValid, err := model.ValidateSession(db, sessionID)
+		isValid, err := model.ValidateSession(ctx, db, sessionID)
 		if err != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
 		if !isValid {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		log.Printf("Trying to extend session %s", sessionID)
-
 		// Extend session expiry
-		err = model.ExtendSessionExpiry(db, sessionID)
+		err = model.ExtendSessionExpiry(ctx, db, sessionID, sessionTTL)
 		if err != nil {
-			log.Printf("Error extending session: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			slog.ErrorContext(ctx, "Error extending session", "fingerprint", logging.Fingerprint(sessionID), "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
 		// Update the session cookie expiration time
-		expirationTime := time.Now().Add(45 * time.Minute)
-		cookie.Expires = expirationTime
-		http.SetCookie(w, cookie)
+		expirationTime := time.Now().Add(sessionTTL)
+		http.SetCookie(w, security.SessionCookie(r, sessionID, expirationTime))
 
-		log.Printf("Session %s and cookie extended", sessionID)
+		slog.DebugContext(ctx, "Session and cookie extended", "fingerprint", logging.Fingerprint(sessionID))
 
 		// Call the next handler
 		next.ServeHTTP(w, r)
 	}
 }
 
-func EnableCors(w *http.ResponseWriter) {
-	(*w).Header().Set("Access-Control-Allow-Origin", "http://localhost:8081")
-	(*w).Header().Set("Access-Control-Allow-Credentials", "true")
+// ModeratorMiddleware only lets moderators and admins through
+func ModeratorMiddleware(db *sql.DB, next http.HandlerFunc) http.HandlerFunc {
+	return requireRole(db, next, model.RoleModerator, model.RoleAdmin)
+}
+
+// AdminMiddleware only lets admins through
+func AdminMiddleware(db *sql.DB, next http.HandlerFunc) http.HandlerFunc {
+	return requireRole(db, next, model.RoleAdmin)
+}
+
+func requireRole(db *sql.DB, next http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session_id")
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userID, err := model.GetUserIDBySessionID(db, cookie.Value)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		role, err := model.GetUserRole(db, userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error fetching role", "user_id", userID, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		for _, allowed := range roles {
+			if role == allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		apierror.HTTPError(w, "Forbidden", http.StatusForbidden)
+	}
 }
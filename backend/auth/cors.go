@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsHeaders are the request headers the API takes from other origins
+const corsHeaders = "Content-Type, Authorization, " + CSRFHeader
+
+// CORSMiddleware lets the allowed origins call the API with the user's cookies and answers their preflight
+// requests. methods returns the methods a path can be requested with.
+func CORSMiddleware(next http.Handler, methods func(path string) []string, security SecurityConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if !security.AllowedOrigin(r, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			allowed := methods(r.URL.Path)
+			if allowed == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", corsHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"social-network/backend/apierror"
+)
+
+// The CSRF token is a double-submit cookie: pages of an allowed origin can read the cookie and send it back in
+// the header, while other sites can make the browser send the cookie but can't read it
+const (
+	csrfCookieName = "csrf_token"
+	CSRFHeader     = "X-CSRF-Token"
+)
+
+// CSRFMiddleware protects state-changing requests made with a session cookie. Requests from origins that aren't
+// allowed are refused; the others need the CSRF header matching the cookie, or, unless the token is required,
+// an Origin or Referer showing they came from an allowed page.
+func CSRFMiddleware(next http.Handler, security SecurityConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := csrfToken(w, r, security)
+		r = r.WithContext(context.WithValue(r.Context(), csrfTokenKey{}, token))
+
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		origin := requestOrigin(r)
+		if origin != "" && !security.AllowedOrigin(r, origin) {
+			slog.WarnContext(r.Context(), "Refused cross-origin request", "method", r.Method, "path", r.URL.Path, "origin", origin)
+			apierror.Write(w, http.StatusForbidden, "origin_not_allowed", "Requests from "+origin+" are not allowed", nil)
+			return
+		}
+
+		// Without a session there is nothing to act as
+		if _, err := r.Cookie("session_id"); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if header := r.Header.Get(CSRFHeader); header != "" {
+			if subtle.ConstantTimeCompare([]byte(header), []byte(token)) != 1 {
+				apierror.Write(w, http.StatusForbidden, "csrf_token_invalid", "Invalid CSRF token", nil)
+				return
+			}
+		} else if security.RequireCSRFToken || origin == "" {
+			apierror.Write(w, http.StatusForbidden, "csrf_token_missing", "Missing CSRF token", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type csrfTokenKey struct{}
+
+// CSRFToken returns the CSRF token CSRFMiddleware found or issued for the request
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfTokenKey{}).(string)
+	return token
+}
+
+// csrfToken returns the token from the request's cookie, or sets a cookie with a new one
+func csrfToken(w http.ResponseWriter, r *http.Request, security SecurityConfig) string {
+	if cookie, err := r.Cookie(csrfCookieName); err == nil && len(cookie.Value) == 64 {
+		return cookie.Value
+	}
+
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		slog.ErrorContext(r.Context(), "Error generating CSRF token", "error", err)
+		return ""
+	}
+	token := hex.EncodeToString(bytes)
+
+	// Scripts have to read this one, so it is never HttpOnly
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		Secure:   security.secureCookie(r),
+		SameSite: security.CookieSameSite,
+	})
+	return token
+}
+
+// requestOrigin returns the origin the request says it came from, from Origin or else Referer
+func requestOrigin(r *http.Request) string {
+	// Sandboxed pages send "null", which isn't an allowed origin either
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return origin
+	}
+	if referer := r.Header.Get("Referer"); referer != "" {
+		if u, err := url.Parse(referer); err == nil && u.Host != "" {
+			return u.Scheme + "://" + u.Host
+		}
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// SecurityConfig holds the origins allowed to call the API and how cookies are set
+type SecurityConfig struct {
+	// AllowedOrigins may call the API with credentials and open websockets, besides the server's own origin
+	AllowedOrigins []string
+
+	// CookieSecure is "auto" to mark cookies Secure on HTTPS requests, or "always" or "never"
+	CookieSecure   string
+	CookieSameSite http.SameSite
+	// CookieHTTPOnly hides the session cookie from scripts; the frontend reads it, so it is off by default
+	CookieHTTPOnly bool
+
+	// RequireCSRFToken makes every state-changing request with a session send the X-CSRF-Token header.
+	// Without it, a request from an allowed origin is enough.
+	RequireCSRFToken bool
+
+	// TrustProxy takes the scheme from X-Forwarded-Proto, for running behind a reverse proxy
+	TrustProxy bool
+}
+
+func DefaultSecurityConfig() SecurityConfig {
+	return SecurityConfig{
+		AllowedOrigins: []string{"http://localhost:8081"},
+		CookieSecure:   "auto",
+		CookieSameSite: http.SameSiteLaxMode,
+	}
+}
+
+// SecurityConfigFromEnv returns the default config with what is set in the environment, like
+// ALLOWED_ORIGINS=https://example.com,https://www.example.com
+func SecurityConfigFromEnv() (SecurityConfig, error) {
+	return SecurityConfigFrom(os.Getenv)
+}
+
+// SecurityConfigFrom is SecurityConfigFromEnv with the variables looked up by getenv
+func SecurityConfigFrom(getenv func(string) string) (SecurityConfig, error) {
+	config := DefaultSecurityConfig()
+
+	if origins := getenv("ALLOWED_ORIGINS"); origins != "" {
+		config.AllowedOrigins = nil
+		for _, origin := range strings.Split(origins, ",") {
+			origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+			if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+				return config, fmt.Errorf("ALLOWED_ORIGINS: %q is not an origin like https://example.com", origin)
+			}
+			config.AllowedOrigins = append(config.AllowedOrigins, origin)
+		}
+	}
+
+	switch secure := getenv("COOKIE_SECURE"); secure {
+	case "":
+	case "auto", "always", "never":
+		config.CookieSecure = secure
+	default:
+		return config, fmt.Errorf("COOKIE_SECURE: %q is not auto, always or never", secure)
+	}
+
+	switch sameSite := getenv("COOKIE_SAMESITE"); strings.ToLower(sameSite) {
+	case "":
+	case "lax":
+		config.CookieSameSite = http.SameSiteLaxMode
+	case "strict":
+		config.CookieSameSite = http.SameSiteStrictMode
+	case "none":
+		config.CookieSameSite = http.SameSiteNoneMode
+	default:
+		return config, fmt.Errorf("COOKIE_SAMESITE: %q is not lax, strict or none", sameSite)
+	}
+	// Browsers drop SameSite=None cookies that aren't Secure
+	if config.CookieSameSite == http.SameSiteNoneMode && config.CookieSecure == "never" {
+		return config, fmt.Errorf("COOKIE_SAMESITE=none needs COOKIE_SECURE auto or always")
+	}
+
+	config.CookieHTTPOnly = getenv("COOKIE_HTTP_ONLY") == "true"
+	config.RequireCSRFToken = getenv("CSRF_REQUIRE_TOKEN") == "true"
+	config.TrustProxy = getenv("TRUST_PROXY") == "true"
+	return config, nil
+}
+
+// AllowedOrigin reports whether the origin, like https://example.com, may make requests with the user's cookies.
+// The server's own origin always may.
+func (c SecurityConfig) AllowedOrigin(r *http.Request, origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// CheckOrigin is for the websocket upgrader: browsers always send an Origin, so a request without one isn't
+// coming from a page, and one from another site is cross-site websocket hijacking
+func (c SecurityConfig) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || c.AllowedOrigin(r, origin)
+}
+
+// IsHTTPS reports whether the request came over HTTPS, directly or through a trusted proxy
+func (c SecurityConfig) IsHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return c.TrustProxy && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+func (c SecurityConfig) secureCookie(r *http.Request) bool {
+	switch c.CookieSecure {
+	case "always":
+		return true
+	case "never":
+		return false
+	default:
+		return c.IsHTTPS(r)
+	}
+}
+
+// SessionCookie returns the session cookie to set for the request; an empty value with a past expiry removes it
+func (c SecurityConfig) SessionCookie(r *http.Request, sessionID string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     "session_id",
+		Value:    sessionID,
+		Expires:  expires,
+		Path:     "/",
+		HttpOnly: c.CookieHTTPOnly,
+		Secure:   c.secureCookie(r),
+		SameSite: c.CookieSameSite,
+	}
+}
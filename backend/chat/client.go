@@ -1,15 +1,20 @@
 package chat
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
 
+	"social-network/backend/apierror"
+	"social-network/backend/auth"
+	"social-network/backend/logging"
 	"social-network/backend/model"
+	"social-network/backend/ratelimit"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -34,12 +39,14 @@ var (
 	space   = []byte{' '}
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  4096,
-	WriteBufferSize: 4096,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+// newUpgrader returns the websocket upgrader. Only pages of the allowed origins may open a websocket with the
+// user's session cookie.
+func newUpgrader(security auth.SecurityConfig) websocket.Upgrader {
+	return websocket.Upgrader{
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+		CheckOrigin:     security.CheckOrigin,
+	}
 }
 
 // C represents the websocket client at the server
@@ -49,6 +56,11 @@ type C struct {
 	send       chan []byte
 	room       *Room
 	sendBuffer []json.RawMessage
+	userID     int
+	limiter    *ratelimit.Limiter // every message the client sends
+	violations int                // messages over the limit in a row
+	done       chan struct{}      // closed when the connection stops reading
+	ctx        context.Context    // carries the connection's ID and user into its logs
 }
 
 type URelation struct {
@@ -59,6 +71,7 @@ type URelation struct {
 	FirstName      string
 	LastName       string
 	ProfilePicture string `json:"profilePicture"`
+	CanMessage     bool
 }
 
 type SockMessage struct {
@@ -73,22 +86,25 @@ type IncomingMessage struct {
 	Content        string    `json:"content"`
 	Timestamp      time.Time `json:"timestamp"`
 	GroupID        int       `json:"groupId,omitempty"`
+	AttachmentIDs  []string  `json:"attachmentIds,omitempty"`
 }
 
 type Message struct {
-	MessageID         string    `json:"messageId"`
-	SenderUserID      int       `json:"senderUserId"`
-	ReceiverUserID    int       `json:"receiverUserId"`
-	RoomID            string    `json:"roomId"`
-	Content           string    `json:"content"`
-	Timestamp         time.Time `json:"timestamp"`
-	Read              bool      `json:"read"`
-	SenderFirstName   string    `json:"senderFirstName"`
-	SenderLastName    string    `json:"senderLastName"`
-	SenderNickname    string    `json:"senderNickname"`
-	ReceiverFirstName string    `json:"receiverFirstName"`
-	ReceiverLastName  string    `json:"receiverLastName"`
-	ReceiverNickname  string    `json:"receiverNickname"`
+	MessageID            string             `json:"messageId"`
+	SenderUserID         int                `json:"senderUserId"`
+	ReceiverUserID       int                `json:"receiverUserId"`
+	RoomID               string             `json:"roomId"`
+	Content              string             `json:"content"`
+	Timestamp            time.Time          `json:"timestamp"`
+	Read                 bool               `json:"read"`
+	SenderFirstName      string             `json:"senderFirstName"`
+	SenderLastName       string             `json:"senderLastName"`
+	SenderNickname       string             `json:"senderNickname"`
+	SenderProfilePicture string             `json:"senderProfilePicture"`
+	ReceiverFirstName    string             `json:"receiverFirstName"`
+	ReceiverLastName     string             `json:"receiverLastName"`
+	ReceiverNickname     string             `json:"receiverNickname"`
+	Attachments          []model.Attachment `json:"attachments,omitempty"`
 }
 
 type EvResponseNotification struct {
@@ -130,20 +146,37 @@ type GrJoinReqNotification struct {
 	GroupName string `json:"groupName"`
 }
 
-func newClient(conn *websocket.Conn, wsServer *WSServer) *C {
+func newClient(ctx context.Context, conn *websocket.Conn, wsServer *WSServer, userID int) *C {
 	return &C{
+		ctx:      ctx,
 		conn:     conn,
 		wsServer: wsServer,
 		send:     make(chan []byte, 256),
+		userID:   userID,
+		limiter:  ratelimit.NewLimiter(wsServer.limits.Config.WSMessagesPerConn),
+		done:     make(chan struct{}),
 	}
 
 }
 
+// newSockMessage marshals a typed websocket message
+func newSockMessage(messageType string, payload interface{}) ([]byte, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(SockMessage{
+		Type:    messageType,
+		Payload: json.RawMessage(payloadJSON),
+	})
+}
+
 func (client *C) readPump(db *sql.DB, UserID int) {
-	log.Println("Starting readPump for client")
+	slog.InfoContext(client.ctx, "Websocket connected")
+	defer close(client.done)
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("Recovered from panic in readPump: %v", r)
+			slog.ErrorContext(client.ctx, "Recovered from panic in readPump", "panic", r)
 		}
 	}()
 
@@ -155,38 +188,59 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 	for {
 		_, message, err := client.conn.ReadMessage()
 		if err != nil {
-			log.Printf("Error reading message: %v", err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				slog.WarnContext(client.ctx, "Websocket closed unexpectedly", "error", err)
+			} else {
+				slog.InfoContext(client.ctx, "Websocket disconnected", "error", err)
+			}
 			break
 		}
 
-		log.Println("Received a new message from client")
+		slog.DebugContext(client.ctx, "Websocket message received", "bytes", len(message))
+		messagesReceived.Inc()
+
+		// Messages over the limit are dropped, and clients that keep sending them are disconnected
+		if allowed, retryAfter := client.limiter.Allow(""); !allowed {
+			client.violations++
+			if client.violations >= client.wsServer.limits.Config.WSMaxViolations {
+				slog.WarnContext(client.ctx, "Disconnecting websocket for sending too many messages")
+				client.close(websocket.ClosePolicyViolation, "too many messages", time.Now().Add(writeWait))
+				break
+			}
+			if client.violations == 1 {
+				if responseJSON, err := newSockMessage("rateLimited", map[string]interface{}{"retryAfter": retryAfter.Seconds()}); err == nil {
+					client.send <- responseJSON
+				}
+			}
+			continue
+		}
+		client.violations = 0
 
 		var wsMessage SockMessage
 		if err := json.Unmarshal(message, &wsMessage); err != nil {
-			log.Println("Error unmarshaling WebSocket message:", err)
+			slog.ErrorContext(client.ctx, "Error unmarshaling WebSocket message", "error", err)
 			continue
 		}
 
 		switch wsMessage.Type {
 		// Add a new case for checking notifications
 		case "eventInvite":
-			log.Printf("Raw message received: %s", message)
 			// Extract the user ID from the payload if needed
 			var notificationCheck struct {
 				UserID int `json:"userId"`
 			}
 			if err := json.Unmarshal(wsMessage.Payload, &notificationCheck); err != nil {
-				log.Println("Error unmarshaling notification check request:", err)
+				slog.ErrorContext(client.ctx, "Error unmarshaling notification check request", "error", err)
 				continue
 			}
 
 			// Log the notification check request
-			log.Printf("Checking notifications for user ID: %d", notificationCheck.UserID)
+			slog.DebugContext(client.ctx, "Checking notifications")
 
 			// Query the datab for notifications
 			notifications, err := CheckEventInvite(db, notificationCheck.UserID)
 			if err != nil {
-				log.Println("Error checking notifications:", err)
+				slog.ErrorContext(client.ctx, "Error checking notifications", "error", err)
 				// Send an error response if needed
 				continue
 			}
@@ -212,8 +266,6 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 			responseJSON, _ := json.Marshal(response)
 			client.send <- responseJSON
 
-			log.Println("Notification check response sent to client")
-
 		case "eInviteResponse":
 			var eventResponse struct {
 				ResponseId int    `json:"responseId"`
@@ -221,7 +273,7 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 				Response   string `json:"response"` // going or notGoing
 			}
 			if err := json.Unmarshal(wsMessage.Payload, &eventResponse); err != nil {
-				log.Println("Error unmarshaling event response:", err)
+				slog.ErrorContext(client.ctx, "Error unmarshaling event response", "error", err)
 				continue
 			}
 
@@ -229,28 +281,27 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 			err := ProcessEventResponse(db, eventResponse.ResponseId, eventResponse.UserId, eventResponse.Response)
 			if err != nil {
 				// Handle error
-				log.Println("Error processing event response:", err)
+				slog.ErrorContext(client.ctx, "Error processing event response", "error", err)
 			}
 
 		case "groupInvite":
-			log.Printf("Received groupInvite check request: %s", message)
 			// Extract the user ID from the payload
 			var groupInviteCheck struct {
 				UserID int `json:"userId"`
 			}
 			if err := json.Unmarshal(wsMessage.Payload, &groupInviteCheck); err != nil {
-				log.Printf("Error unmarshaling groupInvite check request: %v", err)
+				slog.ErrorContext(client.ctx, "Error unmarshaling groupInvite check request", "error", err)
 				// Send an error response if needed
 				continue
 			}
 
 			// Log the group invite check request
-			log.Printf("Checking group invites for user ID: %d", groupInviteCheck.UserID)
+			slog.DebugContext(client.ctx, "Checking group invites")
 
 			// Query the datab for group invites
 			invites, err := CheckGroupInvites(db, groupInviteCheck.UserID)
 			if err != nil {
-				log.Printf("Error checking group invites: %v", err)
+				slog.ErrorContext(client.ctx, "Error checking group invites", "error", err)
 				// Send an error response if needed
 				continue
 			}
@@ -276,8 +327,6 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 			responseJSON, _ := json.Marshal(response)
 			client.send <- responseJSON
 
-			log.Printf("Group invite check response sent to client")
-
 		case "gInviteResponse":
 			var groupResponse struct {
 				GroupID int  `json:"groupId"`
@@ -285,14 +334,14 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 				Accept  bool `json:"accept"`
 			}
 			if err := json.Unmarshal(wsMessage.Payload, &groupResponse); err != nil {
-				log.Println("Error unmarshaling group invite response:", err)
+				slog.ErrorContext(client.ctx, "Error unmarshaling group invite response", "error", err)
 				continue
 			}
 
 			// Process the group invite response
 			err := HandleGroupInviteResponse(db, groupResponse.UserID, groupResponse.GroupID, groupResponse.Accept)
 			if err != nil {
-				log.Println("Error processing group invite response:", err)
+				slog.ErrorContext(client.ctx, "Error processing group invite response", "error", err)
 			}
 
 		case "followRequest":
@@ -301,14 +350,14 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 				RequesterUserId int `json:"requesterUserId"`
 			}
 			if err := json.Unmarshal(wsMessage.Payload, &followReq); err != nil {
-				log.Println("Error unmarshaling follow request:", err)
+				slog.ErrorContext(client.ctx, "Error unmarshaling follow request", "error", err)
 				continue
 			}
 
 			// Save the follow request to the datab
 			err := SaveFollowRequest(db, followReq.RequesterUserId, followReq.TargetUserId)
 			if err != nil {
-				log.Println("Error saving follow request:", err)
+				slog.ErrorContext(client.ctx, "Error saving follow request", "error", err)
 				// Optionally, send a failure response back to the client
 				continue
 			}
@@ -319,14 +368,14 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 				FollowerUserId int `json:"followerUserId"`
 			}
 			if err := json.Unmarshal(wsMessage.Payload, &payload); err != nil {
-				log.Println("Error unmarshaling accept follow request:", err)
+				slog.ErrorContext(client.ctx, "Error unmarshaling accept follow request", "error", err)
 				continue
 			}
 
 			// Accept the follow request
 			err := AcceptFollowRequest(db, payload.FollowerUserId, payload.UserId)
 			if err != nil {
-				log.Println("Error accepting follow request:", err)
+				slog.ErrorContext(client.ctx, "Error accepting follow request", "error", err)
 				// Optionally send an error response back to the client
 			}
 
@@ -336,14 +385,14 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 				FollowerUserId int `json:"followerUserId"`
 			}
 			if err := json.Unmarshal(wsMessage.Payload, &payload); err != nil {
-				log.Println("Error unmarshaling decline follow request:", err)
+				slog.ErrorContext(client.ctx, "Error unmarshaling decline follow request", "error", err)
 				continue
 			}
 
 			// Decline the follow request
 			err := RemoveFollowRequest(db, payload.FollowerUserId, payload.UserId)
 			if err != nil {
-				log.Println("Error declining follow request:", err)
+				slog.ErrorContext(client.ctx, "Error declining follow request", "error", err)
 			}
 
 		case "cancelFollowRequest":
@@ -352,13 +401,13 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 				RequesterUserId int `json:"requesterUserId"`
 			}
 			if err := json.Unmarshal(wsMessage.Payload, &cancelPayload); err != nil {
-				log.Println("Error unmarshaling cancel follow request:", err)
+				slog.ErrorContext(client.ctx, "Error unmarshaling cancel follow request", "error", err)
 				continue
 			}
 
 			err := RemoveFollowRequest(db, cancelPayload.RequesterUserId, cancelPayload.TargetUserId)
 			if err != nil {
-				log.Println("Error removing follow request:", err)
+				slog.ErrorContext(client.ctx, "Error removing follow request", "error", err)
 			}
 
 		case "followRequestCheck":
@@ -366,17 +415,16 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 				UserId int `json:"userId"`
 			}
 			if err := json.Unmarshal(wsMessage.Payload, &checkPayload); err != nil {
-				log.Println("Error unmarshaling follow request check:", err)
+				slog.ErrorContext(client.ctx, "Error unmarshaling follow request check", "error", err)
 				continue
 			}
 
 			// Fetch follow requests
 			followRequests, err := FetchFollowRequests(db, checkPayload.UserId)
 			followRequestsJSON, err := json.Marshal(followRequests)
-			log.Printf("Marshalled follow requests JSON: %s", string(followRequestsJSON))
 
 			if err != nil {
-				log.Println("Error marshaling follow requests:", err)
+				slog.ErrorContext(client.ctx, "Error marshaling follow requests", "error", err)
 				// Handle error appropriately
 			} else {
 				response := SockMessage{
@@ -392,19 +440,19 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 				UserId int `json:"userId"`
 			}
 			if err := json.Unmarshal(wsMessage.Payload, &checkPayload); err != nil {
-				log.Println("Error unmarshaling group join request check:", err)
+				slog.ErrorContext(client.ctx, "Error unmarshaling group join request check", "error", err)
 				continue
 			}
 
 			requests, err := FetchGroupJoinRequests(db, checkPayload.UserId)
 			if err != nil {
-				log.Printf("Error fetching group join requests: %v", err)
+				slog.ErrorContext(client.ctx, "Error fetching group join requests", "error", err)
 				continue
 			}
 
 			responsePayload, err := json.Marshal(requests)
 			if err != nil {
-				log.Printf("Error marshaling group join requests response: %v", err)
+				slog.ErrorContext(client.ctx, "Error marshaling group join requests response", "error", err)
 				continue
 			}
 
@@ -420,14 +468,14 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 				RequestId int `json:"requestId"`
 			}
 			if err := json.Unmarshal(wsMessage.Payload, &acceptPayload); err != nil {
-				log.Println("Error unmarshaling accept group join request:", err)
+				slog.ErrorContext(client.ctx, "Error unmarshaling accept group join request", "error", err)
 				continue
 			}
 
 			// Accept the group join request logic
 			err := AcceptGroupJoinRequest(db, acceptPayload.RequestId, UserID)
 			if err != nil {
-				log.Println("Error accepting group join request:", err)
+				slog.ErrorContext(client.ctx, "Error accepting group join request", "error", err)
 				// Optionally send an error response back to the client
 			}
 
@@ -436,82 +484,193 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 				RequestId int `json:"requestId"`
 			}
 			if err := json.Unmarshal(wsMessage.Payload, &declinePayload); err != nil {
-				log.Println("Error unmarshaling decline group join request:", err)
+				slog.ErrorContext(client.ctx, "Error unmarshaling decline group join request", "error", err)
 				continue
 			}
 
 			// Decline the group join request logic
 			err := DeclineGroupJoinRequest(db, declinePayload.RequestId)
 			if err != nil {
-				log.Println("Error declining group join request:", err)
+				slog.ErrorContext(client.ctx, "Error declining group join request", "error", err)
 				// Optionally send an error response back to the client
 			}
 
 		case "chatMessage":
 			var chatMsg IncomingMessage
 			if err := json.Unmarshal(wsMessage.Payload, &chatMsg); err != nil {
-				log.Println("Error unmarshaling chat message:", err)
+				slog.ErrorContext(client.ctx, "Error unmarshaling chat message", "error", err)
 				continue
 			}
 
+			// The sender is always the authenticated user
+			chatMsg.SenderUserID = UserID
+
+			if allowed, retryAfter := client.wsServer.limits.Messages.Allow(ratelimit.UserKey(UserID)); !allowed {
+				if responseJSON, err := newSockMessage("chatMessageRejected", map[string]interface{}{
+					"receiverUserId": chatMsg.ReceiverUserID,
+					"roomId":         chatMsg.RoomID,
+					"reason":         "rateLimited",
+					"retryAfter":     retryAfter.Seconds(),
+				}); err == nil {
+					client.send <- responseJSON
+				}
+				continue
+			}
+
+			if chatMsg.GroupID != 0 {
+				// The group comes from the room the sender is a member of, not from the client
+				groupID, err := model.GetChatRoomGroup(db, chatMsg.RoomID, UserID)
+				if err != nil {
+					slog.WarnContext(client.ctx, "Not allowed to post in room", "room_id", chatMsg.RoomID, "error", err)
+					continue
+				}
+				chatMsg.GroupID = groupID
+			} else {
+				if chatMsg.RoomID != "" {
+					// The receiver of a private message is whoever else is in the room
+					peerID, err := GetRoomPeer(db, chatMsg.RoomID, UserID)
+					if err != nil {
+						slog.WarnContext(client.ctx, "Not allowed to post in room", "room_id", chatMsg.RoomID, "error", err)
+						continue
+					}
+					chatMsg.ReceiverUserID = peerID
+				}
+
+				// Accounts past their grace period need a verified email to send private messages
+				if err := model.RequireVerified(db, UserID); err != nil {
+					if err != model.ErrUnverified {
+						slog.ErrorContext(client.ctx, "Error checking email verification", "error", err)
+						continue
+					}
+					if responseJSON, err := newSockMessage("chatMessageRejected", map[string]interface{}{"receiverUserId": chatMsg.ReceiverUserID, "reason": "unverified"}); err == nil {
+						client.send <- responseJSON
+					}
+					continue
+				}
+
+				// Blocked users can't reach each other, not even through message requests
+				blocked, err := model.IsBlocked(db, UserID, chatMsg.ReceiverUserID)
+				if err != nil {
+					slog.ErrorContext(client.ctx, "Error checking blocked users", "error", err)
+					continue
+				}
+				if blocked {
+					if responseJSON, err := newSockMessage("chatMessageRejected", map[string]interface{}{"receiverUserId": chatMsg.ReceiverUserID}); err == nil {
+						client.send <- responseJSON
+					}
+					continue
+				}
+
+				allowed, err := model.CanDirectMessage(db, UserID, chatMsg.ReceiverUserID)
+				if err != nil {
+					slog.ErrorContext(client.ctx, "Error checking direct message policy", "error", err)
+					continue
+				}
+
+				if !allowed {
+					// The receiver's policy does not allow the message, so it becomes a message request
+					status, err := model.SaveMessageRequest(db, UserID, chatMsg.ReceiverUserID, chatMsg.Content)
+					if err != nil {
+						slog.ErrorContext(client.ctx, "Error saving message request", "error", err)
+						continue
+					}
+
+					if responseJSON, err := newSockMessage("messageRequestSent", map[string]interface{}{"receiverUserId": chatMsg.ReceiverUserID}); err == nil {
+						client.send <- responseJSON
+					}
+					if status == "pending" {
+						if notificationJSON, err := newSockMessage("messageRequest", map[string]interface{}{"senderUserId": UserID}); err == nil {
+							client.wsServer.sendToUser(chatMsg.ReceiverUserID, notificationJSON)
+						}
+					}
+					continue
+				}
+
+				if chatMsg.RoomID == "" {
+					roomID, err := GetCreateRoom(db, UserID, chatMsg.ReceiverUserID)
+					if err != nil {
+						slog.ErrorContext(client.ctx, "Error getting or creating room", "error", err)
+						continue
+					}
+					chatMsg.RoomID = roomID
+					client.wsServer.addUserToRoom(UserID, roomID)
+					client.wsServer.addUserToRoom(chatMsg.ReceiverUserID, roomID)
+				}
+
+				// Writing to someone lets them answer regardless of the sender's own policy
+				if err := model.AllowDirectMessages(db, UserID, chatMsg.ReceiverUserID); err != nil {
+					slog.ErrorContext(client.ctx, "Error saving message permission", "error", err)
+				}
+			}
+
 			// Fetch sender's first name and last name
 			firstName, lastName, err := model.GetUserDetails(db, chatMsg.SenderUserID)
 			if err != nil {
-				log.Println("Failed to fetch user details for sender:", err)
+				slog.ErrorContext(client.ctx, "Failed to fetch user details for sender", "error", err)
 				continue // Or handle the error as you see fit
 			}
 
-			log.Printf("Received a chat message: %+v", chatMsg)
-
 			// Save the message to the datab
-			if err = saveMessage(db, chatMsg); err != nil {
-				log.Println("Error saving message:", err)
+			messageID, err := saveMessage(db, chatMsg)
+			if err != nil {
+				slog.ErrorContext(client.ctx, "Error saving message", "error", err)
 				continue
 			}
 
+			attachments, err := model.LinkAttachments(db, messageID, chatMsg.RoomID, chatMsg.SenderUserID, chatMsg.AttachmentIDs)
+			if err != nil {
+				slog.ErrorContext(client.ctx, "Error linking attachments", "error", err)
+			}
+
 			// Construct the broadcast message including sender's name
+			chatPayload, err := json.Marshal(map[string]interface{}{
+				"messageId":       messageID,
+				"senderUserId":    chatMsg.SenderUserID,
+				"senderFirstName": firstName,
+				"senderLastName":  lastName,
+				"content":         chatMsg.Content,
+				"roomId":          chatMsg.RoomID,
+				"timestamp":       chatMsg.Timestamp.Format(time.RFC3339),
+				"groupId":         chatMsg.GroupID,
+				"attachments":     attachments,
+			})
+			if err != nil {
+				slog.ErrorContext(client.ctx, "Error marshaling chat message", "error", err)
+				continue
+			}
 			broadcastMessage := SockMessage{
-				Type: "chatMessage",
-				Payload: json.RawMessage(fmt.Sprintf(`{
-					"senderUserId": %d,
-					"senderFirstName": "%s",
-					"senderLastName": "%s",
-					"content": "%s",
-					"roomId": "%s",
-					"timestamp": "%s",
-					"groupId": %d
-				}`, chatMsg.SenderUserID, firstName, lastName, chatMsg.Content, chatMsg.RoomID, chatMsg.Timestamp.Format(time.RFC3339), chatMsg.GroupID)),
+				Type:    "chatMessage",
+				Payload: json.RawMessage(chatPayload),
 			}
 
 			broadcastJSON, _ := json.Marshal(broadcastMessage)
 
 			// Broadcast the message to the room
-			if room, ok := client.wsServer.rooms[chatMsg.RoomID]; ok {
-				log.Printf("Broadcasting message to room: %s", chatMsg.RoomID)
+			if room, ok := client.wsServer.getRoom(chatMsg.RoomID); ok {
 				room.broadcastToClients(broadcastJSON)
 			}
 
-			log.Printf("Message broadcasted to room: %s", chatMsg.RoomID)
+			slog.DebugContext(client.ctx, "Message broadcast", "room_id", chatMsg.RoomID)
 
 		case "joinGroupChat":
 			var joinMsg struct {
 				GroupId string `json:"groupId"`
 			}
 			if err := json.Unmarshal(wsMessage.Payload, &joinMsg); err != nil {
-				log.Println("Error unmarshaling join group chat message:", err)
+				slog.ErrorContext(client.ctx, "Error unmarshaling join group chat message", "error", err)
 				continue
 			}
 
-			log.Printf("C requests to join group chat: %s", joinMsg.GroupId)
+			slog.DebugContext(client.ctx, "Joining group chat", "group_id", joinMsg.GroupId)
 			// Convert GroupId to int before passing
 			groupIdInt, err := strconv.Atoi(joinMsg.GroupId)
 			if err != nil {
-				log.Printf("Error converting GroupId to int: %v", err)
+				slog.ErrorContext(client.ctx, "Error converting GroupId to int", "error", err)
 				continue
 			}
 			roomId, err := client.wsServer.addToGroupChatRoom(db, client, groupIdInt)
 			if err != nil {
-				log.Printf("Error joining group chat room: %v", err)
+				slog.ErrorContext(client.ctx, "Error joining group chat room", "error", err)
 				// Optionally send an error message back to the client
 				// Ensure you construct and send a proper error response here if desired
 			} else {
@@ -522,11 +681,10 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 				}
 				responseJSON, err := json.Marshal(response)
 				if err != nil {
-					log.Println("Error marshaling join group chat response:", err)
+					slog.ErrorContext(client.ctx, "Error marshaling join group chat response", "error", err)
 					// Optionally handle the error, e.g., by logging or sending an error message to the client
 					continue
 				}
-				log.Printf("Sending joinGroupChatResponse: %s", string(responseJSON))
 				client.send <- responseJSON
 			}
 
@@ -536,15 +694,16 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 				GroupID *int   `json:"groupId,omitempty"` // Use pointer to detect if groupId was provided
 			}
 			if err := json.Unmarshal(wsMessage.Payload, &fetchRequest); err != nil {
-				log.Println("Error unmarshaling fetch messages request:", err)
+				slog.ErrorContext(client.ctx, "Error unmarshaling fetch messages request", "error", err)
 				continue
 			}
 
-			log.Printf("Received a fetch messages request: %+v", fetchRequest)
-
 			messages, err := FetchMessages(db, fetchRequest.RoomID, UserID, fetchRequest.GroupID)
-			if err != nil {
-				log.Println("Error fetching messages:", err)
+			if err == ErrNotParticipant {
+				slog.WarnContext(client.ctx, "Not allowed to read room", "room_id", fetchRequest.RoomID)
+				continue
+			} else if err != nil {
+				slog.ErrorContext(client.ctx, "Error fetching messages", "error", err)
 				continue
 			}
 
@@ -558,17 +717,107 @@ func (client *C) readPump(db *sql.DB, UserID int) {
 
 			responseJSON, err := json.Marshal(responsePayload)
 			if err != nil {
-				log.Println("Error marshaling fetch messages response:", err)
+				slog.ErrorContext(client.ctx, "Error marshaling fetch messages response", "error", err)
+				continue
+			}
+
+			client.send <- responseJSON
+
+		case "markRoomRead":
+			var readRequest struct {
+				RoomID  string `json:"roomId"`
+				GroupID *int   `json:"groupId,omitempty"`
+			}
+			if err := json.Unmarshal(wsMessage.Payload, &readRequest); err != nil {
+				slog.ErrorContext(client.ctx, "Error unmarshaling mark room read request", "error", err)
+				continue
+			}
+
+			if readRequest.GroupID != nil {
+				// Only members keep a read marker for a group room, the same as for posting in it
+				if _, err := model.GetChatRoomGroup(db, readRequest.RoomID, UserID); err != nil {
+					slog.WarnContext(client.ctx, "Not allowed to read room", "room_id", readRequest.RoomID, "error", err)
+					continue
+				}
+			}
+
+			if err := markRoomRead(db, readRequest.RoomID, UserID, readRequest.GroupID != nil); err != nil {
+				slog.ErrorContext(client.ctx, "Error marking room as read", "error", err)
+			}
+
+		case "messageRequestCheck":
+			requests, err := model.FetchMessageRequests(db, UserID)
+			if err != nil {
+				slog.ErrorContext(client.ctx, "Error fetching message requests", "error", err)
 				continue
 			}
 
+			responseJSON, err := newSockMessage("messageRequestResponse", requests)
+			if err != nil {
+				slog.ErrorContext(client.ctx, "Error marshaling message requests", "error", err)
+				continue
+			}
 			client.send <- responseJSON
 
+		case "acceptMessageRequest":
+			var acceptPayload struct {
+				SenderUserID int `json:"senderUserId"`
+			}
+			if err := json.Unmarshal(wsMessage.Payload, &acceptPayload); err != nil {
+				slog.ErrorContext(client.ctx, "Error unmarshaling accept message request", "error", err)
+				continue
+			}
+
+			// Only a pending request from a user neither side blocked opens a room with them
+			if err := model.CheckMessageRequest(db, UserID, acceptPayload.SenderUserID); err == model.ErrBlocked || err == model.ErrNoMessageRequest {
+				slog.WarnContext(client.ctx, "Refused to accept message request", "sender_user_id", acceptPayload.SenderUserID, "error", err)
+				continue
+			} else if err != nil {
+				slog.ErrorContext(client.ctx, "Error checking message request", "error", err)
+				continue
+			}
+
+			roomID, err := GetCreateRoom(db, UserID, acceptPayload.SenderUserID)
+			if err != nil {
+				slog.ErrorContext(client.ctx, "Error getting or creating room", "error", err)
+				continue
+			}
+
+			if err := model.AcceptMessageRequests(db, UserID, acceptPayload.SenderUserID, roomID); err != nil {
+				slog.ErrorContext(client.ctx, "Error accepting message requests", "error", err)
+				continue
+			}
+
+			client.wsServer.addUserToRoom(UserID, roomID)
+			client.wsServer.addUserToRoom(acceptPayload.SenderUserID, roomID)
+
+			if responseJSON, err := newSockMessage("messageRequestAccepted", map[string]interface{}{
+				"roomId":       roomID,
+				"senderUserId": acceptPayload.SenderUserID,
+				"userId":       UserID,
+			}); err == nil {
+				client.wsServer.sendToUser(UserID, responseJSON)
+				client.wsServer.sendToUser(acceptPayload.SenderUserID, responseJSON)
+			}
+
+		case "ignoreMessageRequest":
+			var ignorePayload struct {
+				SenderUserID int `json:"senderUserId"`
+			}
+			if err := json.Unmarshal(wsMessage.Payload, &ignorePayload); err != nil {
+				slog.ErrorContext(client.ctx, "Error unmarshaling ignore message request", "error", err)
+				continue
+			}
+
+			if err := model.IgnoreMessageRequests(db, UserID, ignorePayload.SenderUserID); err != nil {
+				slog.ErrorContext(client.ctx, "Error ignoring message requests", "error", err)
+			}
+
 		}
 	}
 }
 
-func saveMessage(db *sql.DB, message IncomingMessage) error {
+func saveMessage(db *sql.DB, message IncomingMessage) (string, error) {
 	var query string
 	var args []interface{}
 	messageID := uuid.New().String()
@@ -585,40 +834,60 @@ func saveMessage(db *sql.DB, message IncomingMessage) error {
 
 	_, err := db.Exec(query, args...)
 	if err != nil {
-		log.Println("Error saving message:", err)
-		return err
+		slog.Error("Error saving message", "error", err)
+		return "", err
 	}
-	return nil
+	chatMessagesSaved.Inc()
+	return messageID, nil
 }
 
+// FetchMessages returns the messages of a room the user is in, newest first, and marks the room read for them.
+// It returns ErrNotParticipant for rooms of other users and groups the user isn't an accepted member of.
 func FetchMessages(db *sql.DB, roomID string, userID int, groupID *int) ([]Message, error) {
 	var messages []Message
 	var query string
 
+	var err error
+	if groupID != nil {
+		var memberGroupID int
+		memberGroupID, err = model.GetChatRoomGroup(db, roomID, userID)
+		if err == nil && memberGroupID != *groupID {
+			err = sql.ErrNoRows
+		}
+	} else {
+		_, err = GetRoomPeer(db, roomID, userID)
+	}
+	if err == sql.ErrNoRows {
+		return nil, ErrNotParticipant
+	} else if err != nil {
+		return nil, err
+	}
+
 	if groupID != nil {
 		// Fetch from GroupChatMessage if groupId is provided
 		query = `
 			SELECT m.MessageID, m.RoomID, m.Content, m.Timestamp, 
-			s.UserID AS SenderUserID, s.FirstName AS SenderFirstName, s.LastName AS SenderLastName, s.Nickname AS SenderNickname
+			s.UserID AS SenderUserID, s.FirstName AS SenderFirstName, s.LastName AS SenderLastName, s.Nickname AS SenderNickname,
+			s.ProfilePicture AS SenderProfilePicture
 			FROM GroupChatMessage m
 			JOIN User s ON m.SenderUserID = s.UserID
-			WHERE m.RoomID = ? AND m.GroupID = ?
+			WHERE m.RoomID = ? AND m.GroupID = ? AND m.Hidden = FALSE
 			ORDER BY m.Timestamp DESC`
 	} else {
 		// Fetch from Message if groupId is not provided
 		query = `
 			SELECT m.MessageID, m.RoomID, m.Content, m.Timestamp, m.Read, 
 			s.UserID AS SenderUserID, s.FirstName AS SenderFirstName, s.LastName AS SenderLastName, s.Nickname AS SenderNickname,
+			s.ProfilePicture AS SenderProfilePicture,
 			r.UserID AS ReceiverUserID, r.FirstName AS ReceiverFirstName, r.LastName AS ReceiverLastName, r.Nickname AS ReceiverNickname
 			FROM Message m
 			JOIN User s ON m.SenderUserID = s.UserID
 			JOIN User r ON m.ReceiverUserID = r.UserID
-			WHERE m.RoomID = ?
+			WHERE m.RoomID = ? AND m.Hidden = FALSE
 			ORDER BY m.Timestamp DESC`
 	}
 
 	var rows *sql.Rows
-	var err error
 
 	if groupID != nil {
 		rows, err = db.Query(query, roomID, *groupID)
@@ -631,23 +900,29 @@ func FetchMessages(db *sql.DB, roomID string, userID int, groupID *int) ([]Messa
 	}
 	defer rows.Close()
 
+	attachments, err := model.GetRoomAttachments(db, roomID, "")
+	if err != nil {
+		return nil, err
+	}
+
 	for rows.Next() {
 		var message Message
 		// Adjust the Scan based on the query being executed
 		if groupID != nil {
 			err := rows.Scan(&message.MessageID, &message.RoomID, &message.Content, &message.Timestamp,
-				&message.SenderUserID, &message.SenderFirstName, &message.SenderLastName, &message.SenderNickname)
+				&message.SenderUserID, &message.SenderFirstName, &message.SenderLastName, &message.SenderNickname, &message.SenderProfilePicture)
 			if err != nil {
 				return nil, err
 			}
 		} else {
 			err := rows.Scan(&message.MessageID, &message.RoomID, &message.Content, &message.Timestamp, &message.Read,
-				&message.SenderUserID, &message.SenderFirstName, &message.SenderLastName, &message.SenderNickname,
+				&message.SenderUserID, &message.SenderFirstName, &message.SenderLastName, &message.SenderNickname, &message.SenderProfilePicture,
 				&message.ReceiverUserID, &message.ReceiverFirstName, &message.ReceiverLastName, &message.ReceiverNickname)
 			if err != nil {
 				return nil, err
 			}
 		}
+		message.Attachments = attachments[message.MessageID]
 		messages = append(messages, message)
 	}
 
@@ -655,15 +930,30 @@ func FetchMessages(db *sql.DB, roomID string, userID int, groupID *int) ([]Messa
 		return nil, err
 	}
 
-	updateQuery := `UPDATE Message SET Read = TRUE WHERE RoomID = ? AND ReceiverUserID = ? AND Read = FALSE`
-	_, err = db.Exec(updateQuery, roomID, userID)
-	if err != nil {
+	if err = markRoomRead(db, roomID, userID, groupID != nil); err != nil {
 		return nil, err
 	}
 
 	return messages, nil
 }
 
+// markRoomRead marks every message in the room as read for the user. Private messages carry their own
+// read flag, group chats keep a per-user read marker in GroupChatRead.
+func markRoomRead(db *sql.DB, roomID string, userID int, isGroup bool) error {
+	if !isGroup {
+		updateQuery := `UPDATE Message SET Read = TRUE WHERE RoomID = ? AND ReceiverUserID = ? AND Read = FALSE`
+		_, err := db.Exec(updateQuery, roomID, userID)
+		return err
+	}
+
+	upsertQuery := `
+	INSERT INTO GroupChatRead (RoomID, UserID, LastReadAt)
+	VALUES (?, ?, IFNULL((SELECT MAX(Timestamp) FROM GroupChatMessage WHERE RoomID = ?), CURRENT_TIMESTAMP))
+	ON CONFLICT(RoomID, UserID) DO UPDATE SET LastReadAt = excluded.LastReadAt`
+	_, err := db.Exec(upsertQuery, roomID, userID, roomID)
+	return err
+}
+
 func CheckEventInvite(db *sql.DB, userID int) ([]EvResponseNotification, error) {
 	var notifications []EvResponseNotification
 	query := `
@@ -727,7 +1017,7 @@ func CheckGroupInvites(db *sql.DB, userID int) ([]GroupInvite, error) {
 
 	rows, err := db.Query(query, userID)
 	if err != nil {
-		log.Printf("Error querying group invites: %v", err)
+		slog.Error("Error querying group invites", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -736,7 +1026,7 @@ func CheckGroupInvites(db *sql.DB, userID int) ([]GroupInvite, error) {
 		var invite GroupInvite
 		var firstName, lastName string
 		if err := rows.Scan(&invite.GroupID, &invite.Name, &invite.Description, &invite.CreatorUserID, &firstName, &lastName); err != nil {
-			log.Printf("Error scanning group invite: %v", err)
+			slog.Error("Error scanning group invite", "error", err)
 			continue
 		}
 		invite.CreatorName = firstName + " " + lastName // Concatenate first name and last name
@@ -751,14 +1041,14 @@ func HandleGroupInviteResponse(db *sql.DB, userID, groupID int, accept bool) err
 		// If the user accepted the invitation, add them to the GroupMembers table
 		_, err := db.Exec(`INSERT INTO GroupMembers (GroupID, UserID, Accepted) VALUES (?, ?, TRUE)`, groupID, userID)
 		if err != nil {
-			log.Printf("Error adding user to GroupMembers: %v", err)
+			slog.Error("Error adding user to GroupMembers", "error", err)
 			return err
 		}
 	}
 	// Remove the invitation from InvitedUsers regardless of accept or decline
 	_, err := db.Exec(`DELETE FROM InvitedUsers WHERE GroupID = ? AND UserID = ?`, groupID, userID)
 	if err != nil {
-		log.Printf("Error removing invitation: %v", err)
+		slog.Error("Error removing invitation", "error", err)
 		return err
 	}
 
@@ -775,7 +1065,7 @@ func FetchFollowRequests(db *sql.DB, userId int) ([]FollowRequest, error) {
 	`
 	rows, err := db.Query(query, userId)
 	if err != nil {
-		log.Printf("Error executing follow requests fetch query: %v", err)
+		slog.Error("Error executing follow requests fetch query", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -783,28 +1073,21 @@ func FetchFollowRequests(db *sql.DB, userId int) ([]FollowRequest, error) {
 	for rows.Next() {
 		var req FollowRequest
 		if err := rows.Scan(&req.FollowerUserID, &req.FirstName, &req.LastName); err != nil {
-			log.Printf("Error scanning follow request: %v", err)
+			slog.Error("Error scanning follow request", "error", err)
 			continue // or return nil, err if you want to stop processing on first error
 		}
 		requests = append(requests, req)
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Printf("Error iterating follow requests rows: %v", err)
+		slog.Error("Error iterating follow requests rows", "error", err)
 		return nil, err
 	}
 
-	log.Printf("%d follow requests fetched for user %d", len(requests), userId)
-
-	// Log marshalled JSON for debugging purposes
-	if marshalledJSON, err := json.Marshal(requests); err != nil {
-		log.Printf("Error marshalling follow requests to JSON: %v", err)
-	} else {
-		log.Printf("Marshalled follow requests JSON: %s", string(marshalledJSON))
-	}
+	slog.Debug("Follow requests fetched", "user_id", userId, "count", len(requests))
 
 	if len(requests) == 0 {
-		log.Printf("No follow requests found for user %d", userId)
+		slog.Debug("No follow requests found", "user_id", userId)
 		// You might choose to return an empty slice instead of nil to explicitly indicate no results found
 		return requests, nil
 	}
@@ -813,6 +1096,14 @@ func FetchFollowRequests(db *sql.DB, userId int) ([]FollowRequest, error) {
 }
 
 func AcceptFollowRequest(db *sql.DB, followerUserID, followingUserID int) error {
+	blocked, err := model.IsBlocked(db, followerUserID, followingUserID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return model.ErrBlocked
+	}
+
 	// Start a transaction
 	tx, err := db.Begin()
 	if err != nil {
@@ -844,13 +1135,21 @@ func AcceptFollowRequest(db *sql.DB, followerUserID, followingUserID int) error
 }
 
 func SaveFollowRequest(db *sql.DB, followerUserID, followingUserID int) error {
-	_, err := db.Exec(`
+	blocked, err := model.IsBlocked(db, followerUserID, followingUserID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return model.ErrBlocked
+	}
+
+	_, err = db.Exec(`
 			INSERT INTO FollowRequests (FollowerUserID, FollowingUserID)
 			VALUES (?, ?)
 	`, followerUserID, followingUserID)
 
 	if err != nil {
-		log.Printf("Error saving follow request: %v", err)
+		slog.Error("Error saving follow request", "error", err)
 		return err
 	}
 	return nil
@@ -860,7 +1159,7 @@ func RemoveFollowRequest(db *sql.DB, requesterUserId, targetUserId int) error {
 	_, err := db.Exec("DELETE FROM FollowRequests WHERE FollowerUserID = ? AND FollowingUserID = ?", requesterUserId, targetUserId)
 
 	if err != nil {
-		log.Printf("Error saving follow request: %v", err)
+		slog.Error("Error saving follow request", "error", err)
 		return err
 	}
 	return err
@@ -966,10 +1265,18 @@ func (client *C) writePump() {
 			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+		case <-client.done:
+			return
 		}
 	}
 }
 
+// close sends the client a close frame with the code and reason, and closes the connection
+func (client *C) close(code int, reason string, deadline time.Time) {
+	client.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+	client.conn.Close()
+}
+
 func (client *C) disconnect() {
 	if client == nil {
 		return
@@ -979,7 +1286,10 @@ func (client *C) disconnect() {
 		delete(client.room.Clients, client)
 	}
 	if client.wsServer != nil {
-		client.wsServer.unregister <- client
+		select {
+		case client.wsServer.unregister <- client:
+		case <-client.wsServer.quit:
+		}
 	}
 	close(client.send)
 	if client.conn != nil {
@@ -989,61 +1299,92 @@ func (client *C) disconnect() {
 
 // ServeWs handles websocket requests from clients requests.
 func ServeWs(db *sql.DB, wsServer *WSServer, w http.ResponseWriter, r *http.Request) {
-	log.Println("ServeWs called")
-
 	sessionID, err := r.Cookie("session_id")
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		log.Println("Failed to get session ID from cookie")
+		apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	log.Println("Got session ID from cookie")
 
 	userID, err := model.GetUserIDBySessionID(db, sessionID.Value)
 	if err != nil {
-		log.Println("Failed to get user ID by session ID:", err)
+		slog.InfoContext(r.Context(), "Websocket refused without a valid session", "error", err)
+		apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// The connection outlives the request, but keeps its ID in the logs
+	ctx := logging.With(context.WithoutCancel(r.Context()), "conn_id", uuid.New().String(), "user_id", userID)
+
+	suspended, err := model.IsUserSuspended(db, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error checking suspension", "error", err)
+		apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if suspended {
+		apierror.HTTPError(w, "Account suspended", http.StatusForbidden)
 		return
 	}
-	log.Printf("Mapped session ID to user ID: %d", userID)
 
 	// Fetch user relations
 	userRelations, err := model.GetUserFollowRelations(db, userID)
 	if err != nil {
-		log.Printf("Error retrieving user relations: %v", err)
+		slog.ErrorContext(ctx, "Error retrieving user relations", "error", err)
 		return
 	}
 
 	followingMap, followersMap, err := model.GetFollowRelationships(db)
 	if err != nil {
-		log.Printf("Error retrieving follow relationships: %v", err)
+		slog.ErrorContext(ctx, "Error retrieving follow relationships", "error", err)
 		return
 	}
 
 	pendingRequests, err := model.GetPendingFollowRequests(db, userID)
 	if err != nil {
-		log.Printf("Error retrieving pending requests: %v", err)
+		slog.ErrorContext(ctx, "Error retrieving pending requests", "error", err)
 		return
 	}
 
 	userGroups, err := model.GetUserGroupMemberships(db, userID)
 	if err != nil {
-		log.Printf("Error retrieving user groups: %v", err)
+		slog.ErrorContext(ctx, "Error retrieving user groups", "error", err)
 		return
 	}
 
 	groupJoinRequests, err := model.GetUserGroupJoinRequests(db, userID)
 	if err != nil {
-		log.Printf("Error retrieving group join requests: %v", err)
+		slog.ErrorContext(ctx, "Error retrieving group join requests", "error", err)
+		return
+	}
+
+	groupRelations, err := model.GetUserGroupChatRelations(db, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error retrieving group chat relations", "error", err)
 		return
 	}
 
 	updatedRelations := make(map[int]URelation)
 	for relatedUserID, relation := range userRelations {
-		roomID, err := GetCreateRoom(db, userID, relatedUserID)
+		canMessage, err := model.CanDirectMessage(db, userID, relatedUserID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error checking direct message policy", "peer_id", relatedUserID, "error", err)
+			continue
+		}
+		canReceive, err := model.CanDirectMessage(db, relatedUserID, userID)
 		if err != nil {
-			log.Printf("Error getting or creating room for users %d and %d: %v", userID, relatedUserID, err)
+			slog.ErrorContext(ctx, "Error checking direct message policy", "peer_id", relatedUserID, "error", err)
 			continue
 		}
+
+		// Only create a room when at least one side is allowed to write
+		roomID := relation.RoomID
+		if roomID == "" && (canMessage || canReceive) {
+			roomID, err = GetCreateRoom(db, userID, relatedUserID)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error getting or creating room", "peer_id", relatedUserID, "error", err)
+				continue
+			}
+		}
 		updatedRelations[relatedUserID] = URelation{
 			Nickname:       relation.Nickname,
 			FirstName:      relation.FirstName,
@@ -1052,25 +1393,30 @@ func ServeWs(db *sql.DB, wsServer *WSServer, w http.ResponseWriter, r *http.Requ
 			UserID:         relatedUserID,
 			UnreadCount:    relation.UnreadCount,
 			ProfilePicture: relation.ProfilePicture,
+			CanMessage:     canMessage,
 		}
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := wsServer.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
+		slog.WarnContext(ctx, "Websocket upgrade failed", "error", err)
 		return
 	}
 
-	client := newClient(conn, wsServer)
+	client := newClient(ctx, conn, wsServer, userID)
 
 	// Add the user to each room related to their follow relations
 	for _, relation := range updatedRelations {
+		if relation.RoomID == "" {
+			continue
+		}
 		wsServer.addToR(client, relation.RoomID)
-		log.Printf("User %d connected to room %s", userID, relation.RoomID)
+		slog.DebugContext(ctx, "Joined room", "room_id", relation.RoomID)
 	}
 	// Wrap the relations data in an object with 'followRelations' key
 	initialDataWrapper := map[string]interface{}{
 		"userRelations":     updatedRelations,
+		"groupRelations":    groupRelations,
 		"followingMap":      followingMap,
 		"followersMap":      followersMap,
 		"pendingRequests":   pendingRequests,
@@ -1079,7 +1425,7 @@ func ServeWs(db *sql.DB, wsServer *WSServer, w http.ResponseWriter, r *http.Requ
 	}
 	initialData, err := json.Marshal(initialDataWrapper)
 	if err != nil {
-		log.Println("Error marshaling initial data:", err)
+		slog.ErrorContext(ctx, "Error marshaling initial data", "error", err)
 		return
 	}
 
@@ -1090,5 +1436,9 @@ func ServeWs(db *sql.DB, wsServer *WSServer, w http.ResponseWriter, r *http.Requ
 	go client.readPump(db, userID)
 	go client.handleBufferedMessages()
 
-	wsServer.register <- client
+	select {
+	case wsServer.register <- client:
+	case <-wsServer.quit:
+		client.close(websocket.CloseGoingAway, "server shutting down", time.Now().Add(writeWait))
+	}
 }
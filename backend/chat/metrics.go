@@ -0,0 +1,39 @@
+package chat
+
+import "social-network/backend/metrics"
+
+var (
+	messagesReceived = metrics.NewCounter("websocket_messages_received_total",
+		"Websocket messages received from clients, including the ones over the rate limit.")
+	chatMessagesSaved = metrics.NewCounter("chat_messages_total",
+		"Private and group chat messages saved.")
+	sendBufferOverflows = metrics.NewCounter("websocket_send_buffer_overflows_total",
+		"Room broadcasts that found a client's send queue full and went to its buffer instead.")
+)
+
+// ClientCount returns the number of open websocket connections
+func (server *WSServer) ClientCount() int {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+	return len(server.clients)
+}
+
+// ActiveRoomCount returns the number of chat rooms with a client in them
+func (server *WSServer) ActiveRoomCount() int {
+	server.mutex.RLock()
+	rooms := make([]*Room, 0, len(server.rooms))
+	for _, room := range server.rooms {
+		rooms = append(rooms, room)
+	}
+	server.mutex.RUnlock()
+
+	count := 0
+	for _, room := range rooms {
+		room.mutex.Lock()
+		if len(room.Clients) > 0 {
+			count++
+		}
+		room.mutex.Unlock()
+	}
+	return count
+}
@@ -0,0 +1,48 @@
+package chat
+
+import (
+	"database/sql"
+	"log/slog"
+
+	"social-network/backend/model"
+)
+
+// PushPollUpdate sends the current tallies of a poll to every connected user that can see its post,
+// each with the results as they may see them. The tallies are loaded once for all of them.
+func (server *WSServer) PushPollUpdate(db *sql.DB, pollID int) {
+	server.mutex.RLock()
+	userIDs := map[int]bool{}
+	for client := range server.clients {
+		userIDs[client.userID] = true
+	}
+	server.mutex.RUnlock()
+	if len(userIDs) == 0 {
+		return
+	}
+
+	results, err := model.GetPollResults(db, pollID)
+	if err != nil {
+		if err != model.ErrPollNotFound {
+			slog.Error("Error fetching poll", "poll_id", pollID, "error", err)
+		}
+		return
+	}
+
+	for userID := range userIDs {
+		poll, err := model.ViewPoll(db, results, userID)
+		if err != nil {
+			slog.Error("Error fetching poll", "poll_id", pollID, "user_id", userID, "error", err)
+			continue
+		}
+		if poll == nil {
+			continue
+		}
+
+		message, err := newSockMessage("pollUpdate", poll)
+		if err != nil {
+			slog.Error("Error marshaling poll update", "error", err)
+			continue
+		}
+		server.sendToUser(userID, message)
+	}
+}
@@ -2,12 +2,16 @@ package chat
 
 import (
 	"database/sql"
-	"log"
+	"errors"
+	"log/slog"
 	"sync"
 
 	"github.com/google/uuid"
 )
 
+// ErrNotParticipant is returned when the user isn't in the private room or a member of the room's group
+var ErrNotParticipant = errors.New("not a participant of the room")
+
 type Room struct {
 	ID      string
 	Clients map[*C]bool
@@ -30,7 +34,7 @@ func GetCreateRoom(db *sql.DB, user1ID, user2ID int) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		log.Printf("Created new room with ID: %s", roomID)
+		slog.Debug("Created room", "room_id", roomID)
 	} else if err != nil {
 		// An error occurred
 		return "", err
@@ -39,16 +43,29 @@ func GetCreateRoom(db *sql.DB, user1ID, user2ID int) (string, error) {
 	return roomID, nil
 }
 
+// GetRoomPeer returns the other participant of a private room the user belongs to
+func GetRoomPeer(db *sql.DB, roomID string, userID int) (int, error) {
+	var peerID int
+	query := `SELECT CASE WHEN User1ID = ? THEN User2ID ELSE User1ID END FROM Rooms WHERE RoomID = ? AND (User1ID = ? OR User2ID = ?)`
+	err := db.QueryRow(query, userID, roomID, userID, userID).Scan(&peerID)
+	if err != nil {
+		return 0, err
+	}
+	return peerID, nil
+}
+
 func (server *WSServer) addToR(client *C, roomID string) {
 	room := server.findCreateRoom(roomID)
 	room.mutex.Lock() // Lock the mutex
 	room.Clients[client] = true
 	room.mutex.Unlock() // Unlock the mutex
 	client.room = room
-	log.Printf("Added client to room: %s", roomID)
+	slog.Debug("Added client to room", "room_id", roomID)
 }
 
 func (server *WSServer) findCreateRoom(roomID string) *Room {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
 	if room, ok := server.rooms[roomID]; ok {
 		return room
 	}
@@ -61,6 +78,14 @@ func (server *WSServer) findCreateRoom(roomID string) *Room {
 	return newRoom
 }
 
+// getRoom returns the active room with the given ID, if any
+func (server *WSServer) getRoom(roomID string) (*Room, bool) {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+	room, ok := server.rooms[roomID]
+	return room, ok
+}
+
 // In room.go
 func GetCreateGrChatRoom(db *sql.DB, groupId int) (string, error) {
 	var roomId string
@@ -88,7 +113,7 @@ func GetCreateGrChatRoom(db *sql.DB, groupId int) (string, error) {
 func (server *WSServer) addToGroupChatRoom(db *sql.DB, client *C, groupId int) (string, error) {
 	roomId, err := GetCreateGrChatRoom(db, groupId)
 	if err != nil {
-		log.Printf("Error getting or creating group chat room: %v", err)
+		slog.Error("Error getting or creating group chat room", "error", err)
 		return "", err // return an empty roomId and the error
 	}
 	server.addToR(client, roomId) // Assuming addToR can handle both private and group chats
@@ -1,7 +1,15 @@
 package chat
 
 import (
+	"context"
+	"log/slog"
+	"sync"
 	"time"
+
+	"social-network/backend/auth"
+	"social-network/backend/ratelimit"
+
+	"github.com/gorilla/websocket"
 )
 
 type WSServer struct {
@@ -10,21 +18,31 @@ type WSServer struct {
 	unregister chan *C
 	broadcast  chan []byte
 	rooms      map[string]*Room
+	mutex      sync.RWMutex
+	limits     *ratelimit.Limiters
+	upgrader   websocket.Upgrader
+	quit       chan struct{} // closed by Shutdown
+	stopped    chan struct{} // closed when Run returns
 }
 
 // NewWSServer creates a new WSServer type
-func NewWSServer() *WSServer {
+func NewWSServer(limits *ratelimit.Limiters, security auth.SecurityConfig) *WSServer {
 	return &WSServer{
 		clients:    make(map[*C]bool),
 		register:   make(chan *C),
 		unregister: make(chan *C),
 		broadcast:  make(chan []byte),
 		rooms:      make(map[string]*Room),
+		limits:     limits,
+		upgrader:   newUpgrader(security),
+		quit:       make(chan struct{}),
+		stopped:    make(chan struct{}),
 	}
 }
 
-// Run our websocket server, accepting various requests
+// Run our websocket server, accepting various requests, until Shutdown
 func (server *WSServer) Run() {
+	defer close(server.stopped)
 	for {
 		select {
 
@@ -33,21 +51,87 @@ func (server *WSServer) Run() {
 
 		case client := <-server.unregister:
 			server.unregisterClient(client)
+
+		case <-server.quit:
+			return
 		}
 
 	}
 }
 
+// Shutdown stops Run and sends every client a close frame before closing its connection, giving up on clients that
+// don't take the frame by the deadline of ctx. Connections opened after it are closed right away.
+func (server *WSServer) Shutdown(ctx context.Context) {
+	close(server.quit)
+	select {
+	case <-server.stopped:
+	case <-ctx.Done():
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(writeWait)
+	}
+
+	server.mutex.RLock()
+	clients := make([]*C, 0, len(server.clients))
+	for client := range server.clients {
+		clients = append(clients, client)
+	}
+	server.mutex.RUnlock()
+
+	for _, client := range clients {
+		client.close(websocket.CloseGoingAway, "server shutting down", deadline)
+	}
+	slog.Info("Closed websocket connections", "count", len(clients))
+}
+
 func (server *WSServer) registerClient(client *C) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
 	server.clients[client] = true
 }
 
 func (server *WSServer) unregisterClient(client *C) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
 	if _, ok := server.clients[client]; ok {
 		delete(server.clients, client)
 	}
 }
 
+// clientsForUser returns every open connection of the user
+func (server *WSServer) clientsForUser(userID int) []*C {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+
+	var clients []*C
+	for client := range server.clients {
+		if client.userID == userID {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// sendToUser delivers the message to every open connection of the user
+func (server *WSServer) sendToUser(userID int, message []byte) {
+	for _, client := range server.clientsForUser(userID) {
+		select {
+		case client.send <- message:
+		default:
+			slog.Warn("Send queue full, dropping message", "user_id", userID)
+		}
+	}
+}
+
+// addUserToRoom adds every open connection of the user to the room
+func (server *WSServer) addUserToRoom(userID int, roomID string) {
+	for _, client := range server.clientsForUser(userID) {
+		server.addToR(client, roomID)
+	}
+}
+
 func (room *Room) broadcastToClients(message []byte) {
 	room.mutex.Lock()         // Lock the mutex
 	defer room.mutex.Unlock() // Unlock the mutex when the function exits
@@ -58,6 +142,7 @@ func (room *Room) broadcastToClients(message []byte) {
 			// Message sent successfully
 		default:
 			// Message queue is full, buffer the message
+			sendBufferOverflows.Inc()
 			client.sendBuffer = append(client.sendBuffer, message)
 		}
 	}
@@ -67,7 +152,13 @@ func (client *C) handleBufferedMessages() {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-client.done:
+			return
+		}
+
 		// Try to send buffered messages
 		for len(client.sendBuffer) > 0 {
 			select {
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"social-network/backend/auth"
+	"social-network/backend/logging"
+	"social-network/backend/mail"
+	"social-network/backend/ratelimit"
+)
+
+// Config is everything the server needs to run. Several instances, like dev, test and production, can run side by
+// side with a config file each.
+type Config struct {
+	Addr       string // address to listen on, like :8091
+	AppURL     string // where users reach the app, for links in e-mails
+	DBPath     string
+	SchemaPath string // SQL run on startup to create the tables
+	StaticDir  string // the built frontend
+
+	// Numbered SQL files changing the tables, which the migrate command applies
+	MigrationsDir string
+
+	// Uploads go to the Google Cloud Storage bucket, with the service account key in CredentialsFile
+	Bucket          string
+	CredentialsFile string
+
+	SessionTTL      time.Duration // sessions expire after this long without requests
+	CleanupInterval time.Duration // how often expired sessions, tokens and challenges are deleted
+
+	// Timeouts of the HTTP server; websocket connections aren't held to the read and write timeouts
+	ReadTimeout  time.Duration // reading a whole request, uploads included
+	WriteTimeout time.Duration // from the end of the request headers to the end of the response
+	IdleTimeout  time.Duration // keep-alive connections between requests
+	// ShutdownTimeout is how long the server waits on requests, websocket clients and background jobs when it stops
+	ShutdownTimeout time.Duration
+
+	// MetricsToken is the bearer token Prometheus scrapes /metrics with; without one /metrics isn't served
+	MetricsToken string
+
+	Log       logging.Config
+	Mail      mail.Config
+	RateLimit ratelimit.Config
+	Security  auth.SecurityConfig
+}
+
+// Default returns the config of a development instance
+func Default() Config {
+	return Config{
+		Addr:            ":8091",
+		AppURL:          "http://localhost:8091",
+		DBPath:          "datab.db",
+		SchemaPath:      "./datab/table.sql",
+		MigrationsDir:   "./datab/migrations",
+		StaticDir:       "frontend/dist",
+		Bucket:          "social-network-bucket",
+		CredentialsFile: "datab/private/social-network-KEY.json",
+		SessionTTL:      45 * time.Minute,
+		CleanupInterval: 10 * time.Minute,
+		ReadTimeout:     time.Minute,
+		WriteTimeout:    time.Minute,
+		IdleTimeout:     2 * time.Minute,
+		ShutdownTimeout: 15 * time.Second,
+		Log:             logging.DefaultConfig(),
+		RateLimit:       ratelimit.DefaultConfig(),
+		Security:        auth.DefaultSecurityConfig(),
+	}
+}
+
+// Load returns the default config with the variables of the file and then the environment set, and checks it.
+// The file has a NAME=value line for each variable, like an env file; it is skipped when path is empty.
+func Load(path string) (Config, error) {
+	file := map[string]string{}
+	if path != "" {
+		var err error
+		if file, err = readFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	// The environment wins over the file
+	getenv := func(name string) string {
+		if value, ok := os.LookupEnv(name); ok {
+			return value
+		}
+		return file[name]
+	}
+
+	return From(getenv)
+}
+
+// From returns the default config with the variables looked up by getenv set, and checks it
+func From(getenv func(string) string) (Config, error) {
+	config := Default()
+
+	values := map[string]*string{
+		"DB_PATH":          &config.DBPath,
+		"SCHEMA_PATH":      &config.SchemaPath,
+		"MIGRATIONS_DIR":   &config.MigrationsDir,
+		"STATIC_DIR":       &config.StaticDir,
+		"STORAGE_BUCKET":   &config.Bucket,
+		"STORAGE_KEY_FILE": &config.CredentialsFile,
+		"MAIL_DIR":         &config.Mail.Dir,
+		"SMTP_HOST":        &config.Mail.SMTPHost,
+		"SMTP_PORT":        &config.Mail.SMTPPort,
+		"SMTP_USERNAME":    &config.Mail.SMTPUsername,
+		"SMTP_PASSWORD":    &config.Mail.SMTPPassword,
+		"SMTP_FROM":        &config.Mail.From,
+		"METRICS_TOKEN":    &config.MetricsToken,
+	}
+	for name, s := range values {
+		if value := getenv(name); value != "" {
+			*s = value
+		}
+	}
+
+	config.Mail.LogBodies = getenv("MAIL_LOG_BODIES") == "true"
+
+	if port := getenv("PORT"); port != "" {
+		config.Addr = ":" + port
+	}
+	if addr := getenv("ADDR"); addr != "" {
+		config.Addr = addr
+	}
+	if appURL := getenv("APP_URL"); appURL != "" {
+		config.AppURL = strings.TrimRight(appURL, "/")
+	} else if _, port, err := net.SplitHostPort(config.Addr); err == nil && port != "" {
+		config.AppURL = "http://localhost:" + port
+	}
+
+	durations := map[string]*time.Duration{
+		"SESSION_TTL":      &config.SessionTTL,
+		"CLEANUP_INTERVAL": &config.CleanupInterval,
+		"READ_TIMEOUT":     &config.ReadTimeout,
+		"WRITE_TIMEOUT":    &config.WriteTimeout,
+		"IDLE_TIMEOUT":     &config.IdleTimeout,
+		"SHUTDOWN_TIMEOUT": &config.ShutdownTimeout,
+	}
+	for name, d := range durations {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return config, fmt.Errorf("%s: %q is not a duration", name, value)
+		}
+		*d = parsed
+	}
+
+	if level := getenv("LOG_LEVEL"); level != "" {
+		parsed, err := logging.ParseLevel(level)
+		if err != nil {
+			return config, fmt.Errorf("LOG_LEVEL: %v", err)
+		}
+		config.Log.Level = parsed
+	}
+	switch format := getenv("LOG_FORMAT"); format {
+	case "":
+	case "text", "json":
+		config.Log.Format = format
+	default:
+		return config, fmt.Errorf("LOG_FORMAT: %q is not text or json", format)
+	}
+
+	var err error
+	if config.RateLimit, err = ratelimit.ConfigFrom(getenv); err != nil {
+		return config, err
+	}
+	if config.Security, err = auth.SecurityConfigFrom(getenv); err != nil {
+		return config, err
+	}
+
+	return config, config.Validate()
+}
+
+// Validate reports the first setting the server can't run with
+func (c Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("ADDR: %q is not an address like :8091", c.Addr)
+	}
+	if u, err := url.Parse(c.AppURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("APP_URL: %q is not a URL like https://example.com", c.AppURL)
+	}
+	if c.DBPath == "" || c.SchemaPath == "" || c.StaticDir == "" {
+		return fmt.Errorf("DB_PATH, SCHEMA_PATH and STATIC_DIR can't be empty")
+	}
+	if c.Bucket == "" {
+		return fmt.Errorf("STORAGE_BUCKET can't be empty")
+	}
+	if c.SessionTTL < time.Minute {
+		return fmt.Errorf("SESSION_TTL: %v is shorter than a minute", c.SessionTTL)
+	}
+	positive := map[string]time.Duration{
+		"CLEANUP_INTERVAL": c.CleanupInterval,
+		"READ_TIMEOUT":     c.ReadTimeout,
+		"WRITE_TIMEOUT":    c.WriteTimeout,
+		"IDLE_TIMEOUT":     c.IdleTimeout,
+		"SHUTDOWN_TIMEOUT": c.ShutdownTimeout,
+	}
+	for name, d := range positive {
+		if d <= 0 {
+			return fmt.Errorf("%s: %v is not positive", name, d)
+		}
+	}
+	if c.Mail.SMTPHost != "" && c.Mail.From == "" {
+		return fmt.Errorf("SMTP_HOST needs SMTP_FROM")
+	}
+	return nil
+}
+
+// readFile reads the NAME=value lines of a config file. Blank lines and lines starting with # are skipped, and
+// values may be quoted.
+func readFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %v", err)
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("%s:%d: %q is not NAME=value", path, line, text)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(name)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("config file: %v", err)
+	}
+	return values, nil
+}
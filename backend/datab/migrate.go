@@ -0,0 +1,188 @@
+package datab
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNoMigrationHistory means the datab has tables from the migrations but no record of which ones ran, because
+// they were applied by hand. BaselineMigrations records them.
+var ErrNoMigrationHistory = errors.New("the datab has no record of the migrations applied to it")
+
+// Migration is a numbered SQL file of the migrations directory, like 0012_create_polls_tables.sql
+type Migration struct {
+	Version   string // the number the file name starts with
+	Name      string
+	Path      string
+	AppliedAt *time.Time // nil while pending
+}
+
+const createSchemaMigrations = `CREATE TABLE IF NOT EXISTS SchemaMigrations (
+	Version TEXT PRIMARY KEY,
+	AppliedAt DATETIME DEFAULT CURRENT_TIMESTAMP
+)`
+
+// Migrations lists the migrations of the directory in order, with when each was applied to the datab
+func Migrations(db *sql.DB, migrationsPath string) ([]Migration, error) {
+	files, err := filepath.Glob(filepath.Join(migrationsPath, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	applied, err := appliedMigrations(db)
+	if err != nil && err != ErrNoMigrationHistory {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(files))
+	for _, file := range files {
+		name := filepath.Base(file)
+		version, _, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s isn't named like 0001_description.sql", name)
+		}
+		migration := Migration{Version: version, Name: name, Path: file}
+		if appliedAt, ok := applied[version]; ok {
+			migration.AppliedAt = &appliedAt
+		}
+		migrations = append(migrations, migration)
+	}
+	return migrations, err
+}
+
+// appliedMigrations returns when each recorded migration was applied, or ErrNoMigrationHistory if the datab
+// has migrated tables but no record of them. An empty SchemaMigrations table, from running its migration by
+// hand, records nothing either.
+func appliedMigrations(db *sql.DB) (map[string]time.Time, error) {
+	var tracked, migrated bool
+	err := db.QueryRow(`SELECT
+	EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'SchemaMigrations'),
+	EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'Sessions')`).Scan(&tracked, &migrated)
+	if err != nil {
+		return nil, err
+	}
+	if tracked && migrated {
+		if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM SchemaMigrations)`).Scan(&tracked); err != nil {
+			return nil, err
+		}
+	}
+	if !tracked {
+		if migrated {
+			return nil, ErrNoMigrationHistory
+		}
+		return map[string]time.Time{}, nil
+	}
+
+	rows, err := db.Query(`SELECT Version, AppliedAt FROM SchemaMigrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[string]time.Time{}
+	for rows.Next() {
+		var version string
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// Migrate applies the pending migrations in order, each in a transaction with its record, and returns the
+// ones it applied. It stops at the first that fails.
+func Migrate(db *sql.DB, migrationsPath string) ([]Migration, error) {
+	migrations, err := Migrations(db, migrationsPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(createSchemaMigrations); err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, migration := range migrations {
+		if migration.AppliedAt != nil {
+			continue
+		}
+		if err := applyMigration(db, migration); err != nil {
+			if strings.Contains(err.Error(), "no such module: fts5") {
+				return applied, fmt.Errorf("migration %s: %w; build with -tags sqlite_fts5", migration.Name, err)
+			}
+			return applied, fmt.Errorf("migration %s: %w", migration.Name, err)
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+func applyMigration(db *sql.DB, migration Migration) error {
+	query, err := os.ReadFile(migration.Path)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(string(query)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO SchemaMigrations (Version) VALUES (?)`, migration.Version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// BaselineMigrations records the migrations up to and including version as applied without running them, for
+// a datab they were applied to by hand
+func BaselineMigrations(db *sql.DB, migrationsPath, version string) (int, error) {
+	files, err := filepath.Glob(filepath.Join(migrationsPath, version+"_*.sql"))
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		return 0, fmt.Errorf("no migration %s in %s", version, migrationsPath)
+	}
+
+	migrations, err := Migrations(db, migrationsPath)
+	if err != nil && err != ErrNoMigrationHistory {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(createSchemaMigrations); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	recorded := 0
+	for _, migration := range migrations {
+		if migration.Version > version {
+			break
+		}
+		if migration.AppliedAt != nil {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO SchemaMigrations (Version) VALUES (?)`, migration.Version); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		recorded++
+	}
+	return recorded, tx.Commit()
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS GroupChatRead (
+  RoomID VARCHAR(36) NOT NULL,
+  UserID INTEGER NOT NULL,
+  LastReadAt DATETIME,
+  PRIMARY KEY (RoomID, UserID),
+  FOREIGN KEY (RoomID) REFERENCES GroupChatRoom(RoomID),
+  FOREIGN KEY (UserID) REFERENCES User(UserID)
+);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS ChatAttachment (
+  AttachmentID VARCHAR(36) PRIMARY KEY,
+  UploaderUserID INTEGER NOT NULL,
+  RoomID VARCHAR(36) NOT NULL,
+  MessageID VARCHAR(36),
+  ObjectName VARCHAR(255) NOT NULL,
+  ThumbnailObjectName VARCHAR(255),
+  FileName VARCHAR(255),
+  MimeType VARCHAR(255),
+  Size INTEGER,
+  Width INTEGER,
+  Height INTEGER,
+  CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (UploaderUserID) REFERENCES User(UserID)
+);
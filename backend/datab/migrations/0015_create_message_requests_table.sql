@@ -0,0 +1,20 @@
+ALTER TABLE User ADD COLUMN DMPolicy VARCHAR(255) DEFAULT 'everyone';
+
+CREATE TABLE IF NOT EXISTS MessageRequests (
+  RequestID INTEGER PRIMARY KEY AUTOINCREMENT,
+  SenderUserID INTEGER NOT NULL,
+  ReceiverUserID INTEGER NOT NULL,
+  Content TEXT,
+  Timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+  Status VARCHAR(255) DEFAULT 'pending' CHECK( Status IN ('pending', 'ignored') ),
+  FOREIGN KEY (SenderUserID) REFERENCES User(UserID),
+  FOREIGN KEY (ReceiverUserID) REFERENCES User(UserID)
+);
+
+CREATE TABLE IF NOT EXISTS MessagePermissions (
+  UserID INTEGER NOT NULL,
+  AllowedUserID INTEGER NOT NULL,
+  PRIMARY KEY (UserID, AllowedUserID),
+  FOREIGN KEY (UserID) REFERENCES User(UserID),
+  FOREIGN KEY (AllowedUserID) REFERENCES User(UserID)
+);
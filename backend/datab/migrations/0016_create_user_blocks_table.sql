@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS UserBlocks (
+  BlockerUserID INTEGER NOT NULL,
+  BlockedUserID INTEGER NOT NULL,
+  CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (BlockerUserID, BlockedUserID),
+  FOREIGN KEY (BlockerUserID) REFERENCES User(UserID),
+  FOREIGN KEY (BlockedUserID) REFERENCES User(UserID)
+);
+
+CREATE TABLE IF NOT EXISTS UserMutes (
+  MuterUserID INTEGER NOT NULL,
+  MutedUserID INTEGER NOT NULL,
+  CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (MuterUserID, MutedUserID),
+  FOREIGN KEY (MuterUserID) REFERENCES User(UserID),
+  FOREIGN KEY (MutedUserID) REFERENCES User(UserID)
+);
@@ -0,0 +1,35 @@
+ALTER TABLE User ADD COLUMN Role VARCHAR(255) DEFAULT 'user';
+ALTER TABLE User ADD COLUMN Suspended BOOLEAN DEFAULT FALSE;
+
+ALTER TABLE Post ADD COLUMN Hidden BOOLEAN DEFAULT FALSE;
+ALTER TABLE Comment ADD COLUMN Hidden BOOLEAN DEFAULT FALSE;
+ALTER TABLE Message ADD COLUMN Hidden BOOLEAN DEFAULT FALSE;
+ALTER TABLE GroupChatMessage ADD COLUMN Hidden BOOLEAN DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS Reports (
+  ReportID INTEGER PRIMARY KEY AUTOINCREMENT,
+  ReporterUserID INTEGER NOT NULL,
+  TargetType VARCHAR(255) NOT NULL CHECK( TargetType IN ('post', 'comment', 'message', 'user') ),
+  TargetID VARCHAR(255) NOT NULL,
+  ReasonCode VARCHAR(255) NOT NULL,
+  Details TEXT,
+  Status VARCHAR(255) DEFAULT 'open' CHECK( Status IN ('open', 'actioned', 'dismissed') ),
+  CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+  ResolvedByUserID INTEGER,
+  ResolvedAt DATETIME,
+  FOREIGN KEY (ReporterUserID) REFERENCES User(UserID),
+  FOREIGN KEY (ResolvedByUserID) REFERENCES User(UserID)
+);
+
+CREATE TABLE IF NOT EXISTS ModerationActions (
+  ActionID INTEGER PRIMARY KEY AUTOINCREMENT,
+  ModeratorUserID INTEGER NOT NULL,
+  ReportID INTEGER,
+  Action VARCHAR(255) NOT NULL,
+  TargetType VARCHAR(255) NOT NULL,
+  TargetID VARCHAR(255) NOT NULL,
+  Note TEXT,
+  CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (ModeratorUserID) REFERENCES User(UserID),
+  FOREIGN KEY (ReportID) REFERENCES Reports(ReportID)
+);
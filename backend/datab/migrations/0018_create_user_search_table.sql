@@ -0,0 +1,32 @@
+-- Full-text index over the searchable profile fields, kept in sync with User by triggers.
+-- Requires SQLite built with FTS5 (go build -tags sqlite_fts5).
+CREATE VIRTUAL TABLE IF NOT EXISTS UserSearch USING fts5(
+  Nickname,
+  FirstName,
+  LastName,
+  AboutMe,
+  content = 'User',
+  content_rowid = 'UserID',
+  tokenize = 'unicode61 remove_diacritics 2',
+  prefix = '2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS UserSearchInsert AFTER INSERT ON User BEGIN
+  INSERT INTO UserSearch (rowid, Nickname, FirstName, LastName, AboutMe)
+  VALUES (new.UserID, new.Nickname, new.FirstName, new.LastName, new.AboutMe);
+END;
+
+CREATE TRIGGER IF NOT EXISTS UserSearchDelete AFTER DELETE ON User BEGIN
+  INSERT INTO UserSearch (UserSearch, rowid, Nickname, FirstName, LastName, AboutMe)
+  VALUES ('delete', old.UserID, old.Nickname, old.FirstName, old.LastName, old.AboutMe);
+END;
+
+CREATE TRIGGER IF NOT EXISTS UserSearchUpdate AFTER UPDATE OF Nickname, FirstName, LastName, AboutMe ON User BEGIN
+  INSERT INTO UserSearch (UserSearch, rowid, Nickname, FirstName, LastName, AboutMe)
+  VALUES ('delete', old.UserID, old.Nickname, old.FirstName, old.LastName, old.AboutMe);
+  INSERT INTO UserSearch (rowid, Nickname, FirstName, LastName, AboutMe)
+  VALUES (new.UserID, new.Nickname, new.FirstName, new.LastName, new.AboutMe);
+END;
+
+-- Index the users that already exist
+INSERT INTO UserSearch (UserSearch) VALUES ('rebuild');
@@ -0,0 +1,70 @@
+-- Full-text indexes over posts, comments and groups, kept in sync by triggers.
+-- Requires SQLite built with FTS5 (go build -tags sqlite_fts5).
+CREATE VIRTUAL TABLE IF NOT EXISTS PostSearch USING fts5(
+  Content,
+  content = 'Post',
+  content_rowid = 'PostID',
+  tokenize = 'unicode61 remove_diacritics 2',
+  prefix = '2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS PostSearchInsert AFTER INSERT ON Post BEGIN
+  INSERT INTO PostSearch (rowid, Content) VALUES (new.PostID, new.Content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS PostSearchDelete AFTER DELETE ON Post BEGIN
+  INSERT INTO PostSearch (PostSearch, rowid, Content) VALUES ('delete', old.PostID, old.Content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS PostSearchUpdate AFTER UPDATE OF Content ON Post BEGIN
+  INSERT INTO PostSearch (PostSearch, rowid, Content) VALUES ('delete', old.PostID, old.Content);
+  INSERT INTO PostSearch (rowid, Content) VALUES (new.PostID, new.Content);
+END;
+
+CREATE VIRTUAL TABLE IF NOT EXISTS CommentSearch USING fts5(
+  Content,
+  content = 'Comment',
+  content_rowid = 'CommentID',
+  tokenize = 'unicode61 remove_diacritics 2',
+  prefix = '2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS CommentSearchInsert AFTER INSERT ON Comment BEGIN
+  INSERT INTO CommentSearch (rowid, Content) VALUES (new.CommentID, new.Content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS CommentSearchDelete AFTER DELETE ON Comment BEGIN
+  INSERT INTO CommentSearch (CommentSearch, rowid, Content) VALUES ('delete', old.CommentID, old.Content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS CommentSearchUpdate AFTER UPDATE OF Content ON Comment BEGIN
+  INSERT INTO CommentSearch (CommentSearch, rowid, Content) VALUES ('delete', old.CommentID, old.Content);
+  INSERT INTO CommentSearch (rowid, Content) VALUES (new.CommentID, new.Content);
+END;
+
+CREATE VIRTUAL TABLE IF NOT EXISTS GroupSearch USING fts5(
+  Name,
+  Description,
+  content = 'Cluster',
+  content_rowid = 'GroupID',
+  tokenize = 'unicode61 remove_diacritics 2',
+  prefix = '2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS GroupSearchInsert AFTER INSERT ON Cluster BEGIN
+  INSERT INTO GroupSearch (rowid, Name, Description) VALUES (new.GroupID, new.Name, new.Description);
+END;
+
+CREATE TRIGGER IF NOT EXISTS GroupSearchDelete AFTER DELETE ON Cluster BEGIN
+  INSERT INTO GroupSearch (GroupSearch, rowid, Name, Description) VALUES ('delete', old.GroupID, old.Name, old.Description);
+END;
+
+CREATE TRIGGER IF NOT EXISTS GroupSearchUpdate AFTER UPDATE OF Name, Description ON Cluster BEGIN
+  INSERT INTO GroupSearch (GroupSearch, rowid, Name, Description) VALUES ('delete', old.GroupID, old.Name, old.Description);
+  INSERT INTO GroupSearch (rowid, Name, Description) VALUES (new.GroupID, new.Name, new.Description);
+END;
+
+-- Index the content that already exists
+INSERT INTO PostSearch (PostSearch) VALUES ('rebuild');
+INSERT INTO CommentSearch (CommentSearch) VALUES ('rebuild');
+INSERT INTO GroupSearch (GroupSearch) VALUES ('rebuild');
@@ -0,0 +1,45 @@
+CREATE TABLE IF NOT EXISTS Tags (
+  TagID INTEGER PRIMARY KEY AUTOINCREMENT,
+  Name VARCHAR(255) NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS PostTags (
+  PostID INTEGER NOT NULL,
+  TagID INTEGER NOT NULL,
+  PRIMARY KEY (PostID, TagID),
+  FOREIGN KEY (PostID) REFERENCES Post(PostID),
+  FOREIGN KEY (TagID) REFERENCES Tags(TagID)
+);
+
+CREATE TABLE IF NOT EXISTS CommentTags (
+  CommentID INTEGER NOT NULL,
+  TagID INTEGER NOT NULL,
+  PRIMARY KEY (CommentID, TagID),
+  FOREIGN KEY (CommentID) REFERENCES Comment(CommentID),
+  FOREIGN KEY (TagID) REFERENCES Tags(TagID)
+);
+
+CREATE TABLE IF NOT EXISTS PostMentions (
+  PostID INTEGER NOT NULL,
+  UserID INTEGER NOT NULL,
+  PRIMARY KEY (PostID, UserID),
+  FOREIGN KEY (PostID) REFERENCES Post(PostID),
+  FOREIGN KEY (UserID) REFERENCES User(UserID)
+);
+
+CREATE TABLE IF NOT EXISTS CommentMentions (
+  CommentID INTEGER NOT NULL,
+  UserID INTEGER NOT NULL,
+  PRIMARY KEY (CommentID, UserID),
+  FOREIGN KEY (CommentID) REFERENCES Comment(CommentID),
+  FOREIGN KEY (UserID) REFERENCES User(UserID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_posttags_tag ON PostTags (TagID);
+CREATE INDEX IF NOT EXISTS idx_commenttags_tag ON CommentTags (TagID);
+
+-- Notifications point at what they are about instead of carrying only text
+ALTER TABLE Notification ADD COLUMN Type VARCHAR(255);
+ALTER TABLE Notification ADD COLUMN ActorUserID INTEGER REFERENCES User(UserID);
+ALTER TABLE Notification ADD COLUMN PostID INTEGER REFERENCES Post(PostID);
+ALTER TABLE Notification ADD COLUMN CommentID INTEGER REFERENCES Comment(CommentID);
@@ -0,0 +1,5 @@
+-- A repost is a post pointing at the original, its content is the optional quote text.
+-- The reference is kept when the original is deleted so the repost can say so.
+ALTER TABLE Post ADD COLUMN RepostOfPostID INTEGER REFERENCES Post(PostID);
+
+CREATE INDEX IF NOT EXISTS idx_post_repostof ON Post (RepostOfPostID);
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS BookmarkCollections (
+  CollectionID INTEGER PRIMARY KEY AUTOINCREMENT,
+  UserID INTEGER NOT NULL,
+  Name VARCHAR(255) NOT NULL,
+  CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (UserID, Name),
+  FOREIGN KEY (UserID) REFERENCES User(UserID)
+);
+
+-- A post is saved once per user, either unsorted (no collection) or in one of the user's collections
+CREATE TABLE IF NOT EXISTS Bookmarks (
+  UserID INTEGER NOT NULL,
+  PostID INTEGER NOT NULL,
+  CollectionID INTEGER,
+  CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (UserID, PostID),
+  FOREIGN KEY (UserID) REFERENCES User(UserID),
+  FOREIGN KEY (PostID) REFERENCES Post(PostID),
+  FOREIGN KEY (CollectionID) REFERENCES BookmarkCollections(CollectionID)
+);
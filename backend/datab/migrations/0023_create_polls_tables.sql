@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS Polls (
+  PollID INTEGER PRIMARY KEY AUTOINCREMENT,
+  PostID INTEGER NOT NULL UNIQUE,
+  Question TEXT NOT NULL,
+  MultipleChoice BOOLEAN DEFAULT FALSE,
+  ClosesAt DATETIME,
+  CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (PostID) REFERENCES Post(PostID)
+);
+
+CREATE TABLE IF NOT EXISTS PollOptions (
+  OptionID INTEGER PRIMARY KEY AUTOINCREMENT,
+  PollID INTEGER NOT NULL,
+  Position INTEGER NOT NULL,
+  Text VARCHAR(255) NOT NULL,
+  FOREIGN KEY (PollID) REFERENCES Polls(PollID)
+);
+
+-- A user votes once per poll; a multiple choice vote has one row per chosen option
+CREATE TABLE IF NOT EXISTS PollVotes (
+  PollID INTEGER NOT NULL,
+  OptionID INTEGER NOT NULL,
+  UserID INTEGER NOT NULL,
+  CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (PollID, OptionID, UserID),
+  FOREIGN KEY (PollID) REFERENCES Polls(PollID),
+  FOREIGN KEY (OptionID) REFERENCES PollOptions(OptionID),
+  FOREIGN KEY (UserID) REFERENCES User(UserID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_polloptions_poll ON PollOptions (PollID);
+CREATE INDEX IF NOT EXISTS idx_pollvotes_user ON PollVotes (PollID, UserID);
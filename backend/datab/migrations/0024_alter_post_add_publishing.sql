@@ -0,0 +1,6 @@
+-- Drafts and scheduled posts stay unpublished, visible only to their author, until they are published.
+-- A scheduled post has the time it is published at, a draft has none.
+ALTER TABLE Post ADD COLUMN Published BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE Post ADD COLUMN PublishAt DATETIME;
+
+CREATE INDEX IF NOT EXISTS idx_post_publishat ON Post (Published, PublishAt);
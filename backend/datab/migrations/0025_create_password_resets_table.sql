@@ -0,0 +1,12 @@
+-- Only the SHA-256 hash of a reset token is stored, the token itself is only in the e-mail.
+-- A token is used once and expires.
+CREATE TABLE IF NOT EXISTS PasswordResets (
+  TokenHash TEXT PRIMARY KEY,
+  UserID INTEGER NOT NULL,
+  ExpiresAt DATETIME NOT NULL,
+  UsedAt DATETIME,
+  CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (UserID) REFERENCES User(UserID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_passwordresets_user ON PasswordResets (UserID);
@@ -0,0 +1,16 @@
+-- Accounts that existed before verification was introduced count as verified.
+ALTER TABLE User ADD COLUMN Verified BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE User SET Verified = TRUE;
+
+-- A verification confirms Email for the user: their address at registration or the one they are changing to,
+-- which only replaces the current address once confirmed. Only the SHA-256 hash of the token is stored.
+CREATE TABLE IF NOT EXISTS EmailVerifications (
+  TokenHash TEXT PRIMARY KEY,
+  UserID INTEGER NOT NULL,
+  Email VARCHAR(255) NOT NULL,
+  ExpiresAt DATETIME NOT NULL,
+  CreatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (UserID) REFERENCES User(UserID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_emailverifications_user ON EmailVerifications (UserID, CreatedAt);
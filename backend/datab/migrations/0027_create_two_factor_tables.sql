@@ -0,0 +1,23 @@
+-- TOTP two-factor authentication. TOTPSecret is set at enrollment and only used for logins once the first
+-- code confirmed it; TOTPLastStep is the time step of the last accepted code, so a code works only once.
+ALTER TABLE User ADD COLUMN TOTPSecret TEXT;
+ALTER TABLE User ADD COLUMN TOTPEnabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE User ADD COLUMN TOTPLastStep INTEGER NOT NULL DEFAULT 0;
+
+-- One-time recovery codes for when the authenticator is lost, stored as SHA-256 hashes
+CREATE TABLE IF NOT EXISTS RecoveryCodes (
+  UserID INTEGER NOT NULL,
+  CodeHash TEXT NOT NULL,
+  UsedAt DATETIME,
+  PRIMARY KEY (UserID, CodeHash),
+  FOREIGN KEY (UserID) REFERENCES User(UserID)
+);
+
+-- A login that passed the password and waits for the second factor
+CREATE TABLE IF NOT EXISTS LoginChallenges (
+  TokenHash TEXT PRIMARY KEY,
+  UserID INTEGER NOT NULL,
+  ExpiresAt DATETIME NOT NULL,
+  Attempts INTEGER NOT NULL DEFAULT 0,
+  FOREIGN KEY (UserID) REFERENCES User(UserID)
+);
@@ -0,0 +1,3 @@
+-- Failed logins in a row, and until when the account is locked because of them
+ALTER TABLE User ADD COLUMN FailedLogins INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE User ADD COLUMN LockedUntil DATETIME;
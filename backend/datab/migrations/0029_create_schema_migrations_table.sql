@@ -0,0 +1,6 @@
+-- Which migrations have been applied, so the migrate command only runs the new ones. A datab whose earlier
+-- migrations were applied by hand has them recorded with migrate baseline <version>.
+CREATE TABLE IF NOT EXISTS SchemaMigrations (
+    Version TEXT PRIMARY KEY,
+    AppliedAt DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,11 @@
+-- Every verification e-mail sent, to limit them per user. Unlike EmailVerifications, whose tokens are replaced
+-- when the address changes and removed once used, rows only go once they are a day old.
+CREATE TABLE IF NOT EXISTS EmailVerificationSends (
+  UserID INTEGER NOT NULL,
+  SentAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (UserID) REFERENCES User(UserID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_emailverificationsends_user ON EmailVerificationSends (UserID, SentAt);
+
+INSERT INTO EmailVerificationSends (UserID, SentAt) SELECT UserID, CreatedAt FROM EmailVerifications;
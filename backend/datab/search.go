@@ -0,0 +1,127 @@
+package datab
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// searchIndexes are the FTS5 tables of migrations 0018 and 0019. SQLite only has FTS5 when the binary is built
+// with -tags sqlite_fts5.
+var searchIndexes = []string{"UserSearch", "PostSearch", "CommentSearch", "GroupSearch"}
+
+// searchTrigger matches a CREATE TRIGGER statement of a migration, with the trigger's name
+var searchTrigger = regexp.MustCompile(`(?s)CREATE TRIGGER IF NOT EXISTS (\w+) .*?\bEND;`)
+
+// searchTriggers returns the statements creating the triggers that keep the indexes current, as the migrations
+// of the migrations directory have them, so the two can't drift. Without FTS5 the triggers make every insert
+// into User, Post, Comment and Cluster fail.
+func searchTriggers(migrationsPath string) (string, error) {
+	files, err := filepath.Glob(filepath.Join(migrationsPath, "*.sql"))
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	wanted := map[string]bool{}
+	for _, name := range searchTriggerList() {
+		wanted[name] = true
+	}
+	var statements []string
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		for _, match := range searchTrigger.FindAllStringSubmatch(string(content), -1) {
+			if wanted[match[1]] {
+				statements = append(statements, match[0])
+				delete(wanted, match[1])
+			}
+		}
+	}
+	if len(wanted) > 0 {
+		return "", fmt.Errorf("the migrations in %s don't create all of the search triggers", migrationsPath)
+	}
+	return strings.Join(statements, "\n\n"), nil
+}
+
+// HasFTS5 tells whether the SQLite compiled into the binary has FTS5
+func HasFTS5(db *sql.DB) (bool, error) {
+	var enabled bool
+	err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled)
+	return enabled, err
+}
+
+// SetupSearch makes the search indexes work with the binary: without FTS5 it drops their triggers, so writes
+// keep working and search falls back to plain matching, and with FTS5 it puts back triggers dropped before, from
+// the migrations that created them, and rebuilds the indexes, which missed the writes in between. It returns
+// whether FTS5 is there.
+func SetupSearch(db *sql.DB, migrationsPath string) (bool, error) {
+	enabled, err := HasFTS5(db)
+	if err != nil {
+		return false, err
+	}
+
+	var indexes, triggers int
+	err = db.QueryRow(`SELECT
+	(SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('`+strings.Join(searchIndexes, "', '")+`')),
+	(SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN (`+searchTriggerNames()+`))`).Scan(&indexes, &triggers)
+	if err != nil {
+		return enabled, err
+	}
+	if indexes < len(searchIndexes) {
+		// Not migrated yet
+		return enabled, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return enabled, err
+	}
+	switch {
+	case !enabled && triggers > 0:
+		for _, index := range searchIndexes {
+			for _, suffix := range []string{"Insert", "Delete", "Update"} {
+				if _, err := tx.Exec(`DROP TRIGGER IF EXISTS ` + index + suffix); err != nil {
+					tx.Rollback()
+					return enabled, err
+				}
+			}
+		}
+	case enabled && triggers < 3*len(searchIndexes):
+		triggerSQL, err := searchTriggers(migrationsPath)
+		if err != nil {
+			tx.Rollback()
+			return enabled, err
+		}
+		if _, err := tx.Exec(triggerSQL); err != nil {
+			tx.Rollback()
+			return enabled, err
+		}
+		for _, index := range searchIndexes {
+			if _, err := tx.Exec(`INSERT INTO ` + index + ` (` + index + `) VALUES ('rebuild')`); err != nil {
+				tx.Rollback()
+				return enabled, err
+			}
+		}
+	}
+	return enabled, tx.Commit()
+}
+
+// searchTriggerList returns the names of the triggers of the search indexes
+func searchTriggerList() []string {
+	var names []string
+	for _, index := range searchIndexes {
+		names = append(names, index+"Insert", index+"Delete", index+"Update")
+	}
+	return names
+}
+
+func searchTriggerNames() string {
+	return "'" + strings.Join(searchTriggerList(), "', '") + "'"
+}
@@ -3,9 +3,11 @@ package datab
 import (
 	"cloud.google.com/go/storage"
 	"context"
+	"google.golang.org/api/iterator"
 	"io"
-	"log"
+	"log/slog"
 	"net/url"
+	"strings"
 )
 
 func StoreToCloud(ctx context.Context, client *storage.Client, bucketName, objectName string, file io.Reader) (string, error) {
@@ -17,9 +19,43 @@ func StoreToCloud(ctx context.Context, client *storage.Client, bucketName, objec
 		return "", err
 	}
 
-	log.Println("Uploaded to cloud")
+	slog.Debug("Uploaded to cloud")
 
 	// Construct the URL for embedding
 	publicURL := "https://storage.googleapis.com/" + bucketName + "/" + url.PathEscape(objectName)
 	return publicURL, nil
 }
+
+// ReadFromCloud opens an object from the bucket for reading; the caller must close the returned reader
+func ReadFromCloud(ctx context.Context, client *storage.Client, bucketName, objectName string) (io.ReadCloser, error) {
+	return client.Bucket(bucketName).Object(objectName).NewReader(ctx)
+}
+
+// DeleteFromCloud removes an object from the bucket
+func DeleteFromCloud(ctx context.Context, client *storage.Client, bucketName, objectName string) error {
+	return client.Bucket(bucketName).Object(objectName).Delete(ctx)
+}
+
+// ObjectNameFromURL returns the name of the object a public URL made by StoreToCloud points at,
+// and false when the URL isn't one of the bucket's
+func ObjectNameFromURL(bucketName, publicURL string) (string, bool) {
+	prefix := "https://storage.googleapis.com/" + bucketName + "/"
+	if !strings.HasPrefix(publicURL, prefix) {
+		return "", false
+	}
+	objectName, err := url.PathUnescape(strings.TrimPrefix(publicURL, prefix))
+	if err != nil || objectName == "" {
+		return "", false
+	}
+	return objectName, true
+}
+
+// CheckCloud makes sure the bucket can be reached with the client's credentials, by listing at most one object
+func CheckCloud(ctx context.Context, client *storage.Client, bucketName string) error {
+	objects := client.Bucket(bucketName).Objects(ctx, nil)
+	objects.PageInfo().MaxSize = 1
+	if _, err := objects.Next(); err != nil && err != iterator.Done {
+		return err
+	}
+	return nil
+}
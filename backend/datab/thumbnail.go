@@ -0,0 +1,71 @@
+package datab
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+)
+
+// maxImagePixels caps the images decoded for thumbnails. A small file can declare huge dimensions, and decoding
+// allocates memory for all of them.
+const maxImagePixels = 40_000_000
+
+// ErrImageTooLarge means the image has more pixels than we are willing to decode
+var ErrImageTooLarge = errors.New("image dimensions are too large")
+
+// CreateThumbnail decodes an image and scales it down so that its longest side is at most maxSide pixels.
+// It returns the JPEG encoded thumbnail together with the dimensions of the original image.
+func CreateThumbnail(data []byte, maxSide int) ([]byte, int, int, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return nil, 0, 0, ErrImageTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	thumbWidth, thumbHeight := width, height
+	if width > maxSide || height > maxSide {
+		if width >= height {
+			thumbWidth = maxSide
+			thumbHeight = height * maxSide / width
+		} else {
+			thumbHeight = maxSide
+			thumbWidth = width * maxSide / height
+		}
+	}
+	if thumbWidth < 1 {
+		thumbWidth = 1
+	}
+	if thumbHeight < 1 {
+		thumbHeight = 1
+	}
+
+	// Nearest neighbour sampling is good enough for chat previews
+	thumb := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	for y := 0; y < thumbHeight; y++ {
+		srcY := bounds.Min.Y + y*height/thumbHeight
+		for x := 0; x < thumbWidth; x++ {
+			srcX := bounds.Min.X + x*width/thumbWidth
+			thumb.Set(x, y, src.At(srcX, srcY))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, 0, 0, err
+	}
+
+	return buf.Bytes(), width, height, nil
+}
@@ -0,0 +1,52 @@
+package datab
+
+import (
+	"context"
+	"database/sql/driver"
+	"time"
+
+	"social-network/backend/metrics"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var queryDuration = metrics.NewHistogramVec("db_query_duration_seconds",
+	"Time the datab takes to run queries and statements, by op (query or exec).",
+	[]float64{.0001, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}, "op")
+
+// timedConnector opens SQLite connections that time their queries
+type timedConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func (c timedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &timedConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+func (c timedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// timedConn is a SQLite connection recording how long its queries and statements take
+type timedConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c *timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	defer observe("query", time.Now())
+	return c.SQLiteConn.QueryContext(ctx, query, args)
+}
+
+func (c *timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	defer observe("exec", time.Now())
+	return c.SQLiteConn.ExecContext(ctx, query, args)
+}
+
+func observe(op string, start time.Time) {
+	queryDuration.With(op).Observe(time.Since(start).Seconds())
+}
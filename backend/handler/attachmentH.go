@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"bytes"
+	"cloud.google.com/go/storage"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"social-network/backend/apierror"
+	"social-network/backend/datab"
+	"social-network/backend/model"
+	"social-network/backend/ratelimit"
+)
+
+const (
+	// Maximum size of a single chat attachment
+	maxAttachmentSize = 10 << 20
+
+	// Longest side of generated image thumbnails in pixels
+	thumbnailSize = 320
+
+	// Longest upload file name kept in storage object names
+	maxObjectFileName = 100
+)
+
+// inlineTypes are the attachment types served for the browser to show. Anything else, HTML above all, is only
+// served as a download, so an uploaded page can't run scripts on the app's origin.
+var inlineTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+	"image/bmp":  true,
+}
+
+// objectFileName makes the file name a client sent for an upload safe to put in a storage object name: no
+// directories, and only letters, digits, dots, dashes and underscores
+func objectFileName(fileName string) string {
+	fileName = path.Base(strings.ReplaceAll(fileName, "\\", "/"))
+	var b strings.Builder
+	for _, r := range fileName {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	name := strings.TrimLeft(b.String(), ".")
+	if len(name) > maxObjectFileName {
+		name = name[len(name)-maxObjectFileName:]
+	}
+	if name == "" {
+		return "file"
+	}
+	return name
+}
+
+// UploadChatAttH stores a file for a chat room the user is in, POST /api/chat/upload. Uploads count against the
+// same verification rule and rate limit as chat messages.
+func UploadChatAttH(db *sql.DB, storageClient *storage.Client, bucketName string, limits *ratelimit.Limiters) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cookie, err := r.Cookie("session_id")
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userID, err := model.GetUserIDBySessionID(db, cookie.Value)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error retrieving user ID", "error", err)
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !requireVerified(db, w, userID) || !allow(w, limits.Messages, ratelimit.UserKey(userID)) {
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+(1<<20))
+		if err := r.ParseMultipartForm(maxAttachmentSize); err != nil {
+			apierror.HTTPError(w, "File too large", http.StatusBadRequest)
+			return
+		}
+
+		roomID := r.FormValue("roomId")
+		isParticipant, err := model.IsRoomParticipant(db, roomID, userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error checking room participation", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !isParticipant {
+			apierror.HTTPError(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			apierror.HTTPError(w, "File is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(file)
+		if err != nil {
+			apierror.HTTPError(w, "Error processing file", http.StatusBadRequest)
+			return
+		}
+
+		attachment := model.Attachment{
+			AttachmentID:   uuid.New().String(),
+			UploaderUserID: userID,
+			RoomID:         roomID,
+			FileName:       header.Filename,
+			MimeType:       http.DetectContentType(data),
+			Size:           int64(len(data)),
+		}
+		attachment.ObjectName = "chat/" + roomID + "/" + attachment.AttachmentID + "_" + objectFileName(header.Filename)
+
+		// Generate a thumbnail and read the dimensions for images we can decode
+		if strings.HasPrefix(attachment.MimeType, "image/") {
+			thumbnail, width, height, err := datab.CreateThumbnail(data, thumbnailSize)
+			if err != nil {
+				slog.WarnContext(r.Context(), "Could not create thumbnail", "attachment_id", attachment.AttachmentID, "error", err)
+			} else {
+				attachment.Width = width
+				attachment.Height = height
+				attachment.ThumbnailObjectName = "chat/" + roomID + "/" + attachment.AttachmentID + "_thumb.jpg"
+				_, err = datab.StoreToCloud(context.Background(), storageClient, bucketName, attachment.ThumbnailObjectName, bytes.NewReader(thumbnail))
+				if err != nil {
+					slog.ErrorContext(r.Context(), "Failed to upload thumbnail", "error", err)
+					apierror.HTTPError(w, "Failed to upload attachment", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+
+		_, err = datab.StoreToCloud(context.Background(), storageClient, bucketName, attachment.ObjectName, bytes.NewReader(data))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to upload attachment", "error", err)
+			apierror.HTTPError(w, "Failed to upload attachment", http.StatusInternalServerError)
+			return
+		}
+
+		createdAttachment, err := model.CreateAttachment(db, attachment)
+		if err != nil {
+			apierror.HTTPError(w, "Error saving attachment", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(createdAttachment)
+	}
+}
+
+func GetChatAttH(db *sql.DB, storageClient *storage.Client, bucketName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session_id")
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userID, err := model.GetUserIDBySessionID(db, cookie.Value)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		attachment, err := model.GetAttachment(db, param(r, "id"))
+		if err == sql.ErrNoRows {
+			apierror.HTTPError(w, "Attachment not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			slog.ErrorContext(r.Context(), "Error fetching attachment", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		isParticipant, err := model.IsRoomParticipant(db, attachment.RoomID, userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error checking room participation", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !isParticipant {
+			// Do not reveal that the attachment exists
+			apierror.HTTPError(w, "Attachment not found", http.StatusNotFound)
+			return
+		}
+
+		objectName := attachment.ObjectName
+		contentType := attachment.MimeType
+		if r.URL.Query().Get("thumbnail") != "" && attachment.ThumbnailObjectName != "" {
+			objectName = attachment.ThumbnailObjectName
+			contentType = "image/jpeg"
+		}
+
+		reader, err := datab.ReadFromCloud(r.Context(), storageClient, bucketName, objectName)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error reading attachment from storage", "attachment_id", attachment.AttachmentID, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer reader.Close()
+
+		if !inlineTypes[contentType] {
+			contentType = "application/octet-stream"
+			disposition := mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName})
+			if disposition == "" {
+				disposition = "attachment"
+			}
+			w.Header().Set("Content-Disposition", disposition)
+			w.Header().Set("Content-Security-Policy", "sandbox")
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if _, err := io.Copy(w, reader); err != nil {
+			slog.ErrorContext(r.Context(), "Error streaming attachment", "attachment_id", attachment.AttachmentID, "error", err)
+		}
+	}
+}
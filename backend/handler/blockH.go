@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"social-network/backend/apierror"
+	"social-network/backend/model"
+)
+
+func BlockH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			UserId int    `json:"userId"`
+			Action string `json:"action"` // block, unblock, mute or unmute
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		if req.UserId == userID || req.UserId <= 0 {
+			apierror.HTTPError(w, "Invalid user", http.StatusBadRequest)
+			return
+		}
+
+		switch req.Action {
+		case "block":
+			err = model.BlockUser(db, userID, req.UserId)
+		case "unblock":
+			err = model.UnblockUser(db, userID, req.UserId)
+		case "mute":
+			err = model.MuteUser(db, userID, req.UserId)
+		case "unmute":
+			err = model.UnmuteUser(db, userID, req.UserId)
+		default:
+			apierror.HTTPError(w, "Invalid Action", http.StatusBadRequest)
+			return
+		}
+
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error processing action", "action", req.Action, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+func GetBlockedH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		blocked, err := model.GetBlockedUsers(db, userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error fetching blocked users", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		muted, err := model.GetMutedUsers(db, userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error fetching muted users", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"blocked": blocked,
+			"muted":   muted,
+		})
+	}
+}
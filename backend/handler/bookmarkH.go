@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"social-network/backend/apierror"
+	"social-network/backend/model"
+)
+
+func BookmarkH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			PostID       int    `json:"postId"`
+			CollectionID int    `json:"collectionId"` // 0 keeps the post unsorted
+			Action       string `json:"action"`       // add or remove
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		switch req.Action {
+		case "add":
+			err = model.AddBookmark(db, userID, req.PostID, req.CollectionID)
+		case "remove":
+			err = model.RemoveBookmark(db, userID, req.PostID)
+		default:
+			apierror.HTTPError(w, "Invalid Action", http.StatusBadRequest)
+			return
+		}
+
+		switch err {
+		case nil:
+		case model.ErrPostNotFound:
+			apierror.HTTPError(w, "Post not found", http.StatusNotFound)
+			return
+		case model.ErrCollectionNotFound:
+			apierror.HTTPError(w, "Collection not found", http.StatusNotFound)
+			return
+		default:
+			slog.ErrorContext(r.Context(), "Error processing bookmark", "action", req.Action, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+// GetBookmarksH lists saved posts, GET /api/bookmarks?collectionId=&limit=&offset=
+func GetBookmarksH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		collectionID := 0
+		if collectionStr := r.URL.Query().Get("collectionId"); collectionStr != "" {
+			collectionID, err = strconv.Atoi(collectionStr)
+			if err != nil || collectionID < 0 {
+				apierror.HTTPError(w, "Invalid collectionId", http.StatusBadRequest)
+				return
+			}
+		}
+
+		limit, offset, err := pageParams(r)
+		if err != nil {
+			apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		posts, err := model.GetBookmarks(db, userID, collectionID, limit, offset)
+		if err != nil {
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(posts)
+	}
+}
+
+// CollectionsH lists the user's bookmark collections on GET and creates or deletes one on POST
+func CollectionsH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method == "GET" {
+			collections, err := model.GetCollections(db, userID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error fetching bookmark collections", "error", err)
+				apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(collections)
+			return
+		}
+
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Action       string `json:"action"` // create or delete
+			Name         string `json:"name"`
+			CollectionID int    `json:"collectionId"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		switch req.Action {
+		case "create":
+			name := strings.TrimSpace(req.Name)
+			if name == "" || len(name) > 100 {
+				apierror.HTTPError(w, "Invalid name", http.StatusBadRequest)
+				return
+			}
+
+			collection, err := model.CreateCollection(db, userID, name)
+			if err == model.ErrCollectionExists {
+				apierror.HTTPError(w, "Collection already exists", http.StatusConflict)
+				return
+			} else if err != nil {
+				apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(collection)
+		case "delete":
+			err := model.DeleteCollection(db, userID, req.CollectionID)
+			if err == model.ErrCollectionNotFound {
+				apierror.HTTPError(w, "Collection not found", http.StatusNotFound)
+				return
+			} else if err != nil {
+				slog.ErrorContext(r.Context(), "Error deleting bookmark collection", "error", err)
+				apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+		default:
+			apierror.HTTPError(w, "Invalid Action", http.StatusBadRequest)
+		}
+	}
+}
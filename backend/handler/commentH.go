@@ -6,50 +6,45 @@ import (
 	"database/sql"
 	"encoding/json"
 	"github.com/google/uuid"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 
-	"social-network/backend/auth"
+	"social-network/backend/apierror"
 	"social-network/backend/datab"
 	"social-network/backend/model"
+	"social-network/backend/ratelimit"
 )
 
-func CrComHandler(db *sql.DB, storageClient *storage.Client, bucketName string) http.HandlerFunc {
+func CrComHandler(db *sql.DB, storageClient *storage.Client, bucketName string, limits *ratelimit.Limiters) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth.EnableCors(&w)
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		log.Println("-------------- Inside CrComHandler ------------------")
 
 		// Parse the multipart form
 		err := r.ParseMultipartForm(32 << 20) // maxMemory 32MB
 		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			apierror.HTTPError(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
 
 		// Extract the text fields
-		postID := r.FormValue("postID")
+		postID := param(r, "postID")
 		userID := r.FormValue("userID")
 		content := r.FormValue("content")
 
 		// Convert postID and userID to integers
 		postIDInt, err := strconv.Atoi(postID)
 		if err != nil {
-			log.Printf("Invalid postID: %v", err)
-			http.Error(w, "Invalid postID", http.StatusBadRequest)
+			slog.WarnContext(r.Context(), "Invalid postID", "error", err)
+			apierror.HTTPError(w, "Invalid postID", http.StatusBadRequest)
 			return
 		}
 		userIDInt, err := strconv.Atoi(userID)
 		if err != nil {
-			log.Printf("Invalid userID: %v", err)
-			http.Error(w, "Invalid userID", http.StatusBadRequest)
+			slog.WarnContext(r.Context(), "Invalid userID", "error", err)
+			apierror.HTTPError(w, "Invalid userID", http.StatusBadRequest)
+			return
+		}
+		if !requireVerified(db, w, userIDInt) || !allow(w, limits.Comments, ratelimit.UserKey(userIDInt)) {
 			return
 		}
 
@@ -64,34 +59,32 @@ func CrComHandler(db *sql.DB, storageClient *storage.Client, bucketName string)
 		file, header, err := r.FormFile("image")
 		if err == nil {
 			defer file.Close()
-			newFileName := "comments/" + uuid.New().String() + "_" + header.Filename
+			newFileName := "comments/" + uuid.New().String() + "_" + objectFileName(header.Filename)
 			imageURL, err := datab.StoreToCloud(context.Background(), storageClient, bucketName, newFileName, file)
 			if err != nil {
-				log.Printf("Failed to upload image: %v", err)
-				http.Error(w, "Failed to upload image", http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "Failed to upload image", "error", err)
+				apierror.HTTPError(w, "Failed to upload image", http.StatusInternalServerError)
 				return
 			}
 			newComment.CommentMedia = imageURL
 		} else if err != http.ErrMissingFile {
-			log.Printf("Error processing image file: %v", err)
-			http.Error(w, "Error processing image file", http.StatusBadRequest)
+			slog.ErrorContext(r.Context(), "Error processing image file", "error", err)
+			apierror.HTTPError(w, "Error processing image file", http.StatusBadRequest)
 			return
 		}
 
 		// Insert the new comment into the datab
 		createdComment, err := model.CreateComment(db, newComment)
 		if err != nil {
-			log.Printf("Error creating comment: %v", err)
-			http.Error(w, "Error creating comment", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error creating comment", "error", err)
+			apierror.HTTPError(w, "Error creating comment", http.StatusInternalServerError)
 			return
 		}
 
-		log.Println("Comment created successfully, returning new comment")
-
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(createdComment); err != nil {
-			log.Printf("Error sending comment response: %v", err)
-			http.Error(w, "Error sending comment response", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error sending comment response", "error", err)
+			apierror.HTTPError(w, "Error sending comment response", http.StatusInternalServerError)
 			return
 		}
 	}
@@ -99,33 +92,100 @@ func CrComHandler(db *sql.DB, storageClient *storage.Client, bucketName string)
 
 func GePostComH(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth.EnableCors(&w)
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		log.Println("--------------- Inside GePostComH ---------------")
 
-		postID := r.URL.Query().Get("postID")
+		postID := param(r, "postID")
 		if postID == "" {
-			http.Error(w, "postID is required", http.StatusBadRequest)
+			apierror.HTTPError(w, "postID is required", http.StatusBadRequest)
 			return
 		}
 
+		viewerID, _ := sessionUserID(db, r)
+
 		// Call the GetCommentsForPost function which executes the datab query
-		comments, err := model.GetCommentsForPost(db, postID)
+		comments, err := model.GetCommentsForPost(db, postID, viewerID)
 		if err != nil {
-			log.Printf("Error fetching comments: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error fetching comments", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		log.Println("Returning comments")
 		// Set the header and write the response
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(comments)
 	}
 }
+
+func EditComH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			CommentID int    `json:"commentId"`
+			Content   string `json:"content"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		// Only the author can edit a comment
+		err = model.UpdateComment(db, req.CommentID, userID, req.Content)
+		if err == model.ErrCommentNotFound {
+			apierror.HTTPError(w, "Comment not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+func DeleteComH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			CommentID int `json:"commentId"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		// The author of the comment and the author of the post can delete it
+		err = model.DeleteComment(db, req.CommentID, userID)
+		if err == model.ErrCommentNotFound {
+			apierror.HTTPError(w, "Comment not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"social-network/backend/apierror"
+	"social-network/backend/model"
+)
+
+// GetDraftsH lists the user's unpublished posts, GET /api/drafts
+func GetDraftsH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		posts, err := model.GetDrafts(db, userID)
+		if err != nil {
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(posts)
+	}
+}
+
+// DraftH publishes or reschedules a draft, POST /api/draft {postId, action: publish|schedule, publishAt}.
+// Scheduling without a publishAt turns the post back into a plain draft.
+func DraftH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			PostID    int        `json:"postId"`
+			Action    string     `json:"action"`
+			PublishAt *time.Time `json:"publishAt"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		switch req.Action {
+		case "publish":
+			err = model.PublishDraft(db, req.PostID, userID)
+		case "schedule":
+			err = model.SchedulePost(db, req.PostID, userID, req.PublishAt)
+		default:
+			apierror.HTTPError(w, "Invalid Action", http.StatusBadRequest)
+			return
+		}
+
+		switch err {
+		case nil:
+		case model.ErrPostNotFound:
+			apierror.HTTPError(w, "Draft not found", http.StatusNotFound)
+			return
+		case model.ErrInvalidPublishAt:
+			apierror.HTTPError(w, "publishAt must be in the future", http.StatusBadRequest)
+			return
+		case model.ErrNotGroupAdmin:
+			apierror.HTTPError(w, "Only group admins can schedule group posts", http.StatusForbidden)
+			return
+		default:
+			slog.ErrorContext(r.Context(), "Error processing draft", "action", req.Action, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
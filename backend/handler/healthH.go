@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"social-network/backend/datab"
+)
+
+const (
+	// readyCheckTimeout bounds each readiness check
+	readyCheckTimeout = 2 * time.Second
+	// storageCheckInterval is how long a storage check result is reused, so probes don't call the cloud every time
+	storageCheckInterval = 30 * time.Second
+)
+
+// HealthH reports that the server is up, GET /healthz
+func HealthH() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}
+}
+
+// ReadyH reports whether the server can take requests, GET /readyz -> {status, checks}. It answers 503 when
+// the datab or the storage bucket can't be reached. Why goes to the log, not to whoever asks.
+func ReadyH(db *sql.DB, storageClient *storage.Client, bucket string) http.HandlerFunc {
+	var mutex sync.Mutex
+	var storageErr error
+	var storageChecked time.Time
+
+	checkStorage := func(ctx context.Context) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if time.Since(storageChecked) > storageCheckInterval {
+			storageErr = datab.CheckCloud(ctx, storageClient, bucket)
+			storageChecked = time.Now()
+		}
+		return storageErr
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+		defer cancel()
+
+		checks := map[string]string{"datab": "ok", "storage": "ok"}
+		status, code := "ok", http.StatusOK
+		if err := db.PingContext(ctx); err != nil {
+			slog.WarnContext(r.Context(), "Datab not ready", "error", err)
+			checks["datab"] = "unavailable"
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+		if err := checkStorage(ctx); err != nil {
+			slog.WarnContext(r.Context(), "Storage not ready", "error", err)
+			checks["storage"] = "unavailable"
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "checks": checks})
+	}
+}
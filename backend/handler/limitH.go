@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"social-network/backend/apierror"
+	"social-network/backend/mail"
+	"social-network/backend/model"
+	"social-network/backend/ratelimit"
+)
+
+// allow takes a token of the limiter for the key, and answers 429 and returns false when there is none left
+func allow(w http.ResponseWriter, limiter *ratelimit.Limiter, key string) bool {
+	allowed, retryAfter := limiter.Allow(key)
+	if !allowed {
+		ratelimit.TooManyRequests(w, retryAfter)
+	}
+	return allowed
+}
+
+// checkLoginLock answers 429 and returns false while the user's account is locked
+func checkLoginLock(db *sql.DB, w http.ResponseWriter, userID int) bool {
+	err := model.CheckLoginLock(db, userID, time.Now())
+	if locked, ok := err.(*model.ErrAccountLocked); ok {
+		seconds := ratelimit.SetRetryAfter(w, locked.RetryAfter)
+		apierror.Write(w, http.StatusTooManyRequests, "account_locked", err.Error(), map[string]int{"retryAfter": seconds})
+		return false
+	} else if err != nil {
+		slog.Error("Error checking login lock", "user_id", userID, "error", err)
+		apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// recordLoginFailure counts a failed login against the account. Once there are too many in a row it locks the
+// account, for longer with each further failure, and tells the owner by e-mail.
+func recordLoginFailure(db *sql.DB, limits *ratelimit.Limiters, mailer mail.Mailer, appURL string, user *model.User) {
+	failures, err := model.RecordLoginFailure(db, user.UserID)
+	if err != nil {
+		return
+	}
+	lockout := limits.Config.LockoutFor(failures)
+	if lockout == 0 {
+		return
+	}
+	if err := model.LockAccount(db, user.UserID, time.Now().Add(lockout)); err != nil {
+		return
+	}
+	slog.Warn("Locked account after failed logins", "user_id", user.UserID, "lockout", lockout, "failures", failures)
+
+	go func(email string) {
+		body := fmt.Sprintf("There were %d failed attempts in a row to log in to your account, so it is locked for %s.\n\n"+
+			"If it wasn't you, someone may be guessing your password. You can choose a new one with \"Forgot password\" at %s",
+			failures, lockout, appURL)
+		if err := mailer.Send(email, "Your account was locked", body); err != nil {
+			slog.Error("Error sending account lock e-mail", "error", err)
+		}
+	}(user.Email)
+}
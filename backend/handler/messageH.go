@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"social-network/backend/apierror"
+	"social-network/backend/model"
+)
+
+func SearchMsgH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cookie, err := r.Cookie("session_id")
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userID, err := model.GetUserIDBySessionID(db, cookie.Value)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error retrieving user ID", "error", err)
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		term := strings.TrimSpace(r.URL.Query().Get("q"))
+		if term == "" {
+			apierror.HTTPError(w, "Search term is required", http.StatusBadRequest)
+			return
+		}
+
+		limit, offset, err := pageParams(r)
+		if err != nil {
+			apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		results, err := model.SearchMessages(db, userID, term, limit, offset)
+		if err != nil {
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+// pageParams reads the limit and offset query parameters, defaulting to the first 20 results
+func pageParams(r *http.Request) (int, int, error) {
+	limit := 20
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > 100 {
+			return 0, 0, errors.New("Invalid limit")
+		}
+	}
+
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("Invalid offset")
+		}
+	}
+
+	return limit, offset, nil
+}
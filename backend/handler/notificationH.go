@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"social-network/backend/apierror"
+	"social-network/backend/model"
+)
+
+func GetNotificationsH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		limit, offset, err := pageParams(r)
+		if err != nil {
+			apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		notifications, err := model.GetNotifications(db, userID, limit, offset)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error fetching notifications", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(notifications)
+	}
+}
+
+// ReadNotificationsH marks notifications as read, all of them when no IDs are sent
+func ReadNotificationsH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			NotificationIDs []int `json:"notificationIds"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		if err := model.MarkNotificationsRead(db, userID, req.NotificationIDs); err != nil {
+			slog.ErrorContext(r.Context(), "Error marking notifications read", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"social-network/backend/apierror"
+	"social-network/backend/mail"
+	"social-network/backend/model"
+)
+
+// ChangePasswordH changes the user's password and signs out their other sessions,
+// POST /api/password/change {currentPassword, newPassword}
+func ChangePasswordH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cookie, err := r.Cookie("session_id")
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		userID, err := model.GetUserIDBySessionID(db, cookie.Value)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			CurrentPassword string `json:"currentPassword"`
+			NewPassword     string `json:"newPassword"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		switch err := model.ChangePassword(db, userID, cookie.Value, req.CurrentPassword, req.NewPassword); err {
+		case nil:
+		case model.ErrWrongPassword:
+			apierror.HTTPError(w, err.Error(), http.StatusForbidden)
+			return
+		case model.ErrWeakPassword:
+			apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
+			return
+		default:
+			slog.ErrorContext(r.Context(), "Error changing password", "user_id", userID, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+// RequestPasswordResetH e-mails a reset link to the address when it belongs to a user, POST /api/password/forgot {email}.
+// The response is the same whether or not it does, so it can't be used to find out who has an account.
+func RequestPasswordResetH(db *sql.DB, mailer mail.Mailer, appURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Email string `json:"email"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		token, err := model.CreatePasswordReset(db, req.Email)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error creating password reset", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// Sending happens in the background so the response takes as long for unknown addresses
+		if token != "" {
+			link := appURL + "/reset-password?token=" + url.QueryEscape(token)
+			go func(email string) {
+				body := "Someone asked to reset the password of your account.\n\n" +
+					"Open this link within an hour to choose a new password:\n" + link + "\n\n" +
+					"If it wasn't you, you can ignore this e-mail."
+				if err := mailer.Send(email, "Reset your password", body); err != nil {
+					slog.ErrorContext(r.Context(), "Error sending password reset e-mail", "error", err)
+				}
+			}(req.Email)
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+// ResetPasswordH sets a new password with a reset token and signs out every session of the user,
+// POST /api/password/reset {token, newPassword}
+func ResetPasswordH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Token       string `json:"token"`
+			NewPassword string `json:"newPassword"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		switch err := model.ResetPassword(db, req.Token, req.NewPassword); err {
+		case nil:
+		case model.ErrInvalidResetToken, model.ErrWeakPassword:
+			apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
+			return
+		default:
+			slog.ErrorContext(r.Context(), "Error resetting password", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
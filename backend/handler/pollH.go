@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"social-network/backend/apierror"
+	"social-network/backend/chat"
+	"social-network/backend/model"
+)
+
+// VotePollH records a vote and pushes the new tallies to connected users, POST /api/poll/vote {pollId, optionIds}
+func VotePollH(db *sql.DB, wsServer *chat.WSServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			PollID    int   `json:"pollId"`
+			OptionIDs []int `json:"optionIds"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		_, err = model.VotePoll(db, req.PollID, userID, req.OptionIDs)
+		switch err {
+		case nil:
+		case model.ErrPollNotFound:
+			apierror.HTTPError(w, "Poll not found", http.StatusNotFound)
+			return
+		case model.ErrNotGroupMember:
+			apierror.HTTPError(w, "Only group members can vote", http.StatusForbidden)
+			return
+		case model.ErrPollClosed:
+			apierror.HTTPError(w, "Poll is closed", http.StatusConflict)
+			return
+		case model.ErrAlreadyVoted:
+			apierror.HTTPError(w, "Already voted", http.StatusConflict)
+			return
+		case model.ErrInvalidVote:
+			apierror.HTTPError(w, "Invalid vote", http.StatusBadRequest)
+			return
+		default:
+			slog.ErrorContext(r.Context(), "Error voting on poll", "poll_id", req.PollID, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		poll, err := model.GetPoll(db, req.PollID, userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error fetching poll", "poll_id", req.PollID, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		go wsServer.PushPollUpdate(db, req.PollID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(poll)
+	}
+}
+
+// GetPollH returns a poll as the viewer sees it, GET /api/poll?pollId=
+func GetPollH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		pollID, err := strconv.Atoi(param(r, "pollId"))
+		if err != nil {
+			apierror.HTTPError(w, "Invalid pollId", http.StatusBadRequest)
+			return
+		}
+
+		poll, err := model.GetPoll(db, pollID, userID)
+		if err == nil {
+			if visible, verr := model.CanViewPost(db, userID, poll.PostID); verr != nil || !visible {
+				err = model.ErrPollNotFound
+			}
+		}
+		switch err {
+		case nil:
+		case model.ErrPollNotFound:
+			apierror.HTTPError(w, "Poll not found", http.StatusNotFound)
+			return
+		default:
+			slog.ErrorContext(r.Context(), "Error fetching poll", "poll_id", pollID, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(poll)
+	}
+}
@@ -6,38 +6,25 @@ import (
 	"database/sql"
 	"encoding/json"
 	"github.com/google/uuid"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"time"
 
-	"social-network/backend/auth"
+	"social-network/backend/apierror"
 	"social-network/backend/datab"
 	"social-network/backend/model"
+	"social-network/backend/ratelimit"
 )
 
-func CreatePH(db *sql.DB, storageClient *storage.Client, bucketName string) http.HandlerFunc {
+func CreatePH(db *sql.DB, storageClient *storage.Client, bucketName string, limits *ratelimit.Limiters) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth.EnableCors(&w)
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		cookie, err := r.Cookie("session_id")
+		userID, err := sessionUserID(db, r)
 		if err != nil {
-			log.Printf("Error retrieving session: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		sessionID := cookie.Value
-
-		// Retrieve the user_id based on the session_id
-		userID, err := model.GetUserIDBySessionID(db, sessionID)
-		if err != nil {
-			log.Printf("Error retrieving user ID: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		if !requireVerified(db, w, userID) || !allow(w, limits.Posts, ratelimit.UserKey(userID)) {
 			return
 		}
 
@@ -46,33 +33,40 @@ func CreatePH(db *sql.DB, storageClient *storage.Client, bucketName string) http
 		file, header, err := r.FormFile("image")
 		if err == nil {
 			defer file.Close()
-			newFileName := "posts/" + uuid.New().String() + "_" + header.Filename
+			newFileName := "posts/" + uuid.New().String() + "_" + objectFileName(header.Filename)
 			imageURL, err = datab.StoreToCloud(context.Background(), storageClient, bucketName, newFileName, file)
 			if err != nil {
-				log.Printf("Failed to upload image: %v", err)
-				http.Error(w, "Failed to upload image", http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "Failed to upload image", "error", err)
+				apierror.HTTPError(w, "Failed to upload image", http.StatusInternalServerError)
 				return
 			}
 		} else if err != http.ErrMissingFile {
 			// Handle other errors
-			http.Error(w, "Error processing image file", http.StatusBadRequest)
+			apierror.HTTPError(w, "Error processing image file", http.StatusBadRequest)
 			return
 		}
 
-		allowedViewers := r.FormValue("selectedUserIds")
-		if allowedViewers == "" {
-			allowedViewers = "[]" // Use an empty JSON array to represent no viewers
+		// The viewers of an almost private post are stored as a JSON array of user IDs, which the privacy checks
+		// read back, so only a well-formed one is kept
+		var selectedUserIDs []int
+		if selected := r.FormValue("selectedUserIds"); selected != "" {
+			if err := json.Unmarshal([]byte(selected), &selectedUserIDs); err != nil {
+				apierror.HTTPError(w, "Invalid selectedUserIds", http.StatusBadRequest)
+				return
+			}
+		}
+		allowedViewers := []byte("[]")
+		if len(selectedUserIDs) > 0 {
+			allowedViewers, _ = json.Marshal(selectedUserIDs)
 		}
-
-		log.Printf("Allowed Viewers: %v", allowedViewers)
 
 		groupIDParam := r.FormValue("groupID")
 		var groupID sql.NullInt64
 		if groupIDParam != "" {
 			groupIDInt, err := strconv.Atoi(groupIDParam) // Convert string to int
 			if err != nil {
-				log.Printf("Error converting groupID to int: %v", err)
-				http.Error(w, "Invalid groupID", http.StatusBadRequest)
+				slog.ErrorContext(r.Context(), "Error converting groupID to int", "error", err)
+				apierror.HTTPError(w, "Invalid groupID", http.StatusBadRequest)
 				return
 			}
 			groupID = sql.NullInt64{Int64: int64(groupIDInt), Valid: true}
@@ -80,27 +74,76 @@ func CreatePH(db *sql.DB, storageClient *storage.Client, bucketName string) http
 			groupID = sql.NullInt64{Valid: false} // GroupID is null
 		}
 
+		// A post is kept as a draft when asked to, or when it is scheduled with an RFC 3339 publishAt
+		draft := r.FormValue("draft") == "true"
+		var publishAt *time.Time
+		if publishAtParam := r.FormValue("publishAt"); publishAtParam != "" {
+			at, err := time.Parse(time.RFC3339, publishAtParam)
+			if err != nil {
+				apierror.HTTPError(w, "Invalid publishAt", http.StatusBadRequest)
+				return
+			}
+			publishAt = &at
+			draft = true
+		}
+
+		// A poll is sent as a JSON form field next to the post content
+		var poll *model.NewPoll
+		if pollParam := r.FormValue("poll"); pollParam != "" {
+			poll = &model.NewPoll{}
+			if err := json.Unmarshal([]byte(pollParam), poll); err != nil || model.ValidatePoll(poll) != nil {
+				apierror.HTTPError(w, "Invalid poll", http.StatusBadRequest)
+				return
+			}
+		}
+
 		newPost := model.Post{
 			UserID:         userID,
 			Content:        r.FormValue("content"),
 			PrivacySetting: r.FormValue("privacy"),
 			ImageURL:       imageURL,
-			AllowedViewers: allowedViewers,
+			AllowedViewers: string(allowedViewers),
 			GroupID:        groupID,
 		}
 
-		createdPost, err := model.CreatePost(db, newPost)
-		if err != nil {
-			log.Printf("Error creating post: %v", err)
-			http.Error(w, "Error creating post", http.StatusInternalServerError)
+		var createdPost *model.Post
+		if draft {
+			createdPost, err = model.CreateDraft(db, newPost, publishAt)
+		} else {
+			createdPost, err = model.CreatePost(db, newPost)
+		}
+		if err == model.ErrInvalidPublishAt {
+			apierror.HTTPError(w, "publishAt must be in the future", http.StatusBadRequest)
+			return
+		} else if err == model.ErrNotGroupAdmin {
+			apierror.HTTPError(w, "Only group admins can schedule group posts", http.StatusForbidden)
 			return
+		} else if err != nil {
+			slog.ErrorContext(r.Context(), "Error creating post", "error", err)
+			apierror.HTTPError(w, "Error creating post", http.StatusInternalServerError)
+			return
+		}
+
+		if poll != nil {
+			if err := model.CreatePoll(db, createdPost.PostID, *poll); err != nil {
+				slog.ErrorContext(r.Context(), "Error creating poll", "error", err)
+				if err := model.DeletePost(db, createdPost.PostID, userID); err != nil {
+					slog.ErrorContext(r.Context(), "Error deleting post without its poll", "post_id", createdPost.PostID, "error", err)
+				}
+				apierror.HTTPError(w, "Error creating post", http.StatusInternalServerError)
+				return
+			}
+			createdPost.Poll, err = model.GetPollForPost(db, createdPost.PostID, userID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error fetching poll", "post_id", createdPost.PostID, "error", err)
+			}
 		}
 
 		// Respond with the newly created post
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(createdPost); err != nil {
-			log.Printf("Error sending post response: %v", err)
-			http.Error(w, "Error sending post response", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error sending post response", "error", err)
+			apierror.HTTPError(w, "Error sending post response", http.StatusInternalServerError)
 			return
 		}
 	}
@@ -108,29 +151,23 @@ func CreatePH(db *sql.DB, storageClient *storage.Client, bucketName string) http
 
 func GetPH(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth.EnableCors(&w) // Make sure to adjust EnableCors to accept *http.Request if needed
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		groupID := r.URL.Query().Get("groupID")
+		groupID := param(r, "groupID")
 		var posts []model.Post
 		var err error
 
+		viewerID, _ := sessionUserID(db, r)
+
 		if groupID != "" {
 			// Fetch posts for a specific group
-			posts, err = model.GetGroupPosts(db, groupID)
+			posts, err = model.GetGroupPosts(db, groupID, viewerID)
 		} else {
 			// Fetch posts that don't belong to any group
-			posts, err = model.GetPosts(db)
+			posts, err = model.GetPosts(db, viewerID)
 		}
 
 		if err != nil {
-			log.Printf("Error fetching posts: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error fetching posts", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
@@ -138,3 +175,188 @@ func GetPH(db *sql.DB) http.HandlerFunc {
 		json.NewEncoder(w).Encode(posts)
 	}
 }
+
+func EditPH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			PostID  int    `json:"postId"`
+			Content string `json:"content"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		// Only the author can edit a post
+		err = model.UpdatePost(db, req.PostID, userID, req.Content)
+		if err == model.ErrPostNotFound {
+			apierror.HTTPError(w, "Post not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+func DeletePH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			PostID int `json:"postId"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		// Only the author can delete a post
+		err = model.DeletePost(db, req.PostID, userID)
+		if err == model.ErrPostNotFound {
+			apierror.HTTPError(w, "Post not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+// HomeFeedH returns the viewer's home feed, GET /api/feed?mode=latest|top&limit=&cursor=
+func HomeFeedH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewerID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		mode := r.URL.Query().Get("mode")
+		if mode == "" {
+			mode = model.FeedModeLatest
+		}
+		if mode != model.FeedModeLatest && mode != model.FeedModeTop {
+			apierror.HTTPError(w, "Invalid mode", http.StatusBadRequest)
+			return
+		}
+
+		limit, _, err := pageParams(r)
+		if err != nil {
+			apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		posts, nextCursor, err := model.GetHomeFeed(db, viewerID, mode, limit, r.URL.Query().Get("cursor"))
+		if err == model.ErrInvalidCursor {
+			apierror.HTTPError(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"posts":      posts,
+			"nextCursor": nextCursor,
+		})
+	}
+}
+
+// RepostH shares a public post with the user's audience, optionally with quote text
+func RepostH(db *sql.DB, limits *ratelimit.Limiters) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !requireVerified(db, w, userID) || !allow(w, limits.Posts, ratelimit.UserKey(userID)) {
+			return
+		}
+
+		var req struct {
+			PostID          int    `json:"postId"`
+			Content         string `json:"content"` // optional quote text
+			Privacy         string `json:"privacy"`
+			SelectedUserIds []int  `json:"selectedUserIds"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		if req.Privacy == "" {
+			req.Privacy = "public"
+		}
+		if req.Privacy != "public" && req.Privacy != "private" && req.Privacy != "almost_private" {
+			apierror.HTTPError(w, "Invalid privacy", http.StatusBadRequest)
+			return
+		}
+
+		allowedViewers := []byte("[]")
+		if req.Privacy == "almost_private" && len(req.SelectedUserIds) > 0 {
+			allowedViewers, _ = json.Marshal(req.SelectedUserIds)
+		}
+
+		repost, err := model.CreateRepost(db, model.Post{
+			UserID:         userID,
+			Content:        req.Content,
+			PrivacySetting: req.Privacy,
+			AllowedViewers: string(allowedViewers),
+			RepostOfPostID: req.PostID,
+		})
+		switch err {
+		case nil:
+		case model.ErrPostNotFound:
+			apierror.HTTPError(w, "Post not found", http.StatusNotFound)
+			return
+		case model.ErrCannotRepost:
+			apierror.HTTPError(w, "Only public posts can be reposted", http.StatusForbidden)
+			return
+		default:
+			slog.ErrorContext(r.Context(), "Error reposting post", "post_id", req.PostID, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(repost)
+	}
+}
@@ -1,90 +1,92 @@
 package handler
 
 import (
+	"cloud.google.com/go/storage"
+	"context"
 	"database/sql"
 	"encoding/json"
-	"log"
+	"github.com/google/uuid"
+	"log/slog"
 	"net/http"
-	"social-network/backend/auth"
+	"social-network/backend/apierror"
+	"social-network/backend/datab"
 	"social-network/backend/model"
 	"strconv"
+	"time"
 )
 
 func GetUserPH(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth.EnableCors(&w)
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		log.Println("-------------- Inside GetUserPH ------------------")
 
-		userIdStr := r.URL.Query().Get("userId")
+		userIdStr := param(r, "userId")
 		if userIdStr == "" {
-			http.Error(w, "User ID is required", http.StatusBadRequest)
+			apierror.HTTPError(w, "User ID is required", http.StatusBadRequest)
 			return
 		}
 
 		userId, err := strconv.Atoi(userIdStr)
 		if err != nil {
-			log.Printf("Error converting userId to int: %v", err)
-			http.Error(w, "Invalid User ID", http.StatusBadRequest)
+			slog.ErrorContext(r.Context(), "Error converting userId to int", "error", err)
+			apierror.HTTPError(w, "Invalid User ID", http.StatusBadRequest)
+			return
+		}
+
+		// Blocked users don't get to see each other's posts, and the others only the posts their privacy allows.
+		// Without a session the viewer is 0, who only sees public posts.
+		viewerID, _ := sessionUserID(db, r)
+		blocked, err := model.IsBlocked(db, viewerID, userId)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error checking blocked users", "error", err)
+			apierror.HTTPError(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if blocked {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode([]model.Post{})
 			return
 		}
 
-		posts, err := model.FetchPostsByUserID(db, userId)
+		posts, err := model.FetchPostsByUserID(db, userId, viewerID)
 		if err != nil {
-			log.Printf("Error fetching posts for user %d: %v", userId, err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error fetching posts of user", "user_id", userId, "error", err)
+			apierror.HTTPError(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(posts); err != nil {
-			log.Printf("Error encoding response: %v", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error encoding response", "error", err)
+			apierror.HTTPError(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		}
 	}
 }
 
 func GetFollowH(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth.EnableCors(&w)
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		log.Println("-------------- Inside GetUserPH ------------------")
 
-		userIdStr := r.URL.Query().Get("userId")
+		userIdStr := param(r, "userId")
 		if userIdStr == "" {
-			http.Error(w, "User ID is required", http.StatusBadRequest)
+			apierror.HTTPError(w, "User ID is required", http.StatusBadRequest)
 			return
 		}
 
 		userId, err := strconv.Atoi(userIdStr)
 		if err != nil {
-			log.Printf("Error converting userId to int: %v", err)
-			http.Error(w, "Invalid User ID", http.StatusBadRequest)
+			slog.ErrorContext(r.Context(), "Error converting userId to int", "error", err)
+			apierror.HTTPError(w, "Invalid User ID", http.StatusBadRequest)
 			return
 		}
 
 		following, errFollowing := model.FetchFollowingByUserID(db, userId)
 		if errFollowing != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
 		// Fetch followers
 		followers, errFollowers := model.FetchFollowersByUserID(db, userId)
 		if errFollowers != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
@@ -99,23 +101,15 @@ func GetFollowH(db *sql.DB) http.HandlerFunc {
 
 func GetUserDetH(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth.EnableCors(&w)
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		userIDStr := r.URL.Query().Get("userId")
+		userIDStr := param(r, "userId")
 		if userIDStr == "" {
-			http.Error(w, "User ID is required", http.StatusBadRequest)
+			apierror.HTTPError(w, "User ID is required", http.StatusBadRequest)
 			return
 		}
 
 		userID, err := strconv.Atoi(userIDStr)
 		if err != nil {
-			http.Error(w, "Invalid User ID", http.StatusBadRequest)
+			apierror.HTTPError(w, "Invalid User ID", http.StatusBadRequest)
 			return
 		}
 
@@ -131,13 +125,17 @@ func GetUserDetH(db *sql.DB) http.HandlerFunc {
 			Gender         string `json:"gender"`
 			CreatedAt      string `json:"createdAt"`
 			ProfilePrivacy string `json:"profilePrivacy"`
+			DMPolicy       string `json:"dmPolicy"`
 		}
 
-		query := `SELECT UserID, Email, FirstName, LastName, DateOfBirth, ProfilePicture, Nickname, AboutMe, Gender, CreatedAt, ProfilePrivacy FROM User WHERE UserID = ?`
-		err = db.QueryRow(query, userID).Scan(&user.UserID, &user.Email, &user.FirstName, &user.LastName, &user.DateOfBirth, &user.ProfilePicture, &user.Nickname, &user.AboutMe, &user.Gender, &user.CreatedAt, &user.ProfilePrivacy)
-		if err != nil {
-			log.Printf("Error fetching user details: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		query := `SELECT UserID, Email, FirstName, LastName, DateOfBirth, ProfilePicture, Nickname, AboutMe, Gender, CreatedAt, ProfilePrivacy, IFNULL(DMPolicy, 'everyone') FROM User WHERE UserID = ?`
+		err = db.QueryRow(query, userID).Scan(&user.UserID, &user.Email, &user.FirstName, &user.LastName, &user.DateOfBirth, &user.ProfilePicture, &user.Nickname, &user.AboutMe, &user.Gender, &user.CreatedAt, &user.ProfilePrivacy, &user.DMPolicy)
+		if err == sql.ErrNoRows {
+			apierror.HTTPError(w, "User not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			slog.ErrorContext(r.Context(), "Error fetching user details", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
@@ -148,16 +146,8 @@ func GetUserDetH(db *sql.DB) http.HandlerFunc {
 
 func ToggleProPrivH(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth.EnableCors(&w)
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
 		if r.Method != "POST" {
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			apierror.HTTPError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
@@ -167,21 +157,66 @@ func ToggleProPrivH(db *sql.DB) http.HandlerFunc {
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Bad Request", http.StatusBadRequest)
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
 			return
 		}
 
 		// Ensure profilePrivacy is either "Private" or "Public"
 		if req.ProfilePrivacy != "Private" && req.ProfilePrivacy != "Public" {
-			http.Error(w, "Invalid profile privacy setting", http.StatusBadRequest)
+			apierror.HTTPError(w, "Invalid profile privacy setting", http.StatusBadRequest)
 			return
 		}
 
 		query := `UPDATE User SET ProfilePrivacy = ? WHERE UserID = ?`
 		_, err := db.Exec(query, req.ProfilePrivacy, req.UserID)
 		if err != nil {
-			log.Printf("Error updating profile privacy: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error updating profile privacy", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+func SetDMPolicyH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cookie, err := r.Cookie("session_id")
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userID, err := model.GetUserIDBySessionID(db, cookie.Value)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error retrieving user ID", "error", err)
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			DMPolicy string `json:"dmPolicy"` // everyone, following, mutuals or nobody
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		if !model.ValidDMPolicy(req.DMPolicy) {
+			apierror.HTTPError(w, "Invalid direct message policy", http.StatusBadRequest)
+			return
+		}
+
+		if err := model.SetDMPolicy(db, userID, req.DMPolicy); err != nil {
+			slog.ErrorContext(r.Context(), "Error updating direct message policy", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
@@ -189,3 +224,119 @@ func ToggleProPrivH(db *sql.DB) http.HandlerFunc {
 		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 	}
 }
+
+// ProfileH returns the user's own profile on GET and updates it on PATCH /api/profile. The update is a form with
+// any of firstName, lastName, nickname, aboutMe, gender and dateOfBirth (2006-01-02), a profilePicture file to
+// replace the avatar or removeProfilePicture=true to drop it. Fields that aren't sent stay as they are.
+func ProfileH(db *sql.DB, storageClient *storage.Client, bucketName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "PATCH" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method == "PATCH" {
+			if !updateProfile(db, storageClient, bucketName, userID, w, r) {
+				return
+			}
+		}
+
+		profile, err := model.GetProfile(db, userID)
+		if err != nil {
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(profile)
+	}
+}
+
+// updateProfile applies the PATCH form to the profile and reports whether it did; on failure it has written the error
+func updateProfile(db *sql.DB, storageClient *storage.Client, bucketName string, userID int, w http.ResponseWriter, r *http.Request) bool {
+	err := r.ParseMultipartForm(10 << 20) // Max upload size ~10MB
+	if err == http.ErrNotMultipart {
+		err = r.ParseForm()
+	}
+	if err != nil {
+		apierror.HTTPError(w, "Invalid form", http.StatusBadRequest)
+		return false
+	}
+
+	var update model.ProfileUpdate
+	formField := func(name string) *string {
+		if _, ok := r.PostForm[name]; !ok {
+			return nil
+		}
+		value := r.PostForm.Get(name)
+		return &value
+	}
+	update.FirstName = formField("firstName")
+	update.LastName = formField("lastName")
+	update.Nickname = formField("nickname")
+	update.AboutMe = formField("aboutMe")
+	update.Gender = formField("gender")
+	if dob := formField("dateOfBirth"); dob != nil {
+		parsedDOB, err := time.Parse("2006-01-02", *dob)
+		if err != nil {
+			apierror.HTTPError(w, "Invalid date of birth format", http.StatusBadRequest)
+			return false
+		}
+		update.DateOfBirth = &parsedDOB
+	}
+
+	if err := model.ValidateProfileUpdate(&update); err != nil {
+		apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	// The new avatar is uploaded before the update so a failed upload changes nothing
+	var newObject string
+	file, header, err := r.FormFile("profilePicture")
+	if err == nil {
+		defer file.Close()
+		newObject = "profilepics/" + uuid.New().String() + "_" + objectFileName(header.Filename)
+		profilePicURL, err := datab.StoreToCloud(context.Background(), storageClient, bucketName, newObject, file)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to upload profile picture", "error", err)
+			apierror.HTTPError(w, "Failed to upload profile picture", http.StatusInternalServerError)
+			return false
+		}
+		update.ProfilePicture = &profilePicURL
+	} else if err != http.ErrMissingFile {
+		apierror.HTTPError(w, "Error processing file", http.StatusBadRequest)
+		return false
+	} else if r.PostForm.Get("removeProfilePicture") == "true" {
+		empty := ""
+		update.ProfilePicture = &empty
+	}
+
+	oldPicture, err := model.UpdateProfile(db, userID, update)
+	if err != nil {
+		if newObject != "" {
+			if err := datab.DeleteFromCloud(context.Background(), storageClient, bucketName, newObject); err != nil {
+				slog.ErrorContext(r.Context(), "Failed to delete unused profile picture", "object", newObject, "error", err)
+			}
+		}
+		if err == model.ErrNicknameTaken {
+			apierror.HTTPError(w, err.Error(), http.StatusConflict)
+		} else {
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return false
+	}
+
+	// The replaced avatar is only deleted once nothing points at it anymore
+	if objectName, ok := datab.ObjectNameFromURL(bucketName, oldPicture); ok {
+		if err := datab.DeleteFromCloud(context.Background(), storageClient, bucketName, objectName); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to delete old profile picture", "object", objectName, "error", err)
+		}
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"social-network/backend/apierror"
+	"social-network/backend/model"
+)
+
+func ReportH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var report model.Report
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		report.ReporterUserID = userID
+		report.Details = strings.TrimSpace(report.Details)
+
+		created, err := model.CreateReport(db, report)
+		switch err {
+		case nil:
+		case model.ErrInvalidReport:
+			apierror.HTTPError(w, "Invalid report", http.StatusBadRequest)
+			return
+		case model.ErrTargetNotFound:
+			apierror.HTTPError(w, "Reported content not found", http.StatusNotFound)
+			return
+		default:
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	}
+}
+
+// GetReportsH lists the moderation queue, open reports by default
+func GetReportsH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := r.URL.Query().Get("status")
+		if status == "" {
+			status = "open"
+		}
+		if status != "open" && status != "actioned" && status != "dismissed" {
+			apierror.HTTPError(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+
+		limit, offset, err := pageParams(r)
+		if err != nil {
+			apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		reports, err := model.GetReports(db, status, limit, offset)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error fetching reports", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reports)
+	}
+}
+
+func ModerateH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		moderatorID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			ReportID int    `json:"reportId"`
+			Action   string `json:"action"` // hide, suspend or dismiss
+			Note     string `json:"note"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		err = model.ResolveReport(db, req.ReportID, moderatorID, req.Action, strings.TrimSpace(req.Note))
+		switch err {
+		case nil:
+		case model.ErrInvalidAction:
+			apierror.HTTPError(w, "Invalid Action", http.StatusBadRequest)
+			return
+		case model.ErrReportNotFound, model.ErrTargetNotFound, sql.ErrNoRows:
+			apierror.HTTPError(w, "Not found", http.StatusNotFound)
+			return
+		case model.ErrReportResolved:
+			apierror.HTTPError(w, "Report already resolved", http.StatusConflict)
+			return
+		default:
+			slog.ErrorContext(r.Context(), "Error resolving report", "report_id", req.ReportID, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+// GetAuditH returns the moderation audit trail, newest first
+func GetAuditH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, offset, err := pageParams(r)
+		if err != nil {
+			apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		actions, err := model.GetModerationActions(db, limit, offset)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error fetching moderation actions", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(actions)
+	}
+}
+
+func SetRoleH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		adminID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			UserId int    `json:"userId"`
+			Role   string `json:"role"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		// Admins can't demote themselves and leave the site without one
+		if req.UserId == adminID {
+			apierror.HTTPError(w, "Cannot change your own role", http.StatusBadRequest)
+			return
+		}
+
+		err = model.SetUserRole(db, adminID, req.UserId, req.Role)
+		switch err {
+		case nil:
+		case model.ErrInvalidAction:
+			apierror.HTTPError(w, "Invalid role", http.StatusBadRequest)
+			return
+		case model.ErrTargetNotFound:
+			apierror.HTTPError(w, "User not found", http.StatusNotFound)
+			return
+		default:
+			slog.ErrorContext(r.Context(), "Error setting role", "target_user_id", req.UserId, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
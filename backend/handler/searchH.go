@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"social-network/backend/apierror"
+	"social-network/backend/model"
+)
+
+// SearchH searches posts, comments and groups, GET /api/search?q=&type=post,comment,group&limit=&cursor=
+func SearchH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		viewerID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		text := strings.TrimSpace(r.URL.Query().Get("q"))
+		if text == "" {
+			apierror.HTTPError(w, "Search term is required", http.StatusBadRequest)
+			return
+		}
+
+		// Every type is searched unless the request narrows it down
+		types := []string{model.SearchTypePost, model.SearchTypeComment, model.SearchTypeGroup}
+		if typeParam := r.URL.Query().Get("type"); typeParam != "" {
+			types = nil
+			for _, searchType := range strings.Split(typeParam, ",") {
+				switch searchType = strings.TrimSpace(searchType); searchType {
+				case model.SearchTypePost, model.SearchTypeComment, model.SearchTypeGroup:
+					types = append(types, searchType)
+				default:
+					apierror.HTTPError(w, "Invalid type", http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		limit, _, err := pageParams(r)
+		if err != nil {
+			apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		results, nextCursor, err := model.SearchContent(db, viewerID, text, types, limit, r.URL.Query().Get("cursor"))
+		if err == model.ErrInvalidCursor {
+			apierror.HTTPError(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"results":    results,
+			"nextCursor": nextCursor,
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"social-network/backend/apierror"
+	"social-network/backend/model"
+)
+
+// TrendingTagsH returns the most used tags of the last hours, GET /api/tags/trending?hours=24&limit=10
+func TrendingTagsH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hours := 24
+		if hoursStr := r.URL.Query().Get("hours"); hoursStr != "" {
+			var err error
+			hours, err = strconv.Atoi(hoursStr)
+			if err != nil || hours < 1 || hours > 24*30 {
+				apierror.HTTPError(w, "Invalid hours", http.StatusBadRequest)
+				return
+			}
+		}
+
+		limit, _, err := pageParams(r)
+		if err != nil {
+			apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		tags, err := model.GetTrendingTags(db, time.Now().Add(-time.Duration(hours)*time.Hour), limit)
+		if err != nil {
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tags)
+	}
+}
+
+// TagPostsH returns the posts with a tag that the viewer may see, GET /api/tags/posts?tag=&limit=&offset=
+func TagPostsH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tag := strings.TrimSpace(param(r, "tag"))
+		if tag == "" {
+			apierror.HTTPError(w, "tag is required", http.StatusBadRequest)
+			return
+		}
+
+		limit, offset, err := pageParams(r)
+		if err != nil {
+			apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Anonymous viewers only see public posts
+		viewerID, _ := sessionUserID(db, r)
+
+		posts, err := model.GetTagPosts(db, viewerID, tag, limit, offset)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error fetching posts for tag", "tag", tag, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(posts)
+	}
+}
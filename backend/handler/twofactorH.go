@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"social-network/backend/apierror"
+	"social-network/backend/auth"
+	"social-network/backend/mail"
+	"social-network/backend/model"
+	"social-network/backend/ratelimit"
+)
+
+// TwoFactorStatusH returns whether two-factor authentication is on, GET /api/2fa
+func TwoFactorStatusH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		status, err := model.GetTwoFactorStatus(db, userID)
+		if err != nil {
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+// EnrollTwoFactorH starts setting up two-factor authentication, POST /api/2fa/enroll -> {secret, provisioningUri}
+func EnrollTwoFactorH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		enrollment, err := model.EnrollTwoFactor(db, userID)
+		if err == model.ErrTwoFactorEnabled {
+			apierror.HTTPError(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			slog.ErrorContext(r.Context(), "Error enrolling in two-factor authentication", "user_id", userID, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(enrollment)
+	}
+}
+
+// ConfirmTwoFactorH turns two-factor authentication on with a first code from the authenticator,
+// POST /api/2fa/confirm {code} -> {recoveryCodes}
+func ConfirmTwoFactorH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Code string `json:"code"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		recoveryCodes, err := model.ConfirmTwoFactor(db, userID, req.Code, time.Now())
+		switch err {
+		case nil:
+		case model.ErrInvalidCode, model.ErrTwoFactorNotEnrolled:
+			apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
+			return
+		case model.ErrTwoFactorEnabled:
+			apierror.HTTPError(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			slog.ErrorContext(r.Context(), "Error confirming two-factor authentication", "user_id", userID, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"recoveryCodes": recoveryCodes})
+	}
+}
+
+// DisableTwoFactorH turns two-factor authentication off, POST /api/2fa/disable {password}
+func DisableTwoFactorH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Password string `json:"password"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		switch err := model.DisableTwoFactor(db, userID, req.Password); err {
+		case nil:
+		case model.ErrWrongPassword:
+			apierror.HTTPError(w, err.Error(), http.StatusForbidden)
+			return
+		default:
+			slog.ErrorContext(r.Context(), "Error disabling two-factor authentication", "user_id", userID, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+// TwoFactorLoginH is the second login step, POST /api/login/2fa {challenge, code} or {challenge, recoveryCode}.
+// It signs the user in like LoginH does.
+func TwoFactorLoginH(db *sql.DB, limits *ratelimit.Limiters, mailer mail.Mailer, appURL string, sessionTTL time.Duration, security auth.SecurityConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Challenge    string `json:"challenge"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recoveryCode"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		if !allow(w, limits.LoginIP, ratelimit.ClientIP(r, limits.Config.TrustProxy)) {
+			return
+		}
+
+		userID, err := model.CompleteLoginChallenge(db, req.Challenge, req.Code, req.RecoveryCode, time.Now())
+		switch err {
+		case nil:
+		case model.ErrInvalidCode:
+			// Wrong codes count towards the lockout like wrong passwords
+			if user, err := model.GetUserByID(db, userID); err == nil {
+				recordLoginFailure(db, limits, mailer, appURL, user)
+			}
+			apierror.HTTPError(w, err.Error(), http.StatusUnauthorized)
+			return
+		case model.ErrInvalidChallenge:
+			apierror.HTTPError(w, err.Error(), http.StatusUnauthorized)
+			return
+		default:
+			slog.ErrorContext(r.Context(), "Error completing two-factor login", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		user, err := model.GetUserByID(db, userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error fetching user", "user_id", userID, "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if !checkLoginLock(db, w, userID) {
+			return
+		}
+
+		// The account may have been suspended since the password step
+		suspended, err := model.IsUserSuspended(db, userID)
+		if err != nil {
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if suspended {
+			apierror.HTTPError(w, "Account suspended", http.StatusForbidden)
+			return
+		}
+
+		startSession(db, w, r, user, sessionTTL, security)
+	}
+}
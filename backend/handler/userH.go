@@ -8,36 +8,59 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
+	"social-network/backend/apierror"
 	"social-network/backend/auth"
 	"social-network/backend/datab"
+	"social-network/backend/mail"
 	"social-network/backend/model"
+	"social-network/backend/ratelimit"
+	"social-network/backend/router"
 )
 
-func RegisterH(db *sql.DB, storageClient *storage.Client, bucketName string) http.HandlerFunc {
+// sessionUserID returns the ID of the user owning the session cookie of the request
+func sessionUserID(db *sql.DB, r *http.Request) (int, error) {
+	cookie, err := r.Cookie("session_id")
+	if err != nil {
+		return 0, err
+	}
+	return model.GetUserIDBySessionID(db, cookie.Value)
+}
+
+// param returns a parameter of the request from its path, like postID in /api/posts/{postID}/comments,
+// or else from the query or form, where the older paths take it
+func param(r *http.Request, name string) string {
+	if value := router.Param(r, name); value != "" {
+		return value
+	}
+	return r.FormValue(name)
+}
+
+func RegisterH(db *sql.DB, storageClient *storage.Client, bucketName string, mailer mail.Mailer, appURL string, limits *ratelimit.Limiters) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth.EnableCors(&w)
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
+		// Check the method of the request
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		log.Println("---------------- Inside RegisterH ----------------")
-		// Check the method of the request
-		if r.Method != "POST" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		if !allow(w, limits.Register, ratelimit.ClientIP(r, limits.Config.TrustProxy)) {
 			return
 		}
 
 		// Parse the multipart form
 		err := r.ParseMultipartForm(10 << 20) // Max upload size ~10MB
 		if err != nil {
-			http.Error(w, "File too large", http.StatusBadRequest)
+			apierror.HTTPError(w, "File too large", http.StatusBadRequest)
+			return
+		}
+
+		if err := model.ValidateEmail(r.FormValue("Email")); err != nil {
+			apierror.HTTPError(w, "Invalid email address", http.StatusBadRequest)
 			return
 		}
 
@@ -48,18 +71,18 @@ func RegisterH(db *sql.DB, storageClient *storage.Client, bucketName string) htt
 			defer file.Close()
 
 			// Generate a unique file name for the profile picture
-			newFileName := "profilepics/" + uuid.New().String() + "_" + header.Filename
+			newFileName := "profilepics/" + uuid.New().String() + "_" + objectFileName(header.Filename)
 
 			// Upload the profile picture to Google Cloud Storage
 			profilePicURL, err = datab.StoreToCloud(context.Background(), storageClient, bucketName, newFileName, file)
 			if err != nil {
-				log.Printf("Failed to upload profile picture: %v", err)
-				http.Error(w, fmt.Sprintf("Failed to upload profile picture: %v", err), http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "Failed to upload profile picture", "error", err)
+				apierror.HTTPError(w, fmt.Sprintf("Failed to upload profile picture: %v", err), http.StatusInternalServerError)
 				return
 			}
 		} else if err != http.ErrMissingFile {
 			// Handle other errors
-			http.Error(w, "Error processing file", http.StatusBadRequest)
+			apierror.HTTPError(w, "Error processing file", http.StatusBadRequest)
 			return
 		}
 
@@ -78,7 +101,7 @@ func RegisterH(db *sql.DB, storageClient *storage.Client, bucketName string) htt
 		if dob != "" {
 			parsedDOB, err := time.Parse("2006-01-02", dob)
 			if err != nil {
-				http.Error(w, "Invalid date of birth format", http.StatusBadRequest)
+				apierror.HTTPError(w, "Invalid date of birth format", http.StatusBadRequest)
 				return
 			}
 			newUser.DateOfBirth = parsedDOB
@@ -100,38 +123,43 @@ func RegisterH(db *sql.DB, storageClient *storage.Client, bucketName string) htt
 		}
 		newUser.ProfilePrivacy = profilePrivacy
 
-		log.Println("Received password for registration:", newUser.Password)
-		log.Println("New User:", newUser)
+		slog.DebugContext(r.Context(), "Registering user", "nickname", newUser.Nickname)
 
 		exists, err := model.UserExists(db, newUser.Email, newUser.Nickname)
 		if err != nil {
 			// Handle error, maybe log it and return an internal server error
-			http.Error(w, `{"error": "Error checking user existence"}`, http.StatusInternalServerError)
+			apierror.HTTPError(w, "Error checking user existence", http.StatusInternalServerError)
 			return
 		}
 
 		if exists {
-			http.Error(w, `{"error": "Email or nickname already in use"}`, http.StatusBadRequest)
+			apierror.HTTPError(w, "Email or nickname already in use", http.StatusBadRequest)
 			return
 		}
 
 		// Hashing the password
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), bcrypt.DefaultCost)
 		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			http.Error(w, `{"error": "Invalid login credentials"}`, http.StatusUnauthorized)
+			apierror.HTTPError(w, "Invalid password", http.StatusBadRequest)
 			return
 		}
-		log.Println("Generated hash for registration:", string(hashedPassword))
 
 		newUser.PasswordHash = string(hashedPassword)
 
 		// Inserting the User data into the datab
 		err = model.RegisterUser(db, &newUser)
 		if err != nil {
-			http.Error(w, `{"error": "Specific registration error message"}`, http.StatusBadRequest)
+			apierror.HTTPError(w, "Specific registration error message", http.StatusBadRequest)
 			return
 		} else {
+			// The account works right away; the link only has to be opened within the grace period
+			token, err := model.CreateEmailVerification(db, newUser.UserID, newUser.Email)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error creating email verification", "user_id", newUser.UserID, "error", err)
+			} else {
+				sendVerificationEmail(mailer, appURL, newUser.Email, token)
+			}
+
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusCreated)
 			json.NewEncoder(w).Encode(map[string]string{"status": "success"})
@@ -139,17 +167,8 @@ func RegisterH(db *sql.DB, storageClient *storage.Client, bucketName string) htt
 	}
 }
 
-func LoginH(db *sql.DB) http.HandlerFunc {
+func LoginH(db *sql.DB, limits *ratelimit.Limiters, mailer mail.Mailer, appURL string, sessionTTL time.Duration, security auth.SecurityConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth.EnableCors(&w)
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		log.Println("---------------- Inside LoginH ----------------")
 
 		// Create struct to match expected JSON
 		var creds struct {
@@ -159,197 +178,260 @@ func LoginH(db *sql.DB) http.HandlerFunc {
 
 		// Parse JSON from request body
 		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-			http.Error(w, "Error parsing JSON", http.StatusBadRequest)
+			apierror.HTTPError(w, "Error parsing JSON", http.StatusBadRequest)
 			return
 		}
 
-		log.Println("Retrieve credentials:", creds.Credential)
-		log.Println("Password:", creds.Password)
+		// Password guessing is limited from each address and against each account
+		if !allow(w, limits.LoginIP, ratelimit.ClientIP(r, limits.Config.TrustProxy)) ||
+			!allow(w, limits.LoginAccount, strings.ToLower(creds.Credential)) {
+			return
+		}
 
 		// Attempt to retrieve the user by email or nickname
 		user, err := model.GetUserByCredential(db, creds.Credential)
 		if err == sql.ErrNoRows {
 			// User with provided email or nickname does not exist
-			http.Error(w, `{"error": "Email or nickname does not exist"}`, http.StatusUnauthorized)
+			apierror.HTTPError(w, "Email or nickname does not exist", http.StatusUnauthorized)
 			return
 		} else if err != nil {
 			// Handle unexpected error
-			http.Error(w, `{"error": "Wrong password"}`, http.StatusInternalServerError)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// A locked account refuses even the right password until the lock ends
+		if !checkLoginLock(db, w, user.UserID) {
 			return
 		}
 
 		// Compare the provided password with the hashed password in the datab
 		err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(creds.Password))
 		if err != nil {
-			log.Printf("Password comparison failed for user '%s': %v", user.Email, err)
-			http.Error(w, `{"error": "Invalid password"}`, http.StatusInternalServerError)
+			slog.InfoContext(r.Context(), "Login with a wrong password", "user_id", user.UserID)
+			recordLoginFailure(db, limits, mailer, appURL, user)
+			apierror.HTTPError(w, "Invalid password", http.StatusUnauthorized)
 			return
 		}
 
-		// If password is correct, generate a new session ID
-		sessionID, err := model.GenerateSessionID()
+		suspended, err := model.IsUserSuspended(db, user.UserID)
 		if err != nil {
-			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if suspended {
+			apierror.HTTPError(w, "Account suspended", http.StatusForbidden)
 			return
 		}
 
-		// Set session expiration time
-		expiration := time.Now().Add(45 * time.Minute)
-
-		// Create session in the datab
-		err = model.CreateSession(db, sessionID, user.UserID, expiration)
-		if err != nil {
-			http.Error(w, "Failed to create session", http.StatusInternalServerError)
-			return
-		}
-
-		userInfo := map[string]interface{}{
-			"userID":         user.UserID,
-			"email":          user.Email,
-			"firstName":      user.FirstName,
-			"lastName":       user.LastName,
-			"dateOfBirth":    user.DateOfBirth,
-			"profilePicture": user.ProfilePicture,
-			"nickname":       user.Nickname,
-			"aboutMe":        user.AboutMe,
-			"gender":         user.Gender,
-			"createdAt":      user.CreatedAt,
-			"profilePrivacy": user.ProfilePrivacy,
-		}
-
-		// Set the session cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "session_id",
-			Value:    sessionID,
-			Expires:  expiration,
-			HttpOnly: false,
-			Path:     "/",
-			Secure:   r.TLS != nil, // Secure should be true when serving over HTTPS
-		})
-
-		// Respond with user data or a success message
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		err = json.NewEncoder(w).Encode(map[string]interface{}{
-			"status": "logged in",
-			"user":   userInfo,
-			// Include any other user info you want to return to the client
-		})
+		// With two-factor authentication on, the session is only issued after the second step
+		status, err := model.GetTwoFactorStatus(db, user.UserID)
 		if err != nil {
-			log.Printf("Error sending response: %v", err)
-			http.Error(w, "Failed to send response", http.StatusInternalServerError)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if status.Enabled {
+			challenge, err := model.CreateLoginChallenge(db, user.UserID, time.Now())
+			if err != nil {
+				apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"status":    "2fa required",
+				"challenge": challenge,
+			})
+			return
+		}
+
+		startSession(db, w, r, user, sessionTTL, security)
+	}
+}
+
+// startSession signs the user in: it creates the session, sets the cookie and responds with the user's data.
+// The session expires after sessionTTL without requests.
+func startSession(db *sql.DB, w http.ResponseWriter, r *http.Request, user *model.User, sessionTTL time.Duration, security auth.SecurityConfig) {
+	// Generate a new session ID
+	sessionID, err := model.GenerateSessionID()
+	if err != nil {
+		apierror.HTTPError(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	// Set session expiration time
+	expiration := time.Now().Add(sessionTTL)
+
+	// Create session in the datab
+	err = model.CreateSession(r.Context(), db, sessionID, user.UserID, expiration)
+	if err != nil {
+		apierror.HTTPError(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	if err := model.ResetLoginFailures(db, user.UserID); err != nil {
+		slog.ErrorContext(r.Context(), "Error resetting failed logins", "user_id", user.UserID, "error", err)
+	}
+
+	userInfo := map[string]interface{}{
+		"userID":         user.UserID,
+		"email":          user.Email,
+		"firstName":      user.FirstName,
+		"lastName":       user.LastName,
+		"dateOfBirth":    user.DateOfBirth,
+		"profilePicture": user.ProfilePicture,
+		"nickname":       user.Nickname,
+		"aboutMe":        user.AboutMe,
+		"gender":         user.Gender,
+		"createdAt":      user.CreatedAt,
+		"profilePrivacy": user.ProfilePrivacy,
+		"dmPolicy":       user.DMPolicy,
+		"role":           user.Role,
+		"verified":       user.Verified,
+	}
+
+	// Set the session cookie
+	http.SetCookie(w, security.SessionCookie(r, sessionID, expiration))
+
+	// Respond with user data or a success message
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "logged in",
+		"user":   userInfo,
+		// Include any other user info you want to return to the client
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error sending response", "error", err)
+		apierror.HTTPError(w, "Failed to send response", http.StatusInternalServerError)
+	}
+}
+
+// CSRFTokenH returns the CSRF token to send in the X-CSRF-Token header, GET /api/csrf.
+// Pages that can read the csrf_token cookie don't need it.
+func CSRFTokenH() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
 		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"token": auth.CSRFToken(r)})
 	}
 }
 
-func LogoutH(db *sql.DB) http.HandlerFunc {
+func LogoutH(db *sql.DB, security auth.SecurityConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth.EnableCors(&w)
 		// Retrieve sessionID from the cookie, assuming you have set it in a cookie
 		cookie, err := r.Cookie("session_id")
 		if err != nil {
-			log.Printf("Error retrieving session: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 		sessionID := cookie.Value
 
 		// Create an empty cookie with expiration time in the past
 		expiration := time.Unix(0, 0)
-		cookie = &http.Cookie{
-			Name:     "session_id",
-			Value:    "",
-			Expires:  expiration,
-			HttpOnly: true,
-			Secure:   true, // Set to true if you are using HTTPS
-			Path:     "/",
-		}
+		cookie = security.SessionCookie(r, "", expiration)
 
 		err = model.DeleteSession(db, sessionID) // sessionID is retrieved from the cookie
 		if err != nil {
-			log.Printf("Error deleting session: %v", err)
+			slog.ErrorContext(r.Context(), "Error deleting session", "error", err)
 			retryCount := 3
 			for i := 1; i <= retryCount; i++ {
 				err = model.DeleteSession(db, sessionID)
 				if err == nil {
 					break
 				}
-				log.Printf("Retry %d: Error deleting session: %v", i, err)
+				slog.WarnContext(r.Context(), "Error deleting session, retrying", "retry", i, "error", err)
 			}
 			if err != nil {
 				// Fails after 'retryCount' attempts
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
 			}
 		}
 
 		// Set the empty cookie to the HTTP response, effectively deleting the cookie
 		http.SetCookie(w, cookie)
 
-		log.Println("User logged out and session ended")
+		slog.DebugContext(r.Context(), "User logged out")
 	}
 }
 
 func FetchUseH(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth.EnableCors(&w)
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
 
-		log.Println("Fething users for group creation...")
+		// Anonymous requests get every user, blocked users are hidden from signed in users
+		viewerID, _ := sessionUserID(db, r)
 
-		users, err := model.FetchAllUsers(db)
+		users, err := model.FetchAllUsers(db, viewerID)
 		if err != nil {
-			log.Printf("Error fetching users: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error fetching users", "error", err)
+			apierror.HTTPError(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		if err := json.NewEncoder(w).Encode(users); err != nil {
-			log.Printf("Error encoding users to JSON: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error encoding users to JSON", "error", err)
+			apierror.HTTPError(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
 }
 
-func FollowH(db *sql.DB) http.HandlerFunc {
+// SearchUsersH searches users by nickname, name and about-me, GET /api/users/search?q=&limit=&offset=
+func SearchUsersH(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		auth.EnableCors(&w)
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
+		if r.Method != "GET" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		var req struct {
-			UserId int    `json:"userId"`
-			Action string `json:"action"`
+		viewerID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
 		}
 
-		err := json.NewDecoder(r.Body).Decode(&req)
+		text := strings.TrimSpace(r.URL.Query().Get("q"))
+		if text == "" {
+			apierror.HTTPError(w, "Search term is required", http.StatusBadRequest)
+			return
+		}
+
+		limit, offset, err := pageParams(r)
 		if err != nil {
-			http.Error(w, "Bad Request", http.StatusBadRequest)
+			apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		cookie, err := r.Cookie("session_id")
+		users, err := model.SearchUsers(db, viewerID, text, limit, offset)
 		if err != nil {
-			log.Printf("Error retrieving session: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
-		sessionID := cookie.Value
 
-		// Retrieve the user_id based on the session_id
-		userID, err := model.GetUserIDBySessionID(db, sessionID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(users)
+	}
+}
+
+func FollowH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			UserId int    `json:"userId"`
+			Action string `json:"action"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
-			log.Printf("Error retrieving user ID: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
@@ -359,13 +441,17 @@ func FollowH(db *sql.DB) http.HandlerFunc {
 		case "unfollow":
 			err = model.UnfollowUser(db, userID, req.UserId)
 		default:
-			http.Error(w, "Invalid Action", http.StatusBadRequest)
+			apierror.HTTPError(w, "Invalid Action", http.StatusBadRequest)
 			return
 		}
 
+		if err == model.ErrBlocked {
+			apierror.HTTPError(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 		if err != nil {
-			log.Printf("Error processing follow action: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error processing follow action", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"social-network/backend/apierror"
+	"social-network/backend/mail"
+	"social-network/backend/model"
+	"social-network/backend/ratelimit"
+)
+
+// sendVerificationEmail mails the verification link in the background
+func sendVerificationEmail(mailer mail.Mailer, appURL, email, token string) {
+	link := appURL + "/verify-email?token=" + url.QueryEscape(token)
+	go func() {
+		body := "Please confirm your email address by opening this link within 48 hours:\n" + link + "\n\n" +
+			"If you didn't sign up or change your email, you can ignore this e-mail."
+		if err := mailer.Send(email, "Confirm your email address", body); err != nil {
+			slog.Error("Error sending verification e-mail", "error", err)
+		}
+	}()
+}
+
+// verificationError writes the response for errors of issuing a verification e-mail
+func verificationError(w http.ResponseWriter, err error) {
+	if limited, ok := err.(*model.ErrTooManyVerificationEmails); ok {
+		seconds := ratelimit.SetRetryAfter(w, limited.RetryAfter)
+		apierror.Write(w, http.StatusTooManyRequests, "rate_limited", err.Error(), map[string]int{"retryAfter": seconds})
+		return
+	}
+	switch err {
+	case model.ErrInvalidEmail:
+		apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
+	case model.ErrWrongPassword:
+		apierror.HTTPError(w, err.Error(), http.StatusForbidden)
+	case model.ErrEmailTaken, model.ErrAlreadyVerified:
+		apierror.HTTPError(w, err.Error(), http.StatusConflict)
+	default:
+		slog.Error("Error issuing email verification", "error", err)
+		apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// VerifyEmailH confirms an email address with the token from the link, POST /api/email/verify {token}
+func VerifyEmailH(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req struct {
+			Token string `json:"token"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		switch err := model.VerifyEmail(db, req.Token); err {
+		case nil:
+		case model.ErrInvalidVerificationToken:
+			apierror.HTTPError(w, err.Error(), http.StatusBadRequest)
+			return
+		case model.ErrEmailTaken:
+			apierror.HTTPError(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			slog.ErrorContext(r.Context(), "Error verifying email", "error", err)
+			apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+// ResendVerificationH sends a new verification link for the user's unverified email, POST /api/email/resend
+func ResendVerificationH(db *sql.DB, mailer mail.Mailer, appURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		token, email, err := model.ResendVerification(db, userID)
+		if err != nil {
+			verificationError(w, err)
+			return
+		}
+		sendVerificationEmail(mailer, appURL, email, token)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+// ChangeEmailH sends a verification link to a new address, which replaces the user's email once confirmed,
+// POST /api/email/change {password, newEmail}
+func ChangeEmailH(db *sql.DB, mailer mail.Mailer, appURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userID, err := sessionUserID(db, r)
+		if err != nil {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Password string `json:"password"`
+			NewEmail string `json:"newEmail"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apierror.HTTPError(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		token, err := model.ChangeEmail(db, userID, req.Password, req.NewEmail)
+		if err != nil {
+			verificationError(w, err)
+			return
+		}
+		sendVerificationEmail(mailer, appURL, req.NewEmail, token)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+// requireVerified answers 403 and returns false when the user's unverified account is past its grace period
+func requireVerified(db *sql.DB, w http.ResponseWriter, userID int) bool {
+	switch err := model.RequireVerified(db, userID); err {
+	case nil:
+		return true
+	case model.ErrUnverified:
+		apierror.HTTPError(w, err.Error(), http.StatusForbidden)
+	default:
+		slog.Error("Error checking verification", "user_id", userID, "error", err)
+		apierror.HTTPError(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+	return false
+}
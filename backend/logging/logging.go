@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Config says what is logged and how
+type Config struct {
+	Level  slog.Level
+	Format string // "text" for people, or "json" for production
+}
+
+func DefaultConfig() Config {
+	return Config{Level: slog.LevelInfo, Format: "text"}
+}
+
+// ParseLevel reads a level like debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("%q is not debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// New returns a logger writing to w that adds the attributes of the context and redacts secrets
+func New(w io.Writer, config Config) *slog.Logger {
+	options := &slog.HandlerOptions{Level: config.Level, ReplaceAttr: redact}
+
+	var handler slog.Handler
+	if config.Format == "json" {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+	return slog.New(contextHandler{handler})
+}
+
+// Setup makes the logger of the config the default one, which the log package writes through as well
+func Setup(config Config) {
+	slog.SetDefault(New(os.Stderr, config))
+}
+
+// secretKeys are the attribute keys whose values never get logged
+var secretKeys = []string{"password", "hash", "secret", "token", "session", "cookie", "authorization", "recovery"}
+
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	key := strings.ToLower(attr.Key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return slog.String(attr.Key, "[REDACTED]")
+		}
+	}
+	return attr
+}
+
+// Fingerprint stands in for a secret, like a session ID, in the logs: the same secret always gets the same
+// fingerprint, but the secret can't be read back from it
+func Fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:4])
+}
+
+type contextKey struct{}
+
+// With returns a context whose logs carry the attributes, given like slog's key-value pairs
+func With(ctx context.Context, args ...any) context.Context {
+	attrs := append(attrsFrom(ctx), slog.Group("", args...).Value.Group()...)
+	return context.WithValue(ctx, contextKey{}, attrs)
+}
+
+func attrsFrom(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	// Copied so contexts derived from the same parent don't share the array
+	return append([]slog.Attr(nil), attrs...)
+}
+
+// contextHandler adds the attributes set with With to the records logged with a context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs := attrsFrom(ctx); len(attrs) > 0 {
+		record = record.Clone()
+		record.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,104 @@
+package mail
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mailer sends plain text e-mails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// Config says where e-mails go: through the SMTP server at SMTPHost, or to the LogMailer when it is empty
+type Config struct {
+	Dir          string // for the LogMailer
+	LogBodies    bool   // the LogMailer logs bodies too, with the links in them; only for development
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+}
+
+// New returns the mailer of the config
+func New(config Config) Mailer {
+	if config.SMTPHost == "" {
+		return NewLogMailer(config.Dir, config.LogBodies)
+	}
+	return NewSMTPMailer(config.SMTPHost, config.SMTPPort, config.SMTPUsername, config.SMTPPassword, config.From)
+}
+
+// SMTPMailer sends e-mails through an SMTP server, authenticating when a username is set
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	if port == "" {
+		port = "587"
+	}
+	return &SMTPMailer{Host: host, Port: port, Username: username, Password: password, From: from}
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, m.From, []string{to}, message(m.From, to, subject, body))
+}
+
+// LogMailer is for development and tests: it writes each e-mail to a file in Dir, or else logs its recipient and
+// subject. The body holds verification and password reset links, so it is only logged with LogBodies.
+type LogMailer struct {
+	Dir       string
+	LogBodies bool
+}
+
+func NewLogMailer(dir string, logBodies bool) *LogMailer {
+	return &LogMailer{Dir: dir, LogBodies: logBodies}
+}
+
+func (m *LogMailer) Send(to, subject, body string) error {
+	if m.Dir == "" {
+		if m.LogBodies {
+			slog.Info("Mail written to the log", "to", to, "subject", subject, "body", body)
+		} else {
+			slog.Info("Mail written to the log", "to", to, "subject", subject)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d_%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(to))
+	return os.WriteFile(filepath.Join(m.Dir, name), message("", to, subject, body), 0o600)
+}
+
+// headerValue keeps line breaks out of a header so it can't add headers of its own
+var headerValue = strings.NewReplacer("\r", "", "\n", "")
+
+func message(from, to, subject, body string) []byte {
+	var b strings.Builder
+	if from != "" {
+		b.WriteString("From: " + headerValue.Replace(from) + "\r\n")
+	}
+	b.WriteString("To: " + headerValue.Replace(to) + "\r\n")
+	b.WriteString("Subject: " + headerValue.Replace(subject) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(body)
+	return []byte(b.String())
+}
@@ -3,78 +3,302 @@ package main
 import (
 	"cloud.google.com/go/storage"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"google.golang.org/api/option"
 	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"social-network/backend/admin"
+	"social-network/backend/auth"
 	"social-network/backend/chat"
+	"social-network/backend/config"
 	"social-network/backend/datab"
 	"social-network/backend/handler"
+	"social-network/backend/logging"
+	"social-network/backend/mail"
+	"social-network/backend/metrics"
 	"social-network/backend/model"
+	"social-network/backend/ratelimit"
+	"social-network/backend/router"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "file with NAME=value settings, overridden by the environment")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-config file] [command]\n\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), "\n"+admin.Usage)
+	}
+	flag.Parse()
 
-	db, err := datab.ConnectDB()
+	cfg, err := config.Load(*configPath)
 	if err != nil {
-		log.Fatalf("Failed to connect to the datab: %v", err)
+		log.Fatalf("Invalid config: %v", err)
+	}
+	logging.Setup(cfg.Log)
+
+	// Without a command the server runs
+	if flag.NArg() > 0 {
+		os.Exit(admin.Run(cfg, flag.Args(), os.Stdout, os.Stderr))
 	}
-	defer db.Close()
 
-	err = datab.CreateTables(db)
+	db, err := datab.ConnectDB(cfg.DBPath)
 	if err != nil {
-		log.Fatalf("Failed to create tables: %v", err)
+		slog.Error("Failed to connect to the datab", "error", err)
+		os.Exit(1)
 	}
 
-	storageClient, err := storage.NewClient(context.Background(), option.WithCredentialsFile("datab/private/social-network-KEY.json"))
+	err = datab.CreateTables(db, cfg.SchemaPath)
 	if err != nil {
-		log.Fatalf("Failed to create storage client: %v", err)
+		slog.Error("Failed to create tables", "error", err)
+		os.Exit(1)
+	}
+	if fts5, err := datab.SetupSearch(db, cfg.MigrationsDir); err != nil {
+		slog.Error("Failed to set up the search indexes", "error", err)
+		os.Exit(1)
+	} else if !fts5 {
+		slog.Warn("SQLite has no FTS5, search falls back to plain matching; build with -tags sqlite_fts5")
+	}
+
+	// The code expects every migration applied, so a new or older datab is brought up to date first
+	applied, err := datab.Migrate(db, cfg.MigrationsDir)
+	for _, migration := range applied {
+		slog.Info("Applied migration", "migration", migration.Name)
 	}
+	if errors.Is(err, datab.ErrNoMigrationHistory) {
+		slog.Error("Can't tell which migrations the datab has; if every migration is applied, record them with migrate baseline <last version>", "error", err)
+		os.Exit(1)
+	} else if err != nil {
+		slog.Error("Failed to apply the migrations", "error", err)
+		os.Exit(1)
+	}
+
+	storageClient, err := storage.NewClient(context.Background(), option.WithCredentialsFile(cfg.CredentialsFile))
+	if err != nil {
+		slog.Error("Failed to create storage client", "error", err)
+		os.Exit(1)
+	}
+
+	// E-mails go to the log unless an SMTP server is set up
+	mailer := mail.New(cfg.Mail)
+	appURL := cfg.AppURL
+	bucket := cfg.Bucket
 
-	go model.CleanExpiredSessions(db)
+	limits := ratelimit.New(cfg.RateLimit)
 
-	wsServer := chat.NewWSServer()
+	// Background jobs run until the server stops
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobs sync.WaitGroup
+	jobs.Add(2)
+	go func() {
+		defer jobs.Done()
+		model.CleanExpiredSessions(jobsCtx, db, cfg.CleanupInterval)
+	}()
+	go func() {
+		defer jobs.Done()
+		model.PublishScheduledPosts(jobsCtx, db)
+	}()
+
+	wsServer := chat.NewWSServer(limits, cfg.Security)
 	go wsServer.Run()
 
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+	metrics.NewGaugeFunc("websocket_connections", "Open websocket connections.", func() float64 {
+		return float64(wsServer.ClientCount())
+	})
+	metrics.NewGaugeFunc("websocket_rooms", "Chat rooms with a client in them.", func() float64 {
+		return float64(wsServer.ActiveRoomCount())
+	})
+	metrics.NewGaugeFunc("sessions_active", "Sessions that haven't expired.", func() float64 {
+		count, err := model.CountActiveSessions(context.Background(), db)
+		if err != nil {
+			slog.Error("Error counting sessions", "error", err)
+			return math.NaN()
+		}
+		return float64(count)
+	})
+
+	rt := router.New()
+
+	rt.Get("/healthz", handler.HealthH())
+	rt.Get("/readyz", handler.ReadyH(db, storageClient, bucket))
+	if cfg.MetricsToken != "" {
+		rt.Get("/metrics", metrics.Handler(cfg.MetricsToken))
+	}
+
+	rt.Get("/ws", func(w http.ResponseWriter, r *http.Request) {
 		chat.ServeWs(db, wsServer, w, r)
 	})
 
-	http.HandleFunc("/api/register", handler.RegisterH(db, storageClient, "social-network-bucket"))
-	http.HandleFunc("/api/login", handler.LoginH(db))
-	http.HandleFunc("/api/logout", handler.LogoutH(db))
-
-	http.HandleFunc("/api/createPost", handler.CreatePH(db, storageClient, "social-network-bucket"))
-	http.HandleFunc("/api/posts", handler.GetPH(db))
-
-	http.HandleFunc("/api/createComment", handler.CrComHandler(db, storageClient, "social-network-bucket"))
-	http.HandleFunc("/api/getComments", handler.GePostComH(db))
-
-	http.HandleFunc("/api/createGroup", handler.CreateGrH(db))
-	http.HandleFunc("/api/groups", handler.GetGrH(db))
-	http.HandleFunc("/api/group/details", handler.FetchGrDetailH(db))
-	http.HandleFunc("/api/groupMembers", handler.FetchGrMemH(db))
-	http.HandleFunc("/api/createEvent", handler.CreateEvH(db))
-	http.HandleFunc("/api/events", handler.GetEvH(db))
-	http.HandleFunc("/api/joinGroup", handler.JoinGrH(db))
-	http.HandleFunc("/api/leaveGroup", handler.LeaveGrH(db))
-	http.HandleFunc("/api/inviteUsers", handler.InviteUserH(db))
-	http.HandleFunc("/api/invitedUsers", handler.GetInvUserH(db))
-
-	http.HandleFunc("/api/users", handler.FetchUseH(db))
-	http.HandleFunc("/api/following", handler.FollowH(db))
-	http.HandleFunc("/api/profilePosts", handler.GetUserPH(db))
-	http.HandleFunc("/api/userFollowing", handler.GetFollowH(db))
-	http.HandleFunc("/api/userDetails", handler.GetUserDetH(db))
-	http.HandleFunc("/api/toggleProfilePrivacy", handler.ToggleProPrivH(db))
-
-	http.Handle("/", http.FileServer(http.Dir("frontend/dist")))
-
-	url := "http://localhost:8091"
-	fmt.Println("Listening on", url)
-
-	http.ListenAndServe(":8091", nil)
-	fmt.Println("Listening on :8091...")
+	rt.Post("/api/register", handler.RegisterH(db, storageClient, bucket, mailer, appURL, limits))
+	rt.Post("/api/login", handler.LoginH(db, limits, mailer, appURL, cfg.SessionTTL, cfg.Security))
+	rt.Post("/api/login/2fa", handler.TwoFactorLoginH(db, limits, mailer, appURL, cfg.SessionTTL, cfg.Security))
+	rt.Post("/api/logout", handler.LogoutH(db, cfg.Security))
+	rt.Get("/api/csrf", handler.CSRFTokenH())
+	rt.Get("/api/2fa", handler.TwoFactorStatusH(db))
+	rt.Post("/api/2fa/enroll", handler.EnrollTwoFactorH(db))
+	rt.Post("/api/2fa/confirm", handler.ConfirmTwoFactorH(db))
+	rt.Post("/api/2fa/disable", handler.DisableTwoFactorH(db))
+	rt.Post("/api/password/change", handler.ChangePasswordH(db))
+	rt.Post("/api/password/forgot", handler.RequestPasswordResetH(db, mailer, appURL))
+	rt.Post("/api/password/reset", handler.ResetPasswordH(db))
+	rt.Post("/api/email/verify", handler.VerifyEmailH(db))
+	rt.Post("/api/email/resend", handler.ResendVerificationH(db, mailer, appURL))
+	rt.Post("/api/email/change", handler.ChangeEmailH(db, mailer, appURL))
+
+	rt.Post("/api/createPost", handler.CreatePH(db, storageClient, bucket, limits))
+	rt.Get("/api/posts", handler.GetPH(db))
+	rt.Get("/api/feed", handler.HomeFeedH(db))
+	rt.Post("/api/editPost", handler.EditPH(db))
+	rt.Post("/api/deletePost", handler.DeletePH(db))
+	rt.Post("/api/repost", handler.RepostH(db, limits))
+	rt.Get("/api/drafts", handler.GetDraftsH(db))
+	rt.Post("/api/draft", handler.DraftH(db))
+	rt.Get("/api/poll", handler.GetPollH(db))
+	rt.Post("/api/poll/vote", handler.VotePollH(db, wsServer))
+	rt.Post("/api/bookmark", handler.BookmarkH(db))
+	rt.Get("/api/bookmarks", handler.GetBookmarksH(db))
+	rt.Get("/api/bookmarks/collections", handler.CollectionsH(db))
+	rt.Post("/api/bookmarks/collections", handler.CollectionsH(db))
+
+	rt.Post("/api/createComment", handler.CrComHandler(db, storageClient, bucket, limits))
+	rt.Get("/api/getComments", handler.GePostComH(db))
+	rt.Post("/api/editComment", handler.EditComH(db))
+	rt.Post("/api/deleteComment", handler.DeleteComH(db))
+
+	rt.Get("/api/search", handler.SearchH(db))
+	rt.Get("/api/tags/trending", handler.TrendingTagsH(db))
+	rt.Get("/api/tags/posts", handler.TagPostsH(db))
+	rt.Get("/api/notifications", handler.GetNotificationsH(db))
+	rt.Post("/api/notifications/read", handler.ReadNotificationsH(db))
+
+	rt.Post("/api/createGroup", handler.CreateGrH(db))
+	rt.Get("/api/groups", handler.GetGrH(db))
+	rt.Post("/api/group/details", handler.FetchGrDetailH(db))
+	rt.Get("/api/groupMembers", handler.FetchGrMemH(db))
+	rt.Post("/api/createEvent", handler.CreateEvH(db))
+	rt.Get("/api/events", handler.GetEvH(db))
+	rt.Post("/api/joinGroup", handler.JoinGrH(db))
+	rt.Post("/api/leaveGroup", handler.LeaveGrH(db))
+	rt.Post("/api/inviteUsers", handler.InviteUserH(db))
+	rt.Post("/api/invitedUsers", handler.GetInvUserH(db))
+
+	rt.Get("/api/messages/search", handler.SearchMsgH(db))
+	rt.Post("/api/chat/upload", handler.UploadChatAttH(db, storageClient, bucket, limits))
+	rt.Get("/api/chat/attachment", handler.GetChatAttH(db, storageClient, bucket))
+
+	rt.Get("/api/users", handler.FetchUseH(db))
+	rt.Get("/api/users/search", handler.SearchUsersH(db))
+	rt.Post("/api/following", handler.FollowH(db))
+	rt.Get("/api/profilePosts", handler.GetUserPH(db))
+	rt.Get("/api/userFollowing", handler.GetFollowH(db))
+	rt.Get("/api/userDetails", handler.GetUserDetH(db))
+	rt.Get("/api/profile", handler.ProfileH(db, storageClient, bucket))
+	rt.Patch("/api/profile", handler.ProfileH(db, storageClient, bucket))
+	rt.Post("/api/toggleProfilePrivacy", handler.ToggleProPrivH(db))
+	rt.Post("/api/dmPolicy", handler.SetDMPolicyH(db))
+	rt.Post("/api/block", handler.BlockH(db))
+	rt.Get("/api/blocked", handler.GetBlockedH(db))
+	rt.Post("/api/report", handler.ReportH(db))
+	rt.Get("/api/admin/reports", auth.ModeratorMiddleware(db, handler.GetReportsH(db)))
+	rt.Post("/api/admin/reports/action", auth.ModeratorMiddleware(db, handler.ModerateH(db)))
+	rt.Get("/api/admin/audit", auth.ModeratorMiddleware(db, handler.GetAuditH(db)))
+	rt.Post("/api/admin/setRole", auth.AdminMiddleware(db, handler.SetRoleH(db)))
+
+	// REST-style paths for the same handlers, next to the older ones above
+	rt.Post("/api/users", handler.RegisterH(db, storageClient, bucket, mailer, appURL, limits))
+	rt.Get("/api/users/{userId}", handler.GetUserDetH(db))
+	rt.Get("/api/users/{userId}/posts", handler.GetUserPH(db))
+	rt.Get("/api/users/{userId}/following", handler.GetFollowH(db))
+	rt.Post("/api/sessions", handler.LoginH(db, limits, mailer, appURL, cfg.SessionTTL, cfg.Security))
+	rt.Post("/api/sessions/2fa", handler.TwoFactorLoginH(db, limits, mailer, appURL, cfg.SessionTTL, cfg.Security))
+	rt.Delete("/api/sessions", handler.LogoutH(db, cfg.Security))
+	rt.Post("/api/posts", handler.CreatePH(db, storageClient, bucket, limits))
+	rt.Get("/api/posts/{postID}/comments", handler.GePostComH(db))
+	rt.Post("/api/posts/{postID}/comments", handler.CrComHandler(db, storageClient, bucket, limits))
+	rt.Get("/api/polls/{pollId}", handler.GetPollH(db))
+	rt.Get("/api/tags/{tag}/posts", handler.TagPostsH(db))
+	rt.Post("/api/groups", handler.CreateGrH(db))
+	rt.Get("/api/groups/{groupID}", handler.FetchGrDetailH(db))
+	rt.Get("/api/groups/{groupID}/posts", handler.GetPH(db))
+	rt.Get("/api/groups/{groupID}/members", handler.FetchGrMemH(db))
+	rt.Get("/api/groups/{groupID}/events", handler.GetEvH(db))
+	rt.Get("/api/chat/attachments/{id}", handler.GetChatAttH(db, storageClient, bucket))
+
+	rt.Handle(http.MethodGet, "/*", http.FileServer(http.Dir(cfg.StaticDir)))
+
+	server := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           logging.Middleware(metrics.Middleware(auth.CORSMiddleware(auth.CSRFMiddleware(rt, cfg.Security), rt.Methods, cfg.Security), rt.Pattern)),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		slog.Error("Failed to listen", "addr", cfg.Addr, "error", err)
+		os.Exit(1)
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Serve(listener)
+	}()
+	slog.Info("Listening", "addr", cfg.Addr, "url", appURL)
+
+	select {
+	case err := <-serverErr:
+		slog.Error("Server failed", "error", err)
+		os.Exit(1)
+	case <-signals.Done():
+		stopSignals() // a second signal kills the server right away
+	}
+
+	// Stop accepting connections, close the websockets and let in-flight requests finish, then stop the jobs and
+	// close the datab, all within the shutdown timeout
+	slog.Info("Shutting down", "timeout", cfg.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	wsClosed := make(chan struct{})
+	server.RegisterOnShutdown(func() {
+		wsServer.Shutdown(ctx)
+		close(wsClosed)
+	})
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("Requests didn't finish in time", "error", err)
+	}
+	<-wsClosed
+
+	stopJobs()
+	jobsDone := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		slog.Warn("Background jobs didn't stop in time")
+	}
+
+	if err := db.Close(); err != nil {
+		slog.Error("Failed to close the datab", "error", err)
+	}
+	slog.Info("Server stopped")
 }
 
 
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	httpRequests = NewCounterVec("http_requests_total",
+		"HTTP requests by method, route and status.", "method", "route", "status")
+	httpDuration = NewHistogramVec("http_request_duration_seconds",
+		"Time to serve HTTP requests by method and route.", DefaultBuckets, "method", "route")
+)
+
+// knownMethods are the methods counted under their own name; the rest count as "other", so clients can't add
+// label values at will
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true, http.MethodPatch: true,
+	http.MethodDelete: true, http.MethodOptions: true, http.MethodConnect: true, http.MethodTrace: true,
+}
+
+// Middleware counts and times the requests by route. route returns the pattern a path matched, like
+// /api/posts/{postID}, so that paths with IDs in them count towards the same route.
+func Middleware(next http.Handler, route func(path string) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		pattern := route(r.URL.Path)
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+		method := r.Method
+		if !knownMethods[method] {
+			method = "other"
+		}
+		httpRequests.With(method, pattern, strconv.Itoa(recorder.status)).Inc()
+		httpDuration.With(method, pattern).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder remembers the status of the response. It passes hijacking on, for websockets.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer can't be hijacked")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
@@ -0,0 +1,282 @@
+// Package metrics keeps counters, gauges and histograms of the server and writes them in the Prometheus text
+// format. Metrics register themselves when they are created.
+package metrics
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"social-network/backend/apierror"
+)
+
+// DefaultBuckets are the upper bounds in seconds of a latency histogram, from 5ms to 10s
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMutex sync.Mutex
+	registry      = map[string]metric{}
+)
+
+func register(name string, m metric) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("metrics: " + name + " registered twice")
+	}
+	registry[name] = m
+}
+
+// WriteTo writes every metric, sorted by name
+func WriteTo(w io.Writer) {
+	registryMutex.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	metrics := make([]metric, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		metrics[i] = registry[name]
+	}
+	registryMutex.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics to Prometheus, when it sends the token as a bearer token
+func Handler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			apierror.HTTPError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteTo(w)
+	}
+}
+
+// Counter only goes up
+type Counter struct {
+	value atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// Value returns the current count
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+// NewCounter returns a counter without labels
+func NewCounter(name, help string) *Counter {
+	vec := NewCounterVec(name, help)
+	return vec.With()
+}
+
+// CounterVec is a counter for each combination of label values
+type CounterVec struct {
+	family
+	counters map[string]*Counter
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	vec := &CounterVec{family: family{name: name, help: help, kind: "counter", labels: labels}, counters: map[string]*Counter{}}
+	register(name, vec)
+	return vec
+}
+
+// With returns the counter of the label values, given in the order of the label names
+func (vec *CounterVec) With(values ...string) *Counter {
+	key := vec.key(values)
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+	counter, ok := vec.counters[key]
+	if !ok {
+		counter = &Counter{}
+		vec.counters[key] = counter
+	}
+	return counter
+}
+
+func (vec *CounterVec) write(w io.Writer) {
+	vec.header(w)
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+	for _, key := range sortedKeys(vec.counters) {
+		fmt.Fprintf(w, "%s%s %d\n", vec.name, key, vec.counters[key].Value())
+	}
+}
+
+// GaugeFunc is a gauge whose value is read when the metrics are written
+type GaugeFunc struct {
+	family
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge reading its value from the function, which returns NaN when it can't tell
+func NewGaugeFunc(name, help string, value func() float64) *GaugeFunc {
+	gauge := &GaugeFunc{family: family{name: name, help: help, kind: "gauge"}, value: value}
+	register(name, gauge)
+	return gauge
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.value()))
+}
+
+// Histogram counts observations, like latencies, into buckets
+type Histogram struct {
+	mutex   sync.Mutex
+	buckets []float64
+	counts  []uint64 // per bucket, not cumulative
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	i := sort.SearchFloat64s(h.buckets, value)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += value
+}
+
+// NewHistogram returns a histogram without labels
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	vec := NewHistogramVec(name, help, buckets)
+	return vec.With()
+}
+
+// HistogramVec is a histogram for each combination of label values
+type HistogramVec struct {
+	family
+	buckets    []float64
+	histograms map[string]*Histogram
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	vec := &HistogramVec{
+		family:     family{name: name, help: help, kind: "histogram", labels: labels},
+		buckets:    buckets,
+		histograms: map[string]*Histogram{},
+	}
+	register(name, vec)
+	return vec
+}
+
+// With returns the histogram of the label values, given in the order of the label names
+func (vec *HistogramVec) With(values ...string) *Histogram {
+	key := vec.key(values)
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+	histogram, ok := vec.histograms[key]
+	if !ok {
+		histogram = &Histogram{buckets: vec.buckets, counts: make([]uint64, len(vec.buckets))}
+		vec.histograms[key] = histogram
+	}
+	return histogram
+}
+
+func (vec *HistogramVec) write(w io.Writer) {
+	vec.header(w)
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+	for _, key := range sortedKeys(vec.histograms) {
+		h := vec.histograms[key]
+		h.mutex.Lock()
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", vec.name, withLabel(key, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", vec.name, withLabel(key, "le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", vec.name, key, formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", vec.name, key, h.count)
+		h.mutex.Unlock()
+	}
+}
+
+// family is what the metrics of one name share
+type family struct {
+	mutex  sync.Mutex
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (f *family) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, strings.ReplaceAll(f.help, "\n", " "))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+// key formats the label values like {method="GET",status="200"}, which is also how they are written
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", f.name, len(f.labels), len(values)))
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = f.labels[i] + `="` + labelValue.Replace(value) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// withLabel adds a label to a key made by family.key
+func withLabel(key, name, value string) string {
+	pair := name + `="` + labelValue.Replace(value) + `"`
+	if key == "" {
+		return "{" + pair + "}"
+	}
+	return strings.TrimSuffix(key, "}") + "," + pair + "}"
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
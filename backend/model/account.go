@@ -0,0 +1,192 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidName        = errors.New("first and last name must be 1 to 50 characters")
+	ErrInvalidNickname    = errors.New("nickname must be 1 to 50 letters, digits or underscores")
+	ErrNicknameTaken      = errors.New("nickname already in use")
+	ErrInvalidAboutMe     = errors.New("about me must be at most 1000 characters")
+	ErrInvalidGender      = errors.New("gender must be Male, Female or empty")
+	ErrInvalidDateOfBirth = errors.New("date of birth must be in the past")
+	ErrUserNotFound       = errors.New("user not found")
+)
+
+// Nicknames follow the mention syntax so every user can be @mentioned
+var nicknamePattern = regexp.MustCompile(`^[\p{L}\p{N}_]{1,50}$`)
+
+// Profile is a user's own account profile with dates in a fixed format
+type Profile struct {
+	UserID         int       `json:"userID"`
+	Email          string    `json:"email"`
+	FirstName      string    `json:"firstName"`
+	LastName       string    `json:"lastName"`
+	DateOfBirth    string    `json:"dateOfBirth"` // 2006-01-02, empty when not given
+	ProfilePicture string    `json:"profilePicture"`
+	Nickname       string    `json:"nickname"`
+	AboutMe        string    `json:"aboutMe"`
+	Gender         string    `json:"gender"`
+	CreatedAt      time.Time `json:"createdAt"`
+	ProfilePrivacy string    `json:"profilePrivacy"`
+	DMPolicy       string    `json:"dmPolicy"`
+	Verified       bool      `json:"verified"`
+	PendingEmail   string    `json:"pendingEmail,omitempty"` // the address being changed to, until it is verified
+}
+
+// ProfileUpdate holds the profile fields to change; nil fields are left as they are
+type ProfileUpdate struct {
+	FirstName      *string
+	LastName       *string
+	Nickname       *string
+	AboutMe        *string
+	Gender         *string
+	DateOfBirth    *time.Time
+	ProfilePicture *string
+}
+
+// ValidateProfileUpdate trims the fields of the update and checks them
+func ValidateProfileUpdate(update *ProfileUpdate) error {
+	for _, name := range []*string{update.FirstName, update.LastName} {
+		if name == nil {
+			continue
+		}
+		*name = strings.TrimSpace(*name)
+		if *name == "" || utf8.RuneCountInString(*name) > 50 {
+			return ErrInvalidName
+		}
+	}
+
+	if update.Nickname != nil {
+		*update.Nickname = strings.TrimSpace(*update.Nickname)
+		if !nicknamePattern.MatchString(*update.Nickname) {
+			return ErrInvalidNickname
+		}
+	}
+
+	if update.AboutMe != nil {
+		*update.AboutMe = strings.TrimSpace(*update.AboutMe)
+		if utf8.RuneCountInString(*update.AboutMe) > 1000 {
+			return ErrInvalidAboutMe
+		}
+	}
+
+	if update.Gender != nil && *update.Gender != "" && *update.Gender != "Male" && *update.Gender != "Female" {
+		return ErrInvalidGender
+	}
+
+	if update.DateOfBirth != nil && !update.DateOfBirth.Before(time.Now()) {
+		return ErrInvalidDateOfBirth
+	}
+
+	return nil
+}
+
+// GetProfile returns the account profile of the user
+func GetProfile(db *sql.DB, userID int) (*Profile, error) {
+	var profile Profile
+	var dateOfBirth sql.NullTime
+	var profilePicture, nickname, aboutMe, gender sql.NullString
+	err := db.QueryRow(`SELECT UserID, Email, FirstName, LastName, DateOfBirth, ProfilePicture, Nickname, AboutMe, Gender, CreatedAt,
+	IFNULL(ProfilePrivacy, 'Public'), IFNULL(DMPolicy, 'everyone'), Verified
+	FROM User WHERE UserID = ?`, userID).Scan(&profile.UserID, &profile.Email, &profile.FirstName, &profile.LastName, &dateOfBirth,
+		&profilePicture, &nickname, &aboutMe, &gender, &profile.CreatedAt, &profile.ProfilePrivacy, &profile.DMPolicy, &profile.Verified)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		slog.Error("Error fetching profile", "user_id", userID, "error", err)
+		return nil, err
+	}
+
+	if dateOfBirth.Valid && !dateOfBirth.Time.IsZero() {
+		profile.DateOfBirth = dateOfBirth.Time.Format("2006-01-02")
+	}
+	profile.ProfilePicture = profilePicture.String
+	profile.Nickname = nickname.String
+	profile.AboutMe = aboutMe.String
+	profile.Gender = gender.String
+	profile.PendingEmail = pendingEmail(db, userID, profile.Email)
+	return &profile, nil
+}
+
+// UpdateProfile applies a validated update to the user's profile and returns the picture it replaced, if any.
+// Like UserExists at registration, a nickname can't be another user's nickname or email, so logins stay unambiguous.
+func UpdateProfile(db *sql.DB, userID int, update ProfileUpdate) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	var oldPicture sql.NullString
+	err = tx.QueryRow(`SELECT ProfilePicture FROM User WHERE UserID = ?`, userID).Scan(&oldPicture)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return "", ErrUserNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	if update.Nickname != nil {
+		var taken bool
+		err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM User WHERE (Email = ? OR Nickname = ?) AND UserID != ?)`,
+			*update.Nickname, *update.Nickname, userID).Scan(&taken)
+		if err != nil {
+			tx.Rollback()
+			return "", err
+		}
+		if taken {
+			tx.Rollback()
+			return "", ErrNicknameTaken
+		}
+	}
+
+	var sets []string
+	var args []interface{}
+	columns := []struct {
+		name  string
+		value *string
+	}{
+		{"FirstName", update.FirstName},
+		{"LastName", update.LastName},
+		{"Nickname", update.Nickname},
+		{"AboutMe", update.AboutMe},
+		{"Gender", update.Gender},
+		{"ProfilePicture", update.ProfilePicture},
+	}
+	for _, column := range columns {
+		if column.value != nil {
+			sets = append(sets, column.name+" = ?")
+			args = append(args, *column.value)
+		}
+	}
+	if update.DateOfBirth != nil {
+		sets = append(sets, "DateOfBirth = ?")
+		args = append(args, *update.DateOfBirth)
+	}
+
+	if len(sets) > 0 {
+		args = append(args, userID)
+		if _, err = tx.Exec(`UPDATE User SET `+strings.Join(sets, ", ")+` WHERE UserID = ?`, args...); err != nil {
+			slog.Error("Error updating profile", "user_id", userID, "error", err)
+			tx.Rollback()
+			return "", err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+
+	if update.ProfilePicture == nil || *update.ProfilePicture == oldPicture.String {
+		return "", nil
+	}
+	return oldPicture.String, nil
+}
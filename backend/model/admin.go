@@ -0,0 +1,357 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ConsoleUserID is the moderator recorded in the audit trail for actions taken with the admin commands
+// of the backend binary
+const ConsoleUserID = 0
+
+// ErrGroupNotFound is returned for a group ID no group has
+var ErrGroupNotFound = errors.New("group not found")
+
+// CreateUser registers a user with the same checks as the register endpoint and the profile settings, and the
+// password hashed. Accounts made by admins don't need their email verified.
+func CreateUser(db *sql.DB, user *User, password string) error {
+	if err := ValidateEmail(user.Email); err != nil {
+		return err
+	}
+	if err := ValidateProfileUpdate(&ProfileUpdate{FirstName: &user.FirstName, LastName: &user.LastName, Nickname: &user.Nickname}); err != nil {
+		return err
+	}
+	if err := ValidatePassword(password); err != nil {
+		return err
+	}
+
+	// Neither may match another user's email or nickname, so logins by either stay unambiguous
+	var emailTaken, nicknameTaken bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM User WHERE Email = ? OR Nickname = ?), EXISTS(SELECT 1 FROM User WHERE Email = ? OR Nickname = ?)`,
+		user.Email, user.Email, user.Nickname, user.Nickname).Scan(&emailTaken, &nicknameTaken)
+	if err != nil {
+		return err
+	}
+	if emailTaken {
+		return ErrEmailTaken
+	}
+	if nicknameTaken {
+		return ErrNicknameTaken
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = string(hashedPassword)
+	if user.ProfilePrivacy != "Private" {
+		user.ProfilePrivacy = "Public"
+	}
+
+	if err := RegisterUser(db, user); err != nil {
+		return err
+	}
+	_, err = db.Exec(`UPDATE User SET Verified = TRUE WHERE UserID = ?`, user.UserID)
+	user.Verified = err == nil
+	return err
+}
+
+// SetPassword sets a new password without asking for the current one, for admins, and ends all the user's sessions
+func SetPassword(db *sql.DB, userID int, password string) error {
+	if _, err := GetUserByID(db, userID); err == sql.ErrNoRows {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+	return setPassword(db, userID, password, "")
+}
+
+// SuspendUser suspends the account like the suspend moderation action, ends its sessions and records it in the
+// audit trail
+func SuspendUser(db *sql.DB, adminUserID, userID int) error {
+	return setSuspended(db, adminUserID, userID, true)
+}
+
+// UnsuspendUser lifts the suspension of the account and records it in the audit trail
+func UnsuspendUser(db *sql.DB, adminUserID, userID int) error {
+	return setSuspended(db, adminUserID, userID, false)
+}
+
+func setSuspended(db *sql.DB, adminUserID, userID int, suspended bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	action := "unsuspend"
+	if suspended {
+		action = ActionSuspend
+		err = suspendUser(tx, userID)
+	} else {
+		_, err = tx.Exec(`UPDATE User SET Suspended = FALSE WHERE UserID = ?`, userID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO ModerationActions (ModeratorUserID, Action, TargetType, TargetID) VALUES (?, ?, 'user', ?)`,
+		adminUserID, action, strconv.Itoa(userID))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteUser removes the account with everything the user wrote, the groups they created and their place in
+// other users' follows, chats and groups. Reports they filed and moderation actions they took are kept.
+func DeleteUser(db *sql.DB, userID int) error {
+	if _, err := GetUserByID(db, userID); err == sql.ErrNoRows {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	groupIDs, err := queryInts(tx, `SELECT GroupID FROM Cluster WHERE CreatorUserID = ?`, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, groupID := range groupIDs {
+		if err := deleteGroup(tx, groupID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	const posts = `SELECT PostID FROM Post WHERE UserID = ?`
+	const comments = `SELECT CommentID FROM Comment WHERE UserID = ? OR PostID IN (` + posts + `)`
+	statements := []struct {
+		query string
+		args  int // how many times the user ID is passed
+	}{
+		{`DELETE FROM CommentTags WHERE CommentID IN (` + comments + `)`, 2},
+		{`DELETE FROM CommentMentions WHERE CommentID IN (` + comments + `)`, 2},
+		{`DELETE FROM Comment WHERE CommentID IN (` + comments + `)`, 2},
+		{`DELETE FROM PollVotes WHERE UserID = ? OR PollID IN (SELECT PollID FROM Polls WHERE PostID IN (` + posts + `))`, 2},
+		{`DELETE FROM PollOptions WHERE PollID IN (SELECT PollID FROM Polls WHERE PostID IN (` + posts + `))`, 1},
+		{`DELETE FROM Polls WHERE PostID IN (` + posts + `)`, 1},
+		{`DELETE FROM PostTags WHERE PostID IN (` + posts + `)`, 1},
+		{`DELETE FROM PostMentions WHERE UserID = ? OR PostID IN (` + posts + `)`, 2},
+		{`DELETE FROM Bookmarks WHERE UserID = ? OR PostID IN (` + posts + `)`, 2},
+		{`DELETE FROM Post WHERE UserID = ?`, 1},
+		{`DELETE FROM CommentMentions WHERE UserID = ?`, 1},
+		{`DELETE FROM BookmarkCollections WHERE UserID = ?`, 1},
+		{`DELETE FROM Notification WHERE UserID = ? OR ActorUserID = ?`, 2},
+		{`DELETE FROM UserFollowers WHERE FollowerUserID = ? OR FollowingUserID = ?`, 2},
+		{`DELETE FROM FollowRequests WHERE FollowerUserID = ? OR FollowingUserID = ?`, 2},
+		{`DELETE FROM UserBlocks WHERE BlockerUserID = ? OR BlockedUserID = ?`, 2},
+		{`DELETE FROM UserMutes WHERE MuterUserID = ? OR MutedUserID = ?`, 2},
+		{`DELETE FROM MessagePermissions WHERE UserID = ? OR AllowedUserID = ?`, 2},
+		{`DELETE FROM MessageRequests WHERE SenderUserID = ? OR ReceiverUserID = ?`, 2},
+		{`DELETE FROM ChatAttachment WHERE UploaderUserID = ?`, 1},
+		{`DELETE FROM Message WHERE SenderUserID = ? OR ReceiverUserID = ?`, 2},
+		{`DELETE FROM Rooms WHERE User1ID = ? OR User2ID = ?`, 2},
+		{`DELETE FROM GroupChatMessage WHERE SenderUserID = ?`, 1},
+		{`DELETE FROM GroupChatRead WHERE UserID = ?`, 1},
+		{`DELETE FROM GroupMembers WHERE UserID = ?`, 1},
+		{`DELETE FROM GroupJoinRequests WHERE UserId = ?`, 1},
+		{`DELETE FROM InvitedUsers WHERE UserID = ?`, 1},
+		{`DELETE FROM UserEventResponse WHERE UserID = ?`, 1},
+		{`DELETE FROM Sessions WHERE UserID = ?`, 1},
+		{`DELETE FROM PasswordResets WHERE UserID = ?`, 1},
+		{`DELETE FROM EmailVerifications WHERE UserID = ?`, 1},
+		{`DELETE FROM EmailVerificationSends WHERE UserID = ?`, 1},
+		{`DELETE FROM LoginChallenges WHERE UserID = ?`, 1},
+		{`DELETE FROM RecoveryCodes WHERE UserID = ?`, 1},
+		{`DELETE FROM User WHERE UserID = ?`, 1},
+	}
+	for _, statement := range statements {
+		args := make([]interface{}, statement.args)
+		for i := range args {
+			args[i] = userID
+		}
+		if _, err := tx.Exec(statement.query, args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteGroup removes the group with its posts, events, chat and memberships
+func DeleteGroup(db *sql.DB, groupID int) error {
+	var exists bool
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM Cluster WHERE GroupID = ?)`, groupID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrGroupNotFound
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := deleteGroup(tx, groupID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteGroup(tx *sql.Tx, groupID int) error {
+	const posts = `SELECT PostID FROM Post WHERE GroupID = ?`
+	statements := []string{
+		`DELETE FROM CommentTags WHERE CommentID IN (SELECT CommentID FROM Comment WHERE PostID IN (` + posts + `))`,
+		`DELETE FROM CommentMentions WHERE CommentID IN (SELECT CommentID FROM Comment WHERE PostID IN (` + posts + `))`,
+		`DELETE FROM Comment WHERE PostID IN (` + posts + `)`,
+		`DELETE FROM PollVotes WHERE PollID IN (SELECT PollID FROM Polls WHERE PostID IN (` + posts + `))`,
+		`DELETE FROM PollOptions WHERE PollID IN (SELECT PollID FROM Polls WHERE PostID IN (` + posts + `))`,
+		`DELETE FROM Polls WHERE PostID IN (` + posts + `)`,
+		`DELETE FROM PostTags WHERE PostID IN (` + posts + `)`,
+		`DELETE FROM PostMentions WHERE PostID IN (` + posts + `)`,
+		`DELETE FROM Bookmarks WHERE PostID IN (` + posts + `)`,
+		`DELETE FROM Post WHERE GroupID = ?`,
+		`DELETE FROM UserEventResponse WHERE EventID IN (SELECT EventID FROM Event WHERE GroupID = ?)`,
+		`DELETE FROM Event WHERE GroupID = ?`,
+		`DELETE FROM GroupChatRead WHERE RoomID IN (SELECT RoomID FROM GroupChatRoom WHERE GroupID = ?)`,
+		`DELETE FROM GroupChatMessage WHERE GroupID = ?`,
+		`DELETE FROM GroupChatRoom WHERE GroupID = ?`,
+		`DELETE FROM GroupMembers WHERE GroupID = ?`,
+		`DELETE FROM GroupJoinRequests WHERE GroupId = ?`,
+		`DELETE FROM InvitedUsers WHERE GroupID = ?`,
+		`DELETE FROM Cluster WHERE GroupID = ?`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, groupID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queryInts(tx *sql.Tx, query string, args ...interface{}) ([]int, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ints []int
+	for rows.Next() {
+		var n int
+		if err := rows.Scan(&n); err != nil {
+			return nil, err
+		}
+		ints = append(ints, n)
+	}
+	return ints, rows.Err()
+}
+
+// GroupContent counts what was posted in a group
+type GroupContent struct {
+	Posts    int `json:"posts"`
+	Events   int `json:"events"`
+	Messages int `json:"messages"`
+}
+
+func GetGroupContent(db *sql.DB, groupID int) (GroupContent, error) {
+	var content GroupContent
+	err := db.QueryRow(`SELECT
+	(SELECT COUNT(*) FROM Post WHERE GroupID = ?),
+	(SELECT COUNT(*) FROM Event WHERE GroupID = ?),
+	(SELECT COUNT(*) FROM GroupChatMessage WHERE GroupID = ?)`, groupID, groupID, groupID).
+		Scan(&content.Posts, &content.Events, &content.Messages)
+	return content, err
+}
+
+// SessionInfo is a session with the user it belongs to
+type SessionInfo struct {
+	SessionID string
+	UserID    int
+	Nickname  string
+	Email     string
+	ExpiresAt time.Time
+}
+
+// ListSessions returns the sessions that haven't expired, of one user or of everyone when userID is 0,
+// the ones expiring last first
+func ListSessions(db *sql.DB, userID int) ([]SessionInfo, error) {
+	rows, err := db.Query(`SELECT s.SessionID, s.UserID, IFNULL(u.Nickname, ''), IFNULL(u.Email, ''), s.ExpiresAt
+	FROM Sessions s
+	LEFT JOIN User u ON s.UserID = u.UserID
+	WHERE s.ExpiresAt >= ? AND (? = 0 OR s.UserID = ?)
+	ORDER BY s.ExpiresAt DESC`, time.Now(), userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []SessionInfo{}
+	for rows.Next() {
+		var session SessionInfo
+		if err := rows.Scan(&session.SessionID, &session.UserID, &session.Nickname, &session.Email, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteUserSessions signs the user out everywhere and returns how many sessions ended
+func DeleteUserSessions(db *sql.DB, userID int) (int64, error) {
+	result, err := db.Exec(`DELETE FROM Sessions WHERE UserID = ?`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Stats counts what the site holds
+type Stats struct {
+	Users          int `json:"users"`
+	SuspendedUsers int `json:"suspendedUsers"`
+	Moderators     int `json:"moderators"`
+	Admins         int `json:"admins"`
+	ActiveSessions int `json:"activeSessions"`
+	Posts          int `json:"posts"`
+	Comments       int `json:"comments"`
+	Groups         int `json:"groups"`
+	Events         int `json:"events"`
+	Messages       int `json:"messages"`
+	GroupMessages  int `json:"groupMessages"`
+	OpenReports    int `json:"openReports"`
+}
+
+func GetStats(db *sql.DB) (Stats, error) {
+	var stats Stats
+	err := db.QueryRow(`SELECT
+	(SELECT COUNT(*) FROM User),
+	(SELECT COUNT(*) FROM User WHERE Suspended = TRUE),
+	(SELECT COUNT(*) FROM User WHERE Role = ?),
+	(SELECT COUNT(*) FROM User WHERE Role = ?),
+	(SELECT COUNT(*) FROM Sessions WHERE ExpiresAt >= ?),
+	(SELECT COUNT(*) FROM Post),
+	(SELECT COUNT(*) FROM Comment),
+	(SELECT COUNT(*) FROM Cluster),
+	(SELECT COUNT(*) FROM Event),
+	(SELECT COUNT(*) FROM Message),
+	(SELECT COUNT(*) FROM GroupChatMessage),
+	(SELECT COUNT(*) FROM Reports WHERE Status = 'open')`, RoleModerator, RoleAdmin, time.Now()).Scan(
+		&stats.Users, &stats.SuspendedUsers, &stats.Moderators, &stats.Admins, &stats.ActiveSessions, &stats.Posts,
+		&stats.Comments, &stats.Groups, &stats.Events, &stats.Messages, &stats.GroupMessages, &stats.OpenReports)
+	return stats, err
+}
@@ -0,0 +1,142 @@
+package model
+
+import (
+	"database/sql"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+type Attachment struct {
+	AttachmentID        string    `json:"attachmentId"`
+	UploaderUserID      int       `json:"uploaderUserId"`
+	RoomID              string    `json:"roomId"`
+	MessageID           string    `json:"messageId,omitempty"`
+	ObjectName          string    `json:"-"`
+	ThumbnailObjectName string    `json:"-"`
+	FileName            string    `json:"fileName"`
+	MimeType            string    `json:"mimeType"`
+	Size                int64     `json:"size"`
+	Width               int       `json:"width,omitempty"`
+	Height              int       `json:"height,omitempty"`
+	URL                 string    `json:"url"`
+	ThumbnailURL        string    `json:"thumbnailUrl,omitempty"`
+	CreatedAt           time.Time `json:"createdAt"`
+}
+
+const attachmentColumns = `AttachmentID, UploaderUserID, RoomID, IFNULL(MessageID, ''), ObjectName, IFNULL(ThumbnailObjectName, ''),
+	FileName, MimeType, Size, IFNULL(Width, 0), IFNULL(Height, 0), CreatedAt`
+
+func scanAttachment(scanner interface{ Scan(...interface{}) error }) (Attachment, error) {
+	var attachment Attachment
+	err := scanner.Scan(&attachment.AttachmentID, &attachment.UploaderUserID, &attachment.RoomID, &attachment.MessageID,
+		&attachment.ObjectName, &attachment.ThumbnailObjectName, &attachment.FileName, &attachment.MimeType,
+		&attachment.Size, &attachment.Width, &attachment.Height, &attachment.CreatedAt)
+	if err != nil {
+		return attachment, err
+	}
+
+	// Attachments are served through the API so that access can be checked against the room
+	attachment.URL = "/api/chat/attachment?id=" + attachment.AttachmentID
+	if attachment.ThumbnailObjectName != "" {
+		attachment.ThumbnailURL = attachment.URL + "&thumbnail=1"
+	}
+	return attachment, nil
+}
+
+// IsRoomParticipant reports whether the user belongs to the private room or is an accepted member of the group owning the group chat room
+func IsRoomParticipant(db *sql.DB, roomID string, userID int) (bool, error) {
+	var exists bool
+	query := `
+	SELECT EXISTS(SELECT 1 FROM Rooms WHERE RoomID = ? AND (User1ID = ? OR User2ID = ?))
+	OR EXISTS(SELECT 1 FROM GroupChatRoom gcr
+		JOIN GroupMembers gm ON gcr.GroupID = gm.GroupID
+		WHERE gcr.RoomID = ? AND gm.UserID = ? AND gm.Accepted = TRUE)`
+	err := db.QueryRow(query, roomID, userID, userID, roomID, userID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func CreateAttachment(db *sql.DB, attachment Attachment) (*Attachment, error) {
+	statement := `INSERT INTO ChatAttachment (AttachmentID, UploaderUserID, RoomID, ObjectName, ThumbnailObjectName, FileName, MimeType, Size, Width, Height)
+	VALUES (?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?, NULLIF(?, 0), NULLIF(?, 0))`
+	_, err := db.Exec(statement, attachment.AttachmentID, attachment.UploaderUserID, attachment.RoomID, attachment.ObjectName,
+		attachment.ThumbnailObjectName, attachment.FileName, attachment.MimeType, attachment.Size, attachment.Width, attachment.Height)
+	if err != nil {
+		slog.Error("Error creating chat attachment", "error", err)
+		return nil, err
+	}
+
+	return GetAttachment(db, attachment.AttachmentID)
+}
+
+func GetAttachment(db *sql.DB, attachmentID string) (*Attachment, error) {
+	row := db.QueryRow(`SELECT `+attachmentColumns+` FROM ChatAttachment WHERE AttachmentID = ?`, attachmentID)
+	attachment, err := scanAttachment(row)
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// LinkAttachments attaches the uploaded files to a saved message. Only files uploaded by the sender
+// to the same room that are not yet part of another message are linked.
+func LinkAttachments(db *sql.DB, messageID, roomID string, senderUserID int, attachmentIDs []string) ([]Attachment, error) {
+	if len(attachmentIDs) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(attachmentIDs)), ", ")
+	args := []interface{}{messageID, roomID, senderUserID}
+	for _, id := range attachmentIDs {
+		args = append(args, id)
+	}
+
+	statement := `UPDATE ChatAttachment SET MessageID = ?
+	WHERE RoomID = ? AND UploaderUserID = ? AND MessageID IS NULL AND AttachmentID IN (` + placeholders + `)`
+	if _, err := db.Exec(statement, args...); err != nil {
+		slog.Error("Error linking attachments", "message_id", messageID, "error", err)
+		return nil, err
+	}
+
+	attachments, err := GetRoomAttachments(db, roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	return attachments[messageID], nil
+}
+
+// GetRoomAttachments returns the attachments of the room grouped by message ID. When messageID is not empty
+// only the attachments of that message are returned.
+func GetRoomAttachments(db *sql.DB, roomID string, messageID string) (map[string][]Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM ChatAttachment WHERE RoomID = ? AND MessageID IS NOT NULL`
+	args := []interface{}{roomID}
+	if messageID != "" {
+		query += ` AND MessageID = ?`
+		args = append(args, messageID)
+	}
+	query += ` ORDER BY CreatedAt`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make(map[string][]Attachment)
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments[attachment.MessageID] = append(attachments[attachment.MessageID], attachment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+)
+
+// ErrBlocked is returned when an action is refused because one of the users blocked the other
+var ErrBlocked = errors.New("user is blocked")
+
+// notBlockedClause returns a condition that excludes rows whose user column is blocked by, or has blocked, the viewer.
+// The condition takes the viewer's user ID twice as arguments.
+func notBlockedClause(userColumn string) string {
+	return `NOT EXISTS (SELECT 1 FROM UserBlocks ub
+		WHERE (ub.BlockerUserID = ? AND ub.BlockedUserID = ` + userColumn + `)
+		OR (ub.BlockerUserID = ` + userColumn + ` AND ub.BlockedUserID = ?))`
+}
+
+// notMutedClause returns a condition that excludes rows whose user column is muted by the viewer.
+// The condition takes the viewer's user ID as argument.
+func notMutedClause(userColumn string) string {
+	return `NOT EXISTS (SELECT 1 FROM UserMutes um WHERE um.MuterUserID = ? AND um.MutedUserID = ` + userColumn + `)`
+}
+
+// IsBlocked reports whether either user has blocked the other
+func IsBlocked(db *sql.DB, userID, otherUserID int) (bool, error) {
+	var blocked bool
+	query := `SELECT EXISTS(SELECT 1 FROM UserBlocks
+	WHERE (BlockerUserID = ? AND BlockedUserID = ?) OR (BlockerUserID = ? AND BlockedUserID = ?))`
+	err := db.QueryRow(query, userID, otherUserID, otherUserID, userID).Scan(&blocked)
+	if err != nil {
+		return false, err
+	}
+	return blocked, nil
+}
+
+// BlockUser blocks the user and removes every follow edge, follow request and direct message permission between the two
+func BlockUser(db *sql.DB, blockerUserID, blockedUserID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	statements := []string{
+		`INSERT OR IGNORE INTO UserBlocks (BlockerUserID, BlockedUserID) VALUES (?1, ?2)`,
+		`DELETE FROM UserFollowers WHERE (FollowerUserID = ?1 AND FollowingUserID = ?2) OR (FollowerUserID = ?2 AND FollowingUserID = ?1)`,
+		`DELETE FROM FollowRequests WHERE (FollowerUserID = ?1 AND FollowingUserID = ?2) OR (FollowerUserID = ?2 AND FollowingUserID = ?1)`,
+		`DELETE FROM MessagePermissions WHERE (UserID = ?1 AND AllowedUserID = ?2) OR (UserID = ?2 AND AllowedUserID = ?1)`,
+		`DELETE FROM MessageRequests WHERE (SenderUserID = ?1 AND ReceiverUserID = ?2) OR (SenderUserID = ?2 AND ReceiverUserID = ?1)`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, blockerUserID, blockedUserID); err != nil {
+			slog.Error("Error blocking user", "user_id", blockerUserID, "blocked_user_id", blockedUserID, "error", err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func UnblockUser(db *sql.DB, blockerUserID, blockedUserID int) error {
+	_, err := db.Exec(`DELETE FROM UserBlocks WHERE BlockerUserID = ? AND BlockedUserID = ?`, blockerUserID, blockedUserID)
+	return err
+}
+
+func MuteUser(db *sql.DB, muterUserID, mutedUserID int) error {
+	_, err := db.Exec(`INSERT OR IGNORE INTO UserMutes (MuterUserID, MutedUserID) VALUES (?, ?)`, muterUserID, mutedUserID)
+	return err
+}
+
+func UnmuteUser(db *sql.DB, muterUserID, mutedUserID int) error {
+	_, err := db.Exec(`DELETE FROM UserMutes WHERE MuterUserID = ? AND MutedUserID = ?`, muterUserID, mutedUserID)
+	return err
+}
+
+// GetBlockedUsers returns the users blocked by the user
+func GetBlockedUsers(db *sql.DB, userID int) ([]User, error) {
+	return fetchRelatedUsers(db, `SELECT u.UserID, u.FirstName, u.LastName, u.ProfilePicture, u.ProfilePrivacy
+	FROM UserBlocks ub
+	JOIN User u ON ub.BlockedUserID = u.UserID
+	WHERE ub.BlockerUserID = ?
+	ORDER BY ub.CreatedAt DESC`, userID)
+}
+
+// GetMutedUsers returns the users muted by the user
+func GetMutedUsers(db *sql.DB, userID int) ([]User, error) {
+	return fetchRelatedUsers(db, `SELECT u.UserID, u.FirstName, u.LastName, u.ProfilePicture, u.ProfilePrivacy
+	FROM UserMutes um
+	JOIN User u ON um.MutedUserID = u.UserID
+	WHERE um.MuterUserID = ?
+	ORDER BY um.CreatedAt DESC`, userID)
+}
+
+func fetchRelatedUsers(db *sql.DB, query string, userID int) ([]User, error) {
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.UserID, &user.FirstName, &user.LastName, &user.ProfilePicture, &user.ProfilePrivacy); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
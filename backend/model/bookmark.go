@@ -0,0 +1,177 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCollectionNotFound = errors.New("collection not found")
+	ErrCollectionExists   = errors.New("collection already exists")
+)
+
+type BookmarkCollection struct {
+	CollectionID int       `json:"collectionId"`
+	Name         string    `json:"name"`
+	CreatedAt    time.Time `json:"createdAt"`
+	PostCount    int       `json:"postCount"`
+}
+
+func CreateCollection(db *sql.DB, userID int, name string) (*BookmarkCollection, error) {
+	result, err := db.Exec(`INSERT INTO BookmarkCollections (UserID, Name) VALUES (?, ?)`, userID, name)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return nil, ErrCollectionExists
+		}
+		slog.Error("Error creating bookmark collection", "error", err)
+		return nil, err
+	}
+
+	collectionID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &BookmarkCollection{CollectionID: int(collectionID), Name: name, CreatedAt: time.Now().UTC()}, nil
+}
+
+// DeleteCollection removes a collection of the user; the posts saved in it stay saved, unsorted
+func DeleteCollection(db *sql.DB, userID, collectionID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM BookmarkCollections WHERE CollectionID = ? AND UserID = ?`, collectionID, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return ErrCollectionNotFound
+	}
+
+	if _, err = tx.Exec(`UPDATE Bookmarks SET CollectionID = NULL WHERE CollectionID = ? AND UserID = ?`, collectionID, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetCollections returns the collections of the user with the number of saved posts in each. The count leaves
+// out the same posts GetBookmarks does, so it matches what the collection shows.
+func GetCollections(db *sql.DB, userID int) ([]BookmarkCollection, error) {
+	query := `SELECT c.CollectionID, c.Name, c.CreatedAt,
+	(SELECT COUNT(*) FROM Bookmarks b
+		JOIN Post p ON b.PostID = p.PostID
+		WHERE b.CollectionID = c.CollectionID AND p.Hidden = FALSE AND p.Published = TRUE
+		AND ` + notBlockedClause("p.UserID") + `
+		AND ` + postVisibleClause("p") + `)
+	FROM BookmarkCollections c
+	WHERE c.UserID = ?
+	ORDER BY c.Name`
+
+	rows, err := db.Query(query, userID, userID, userID, userID, userID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []BookmarkCollection{}
+	for rows.Next() {
+		var collection BookmarkCollection
+		if err := rows.Scan(&collection.CollectionID, &collection.Name, &collection.CreatedAt, &collection.PostCount); err != nil {
+			return nil, err
+		}
+		collections = append(collections, collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// AddBookmark saves a post the user can see, in the given collection or unsorted when collectionID is 0.
+// Saving a post that is already saved moves it to the collection.
+func AddBookmark(db *sql.DB, userID, postID, collectionID int) error {
+	visible, err := CanViewPost(db, userID, postID)
+	if err != nil {
+		return err
+	}
+	if !visible {
+		return ErrPostNotFound
+	}
+
+	var collection sql.NullInt64
+	if collectionID != 0 {
+		var exists bool
+		err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM BookmarkCollections WHERE CollectionID = ? AND UserID = ?)`,
+			collectionID, userID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrCollectionNotFound
+		}
+		collection = sql.NullInt64{Int64: int64(collectionID), Valid: true}
+	}
+
+	_, err = db.Exec(`INSERT INTO Bookmarks (UserID, PostID, CollectionID) VALUES (?, ?, ?)
+	ON CONFLICT (UserID, PostID) DO UPDATE SET CollectionID = excluded.CollectionID`, userID, postID, collection)
+	if err != nil {
+		slog.Error("Error saving post", "post_id", postID, "user_id", userID, "error", err)
+	}
+	return err
+}
+
+func RemoveBookmark(db *sql.DB, userID, postID int) error {
+	_, err := db.Exec(`DELETE FROM Bookmarks WHERE UserID = ? AND PostID = ?`, userID, postID)
+	return err
+}
+
+// GetBookmarks returns the saved posts of the user, most recently saved first, from one collection or from all of
+// them when collectionID is 0. Posts the user can no longer see, because privacy changed or they left the group,
+// are left out but stay saved in case they become visible again.
+func GetBookmarks(db *sql.DB, userID, collectionID, limit, offset int) ([]Post, error) {
+	query := `SELECT p.PostID, p.UserID, p.Content, p.ImageURL, p.Timestamp, p.PrivacySetting, p.AllowedViewers, p.GroupID,
+	u.Nickname, u.FirstName, u.LastName, u.ProfilePicture
+	FROM Bookmarks b
+	JOIN Post p ON b.PostID = p.PostID
+	JOIN User u ON p.UserID = u.UserID
+	WHERE b.UserID = ? AND (? = 0 OR b.CollectionID = ?) AND p.Hidden = FALSE AND p.Published = TRUE
+	AND ` + notBlockedClause("p.UserID") + `
+	AND ` + postVisibleClause("p") + `
+	ORDER BY b.CreatedAt DESC, p.PostID DESC
+	LIMIT ? OFFSET ?`
+
+	rows, err := db.Query(query, userID, collectionID, collectionID, userID, userID, userID, userID, userID, userID, limit, offset)
+	if err != nil {
+		slog.Error("Error querying bookmarks", "user_id", userID, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(&post.PostID, &post.UserID, &post.Content, &post.ImageURL, &post.Timestamp, &post.PrivacySetting, &post.AllowedViewers,
+			&post.GroupID, &post.Nickname, &post.FirstName, &post.LastName, &post.ProfilePicture); err != nil {
+			slog.Error("Error scanning bookmarked post", "error", err)
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	decoratePosts(db, userID, posts)
+	return posts, nil
+}
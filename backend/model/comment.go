@@ -2,10 +2,14 @@ package model
 
 import (
 	"database/sql"
-	"log"
+	"errors"
+	"log/slog"
 	"time"
 )
 
+// ErrCommentNotFound is returned when a comment doesn't exist or the user may not change it
+var ErrCommentNotFound = errors.New("comment not found")
+
 type Comment struct {
 	CommentID      int       `json:"commentID"`
 	PostID         int       `json:"postID"`
@@ -16,6 +20,7 @@ type Comment struct {
 	LastName       string    `json:"lastName"`
 	ProfilePicture string    `json:"profilePicture,omitempty"`
 	CommentMedia   string    `json:"commentMedia,omitempty"`
+	Entities       []Entity  `json:"entities"`
 }
 
 func CreateComment(db *sql.DB, comment Comment) (*Comment, error) {
@@ -23,14 +28,14 @@ func CreateComment(db *sql.DB, comment Comment) (*Comment, error) {
 	statement := `INSERT INTO Comment (PostID, UserID, Content, CommentMedia) VALUES (?, ?, ?, ?)`
 	result, err := db.Exec(statement, comment.PostID, comment.UserID, comment.Content, comment.CommentMedia)
 	if err != nil {
-		log.Printf("Error creating comment: %v", err)
+		slog.Error("Error creating comment", "error", err)
 		return nil, err
 	}
 
 	// Get the ID of the newly created comment
 	commentID, err := result.LastInsertId()
 	if err != nil {
-		log.Printf("Error getting last insert ID: %v", err)
+		slog.Error("Error getting last insert ID", "error", err)
 		return nil, err
 	}
 	comment.CommentID = int(commentID)
@@ -45,15 +50,21 @@ func CreateComment(db *sql.DB, comment Comment) (*Comment, error) {
 		&comment.CommentID, &comment.PostID, &comment.UserID, &comment.Content,
 		&comment.Timestamp, &comment.CommentMedia, &comment.FirstName, &comment.LastName, &comment.ProfilePicture)
 	if err != nil {
-		log.Printf("Error retrieving new comment with user data: %v", err)
+		slog.Error("Error retrieving new comment with user data", "error", err)
 		return nil, err
 	}
 
-	log.Printf("Comment created successfully with CommentID: %d", comment.CommentID)
+	updateEntities(db, "comment", comment.CommentID, comment.UserID, comment.PostID, comment.Content)
+	comments := []Comment{comment}
+	attachCommentEntities(db, comments)
+	comment = comments[0]
+
+	slog.Debug("Comment created", "comment_id", comment.CommentID)
 	return &comment, nil
 }
 
-func GetCommentsForPost(db *sql.DB, postID string) ([]Comment, error) {
+// GetCommentsForPost returns the comments of a post, leaving out authors the viewer blocked or was blocked by
+func GetCommentsForPost(db *sql.DB, postID string, viewerID int) ([]Comment, error) {
 	var comments []Comment
 
 	// Updated SQL query to join Comment and User tables
@@ -62,12 +73,13 @@ func GetCommentsForPost(db *sql.DB, postID string) ([]Comment, error) {
 				 u.FirstName, u.LastName, u.ProfilePicture
 	FROM Comment c
 	JOIN User u ON c.UserID = u.UserID
-	WHERE c.PostID = ?
+	WHERE c.PostID = ? AND c.Hidden = FALSE
+	AND ` + notBlockedClause("c.UserID") + `
 	ORDER BY c.Timestamp DESC`
 
-	rows, err := db.Query(query, postID)
+	rows, err := db.Query(query, postID, viewerID, viewerID)
 	if err != nil {
-		log.Printf("Error querying comments: %v", err)
+		slog.Error("Error querying comments", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -77,7 +89,7 @@ func GetCommentsForPost(db *sql.DB, postID string) ([]Comment, error) {
 		var comment Comment
 		if err := rows.Scan(&comment.CommentID, &comment.PostID, &comment.UserID, &comment.Content,
 			&comment.Timestamp, &comment.CommentMedia, &comment.FirstName, &comment.LastName, &comment.ProfilePicture); err != nil {
-			log.Printf("Error scanning comment with user data: %v", err)
+			slog.Error("Error scanning comment with user data", "error", err)
 			continue
 		}
 		comments = append(comments, comment)
@@ -87,5 +99,45 @@ func GetCommentsForPost(db *sql.DB, postID string) ([]Comment, error) {
 		return nil, err
 	}
 
+	attachCommentEntities(db, comments)
 	return comments, nil
 }
+
+// UpdateComment changes the content of a comment written by the user
+func UpdateComment(db *sql.DB, commentID, userID int, content string) error {
+	result, err := db.Exec(`UPDATE Comment SET Content = ? WHERE CommentID = ? AND UserID = ?`, content, commentID, userID)
+	if err != nil {
+		slog.Error("Error updating comment", "comment_id", commentID, "error", err)
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrCommentNotFound
+	}
+
+	var postID int
+	if err := db.QueryRow(`SELECT PostID FROM Comment WHERE CommentID = ?`, commentID).Scan(&postID); err != nil {
+		return err
+	}
+	updateEntities(db, "comment", commentID, userID, postID, content)
+	return nil
+}
+
+// DeleteComment removes a comment written by the user or left on one of the user's posts
+func DeleteComment(db *sql.DB, commentID, userID int) error {
+	result, err := db.Exec(`DELETE FROM Comment WHERE CommentID = ?
+	AND (UserID = ? OR PostID IN (SELECT PostID FROM Post WHERE UserID = ?))`, commentID, userID, userID)
+	if err != nil {
+		slog.Error("Error deleting comment", "comment_id", commentID, "error", err)
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrCommentNotFound
+	}
+
+	for _, table := range []string{"CommentTags", "CommentMentions"} {
+		if _, err := db.Exec(`DELETE FROM `+table+` WHERE CommentID = ?`, commentID); err != nil {
+			slog.Error("Error unlinking entities of comment", "comment_id", commentID, "error", err)
+		}
+	}
+	return nil
+}
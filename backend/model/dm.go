@@ -0,0 +1,286 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Direct message policies a user can choose for who may message them
+const (
+	DMPolicyEveryone  = "everyone"
+	DMPolicyFollowing = "following" // people the user follows
+	DMPolicyMutuals   = "mutuals"
+	DMPolicyNobody    = "nobody"
+)
+
+// ErrNoMessageRequest is returned when accepting messages from a user who has no pending request
+var ErrNoMessageRequest = errors.New("no pending message request")
+
+type MessageRequest struct {
+	RequestID      int       `json:"requestId"`
+	SenderUserID   int       `json:"senderUserId"`
+	FirstName      string    `json:"firstName"`
+	LastName       string    `json:"lastName"`
+	Nickname       string    `json:"nickname"`
+	ProfilePicture string    `json:"profilePicture"`
+	Content        string    `json:"content"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+func ValidDMPolicy(policy string) bool {
+	switch policy {
+	case DMPolicyEveryone, DMPolicyFollowing, DMPolicyMutuals, DMPolicyNobody:
+		return true
+	}
+	return false
+}
+
+func GetDMPolicy(db *sql.DB, userID int) (string, error) {
+	var policy sql.NullString
+	err := db.QueryRow(`SELECT DMPolicy FROM User WHERE UserID = ?`, userID).Scan(&policy)
+	if err != nil {
+		return "", err
+	}
+	if !policy.Valid || policy.String == "" {
+		return DMPolicyEveryone, nil
+	}
+	return policy.String, nil
+}
+
+// dmPolicyRank orders the policies from the most to the least open
+var dmPolicyRank = map[string]int{
+	DMPolicyEveryone:  0,
+	DMPolicyFollowing: 1,
+	DMPolicyMutuals:   2,
+	DMPolicyNobody:    3,
+}
+
+// SetDMPolicy changes who may message the user. A stricter policy also takes back the permissions the user gave
+// by accepting requests or messaging people first, so everyone it doesn't allow has to ask again.
+func SetDMPolicy(db *sql.DB, userID int, policy string) error {
+	current, err := GetDMPolicy(db, userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`UPDATE User SET DMPolicy = ? WHERE UserID = ?`, policy, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if dmPolicyRank[policy] > dmPolicyRank[current] {
+		if _, err = tx.Exec(`DELETE FROM MessagePermissions WHERE UserID = ?`, userID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CanDirectMessage reports whether the sender may message the receiver directly. The receiver's policy decides,
+// unless the receiver accepted a message request from the sender or has messaged the sender themselves. Those
+// permissions don't depend on follows; they last until the receiver tightens their policy or one blocks the other.
+func CanDirectMessage(db *sql.DB, senderUserID, receiverUserID int) (bool, error) {
+	if senderUserID == receiverUserID {
+		return false, nil
+	}
+
+	blocked, err := IsBlocked(db, senderUserID, receiverUserID)
+	if err != nil || blocked {
+		return false, err
+	}
+
+	var permitted bool
+	err = db.QueryRow(`SELECT EXISTS(SELECT 1 FROM MessagePermissions WHERE UserID = ? AND AllowedUserID = ?)`,
+		receiverUserID, senderUserID).Scan(&permitted)
+	if err != nil {
+		return false, err
+	}
+	if permitted {
+		return true, nil
+	}
+
+	policy, err := GetDMPolicy(db, receiverUserID)
+	if err != nil {
+		return false, err
+	}
+
+	var receiverFollowsSender, senderFollowsReceiver bool
+	query := `SELECT
+		EXISTS(SELECT 1 FROM UserFollowers WHERE FollowerUserID = ? AND FollowingUserID = ?),
+		EXISTS(SELECT 1 FROM UserFollowers WHERE FollowerUserID = ? AND FollowingUserID = ?)`
+	err = db.QueryRow(query, receiverUserID, senderUserID, senderUserID, receiverUserID).Scan(&receiverFollowsSender, &senderFollowsReceiver)
+	if err != nil {
+		return false, err
+	}
+
+	switch policy {
+	case DMPolicyEveryone:
+		return true, nil
+	case DMPolicyFollowing:
+		return receiverFollowsSender, nil
+	case DMPolicyMutuals:
+		return receiverFollowsSender && senderFollowsReceiver, nil
+	default:
+		return false, nil
+	}
+}
+
+// AllowDirectMessages lets allowedUserID message userID regardless of userID's policy
+func AllowDirectMessages(db *sql.DB, userID, allowedUserID int) error {
+	_, err := db.Exec(`INSERT OR IGNORE INTO MessagePermissions (UserID, AllowedUserID) VALUES (?, ?)`, userID, allowedUserID)
+	return err
+}
+
+// SaveMessageRequest stores a message that the receiver's policy did not allow and returns its status.
+// Messages from a sender the receiver already ignored stay ignored.
+func SaveMessageRequest(db *sql.DB, senderUserID, receiverUserID int, content string) (string, error) {
+	var ignored bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM MessageRequests WHERE SenderUserID = ? AND ReceiverUserID = ? AND Status = 'ignored')`,
+		senderUserID, receiverUserID).Scan(&ignored)
+	if err != nil {
+		return "", err
+	}
+
+	status := "pending"
+	if ignored {
+		status = "ignored"
+	}
+
+	_, err = db.Exec(`INSERT INTO MessageRequests (SenderUserID, ReceiverUserID, Content, Status) VALUES (?, ?, ?, ?)`,
+		senderUserID, receiverUserID, content, status)
+	if err != nil {
+		slog.Error("Error saving message request", "error", err)
+		return "", err
+	}
+	return status, nil
+}
+
+func FetchMessageRequests(db *sql.DB, receiverUserID int) ([]MessageRequest, error) {
+	requests := []MessageRequest{}
+	query := `
+	SELECT mr.RequestID, mr.SenderUserID, u.FirstName, u.LastName, u.Nickname, u.ProfilePicture, mr.Content, mr.Timestamp
+	FROM MessageRequests mr
+	JOIN User u ON mr.SenderUserID = u.UserID
+	WHERE mr.ReceiverUserID = ? AND mr.Status = 'pending'
+	ORDER BY mr.Timestamp`
+	rows, err := db.Query(query, receiverUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var request MessageRequest
+		if err := rows.Scan(&request.RequestID, &request.SenderUserID, &request.FirstName, &request.LastName, &request.Nickname,
+			&request.ProfilePicture, &request.Content, &request.Timestamp); err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// CheckMessageRequest returns ErrBlocked when either user blocked the other, and ErrNoMessageRequest when the
+// sender has no pending request to the receiver
+func CheckMessageRequest(db *sql.DB, receiverUserID, senderUserID int) error {
+	blocked, err := IsBlocked(db, receiverUserID, senderUserID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+
+	var pending bool
+	err = db.QueryRow(`SELECT EXISTS(SELECT 1 FROM MessageRequests WHERE SenderUserID = ? AND ReceiverUserID = ? AND Status = 'pending')`,
+		senderUserID, receiverUserID).Scan(&pending)
+	if err != nil {
+		return err
+	}
+	if !pending {
+		return ErrNoMessageRequest
+	}
+	return nil
+}
+
+// AcceptMessageRequests allows the sender to message the receiver and moves the pending requests
+// into the conversation in the given room. It returns ErrNoMessageRequest when there are none.
+func AcceptMessageRequests(db *sql.DB, receiverUserID, senderUserID int, roomID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT OR IGNORE INTO MessagePermissions (UserID, AllowedUserID) VALUES (?, ?)`, receiverUserID, senderUserID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT RequestID, Content, Timestamp FROM MessageRequests
+	WHERE SenderUserID = ? AND ReceiverUserID = ? AND Status = 'pending' ORDER BY Timestamp`, senderUserID, receiverUserID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	type pendingMessage struct {
+		content   string
+		timestamp time.Time
+	}
+	var pending []pendingMessage
+	for rows.Next() {
+		var requestID int
+		var message pendingMessage
+		if err := rows.Scan(&requestID, &message.content, &message.timestamp); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return err
+		}
+		pending = append(pending, message)
+	}
+	rows.Close()
+	if len(pending) == 0 {
+		tx.Rollback()
+		return ErrNoMessageRequest
+	}
+
+	for _, message := range pending {
+		_, err = tx.Exec(`INSERT INTO Message (MessageID, SenderUserID, ReceiverUserID, RoomID, Content, Timestamp) VALUES (?, ?, ?, ?, ?, ?)`,
+			uuid.New().String(), senderUserID, receiverUserID, roomID, message.content, message.timestamp.UTC().Format("2006-01-02 15:04:05"))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM MessageRequests WHERE SenderUserID = ? AND ReceiverUserID = ?`, senderUserID, receiverUserID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IgnoreMessageRequests hides the pending requests of the sender; later messages from them are ignored as well
+func IgnoreMessageRequests(db *sql.DB, receiverUserID, senderUserID int) error {
+	_, err := db.Exec(`UPDATE MessageRequests SET Status = 'ignored' WHERE SenderUserID = ? AND ReceiverUserID = ?`, senderUserID, receiverUserID)
+	return err
+}
@@ -0,0 +1,202 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+var (
+	ErrInvalidPublishAt = errors.New("publish time must be in the future")
+	ErrNotGroupAdmin    = errors.New("not an admin of the group")
+)
+
+// publishInterval is how often the publisher looks for scheduled posts that are due
+const publishInterval = time.Minute
+
+// IsGroupAdmin reports whether the user created the group, which makes them its admin
+func IsGroupAdmin(db *sql.DB, groupID int64, userID int) (bool, error) {
+	var admin bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM Cluster WHERE GroupID = ? AND CreatorUserID = ?)`, groupID, userID).Scan(&admin)
+	return admin, err
+}
+
+// checkSchedule makes sure a post can be scheduled for publishAt; a nil publishAt leaves it a plain draft.
+// Only group admins schedule posts in groups.
+func checkSchedule(db *sql.DB, groupID sql.NullInt64, userID int, publishAt *time.Time) error {
+	if publishAt == nil {
+		return nil
+	}
+	if !publishAt.After(time.Now()) {
+		return ErrInvalidPublishAt
+	}
+	if groupID.Valid {
+		admin, err := IsGroupAdmin(db, groupID.Int64, userID)
+		if err != nil {
+			return err
+		}
+		if !admin {
+			return ErrNotGroupAdmin
+		}
+	}
+	return nil
+}
+
+// CreateDraft saves a post without publishing it, to be published at publishAt when it is set
+func CreateDraft(db *sql.DB, post Post, publishAt *time.Time) (*Post, error) {
+	if err := checkSchedule(db, post.GroupID, post.UserID, publishAt); err != nil {
+		return nil, err
+	}
+	post.Draft = true
+	post.PublishAt = publishAt
+	return insertPost(db, post)
+}
+
+// GetDrafts returns the unpublished posts of the user, plain drafts first, then scheduled posts in the order they go out
+func GetDrafts(db *sql.DB, userID int) ([]Post, error) {
+	rows, err := db.Query(`SELECT p.PostID, p.UserID, p.Content, p.ImageURL, p.Timestamp, p.PrivacySetting, p.AllowedViewers, p.GroupID,
+	p.PublishAt, u.Nickname, u.FirstName, u.LastName, u.ProfilePicture
+	FROM Post p
+	JOIN User u ON p.UserID = u.UserID
+	WHERE p.UserID = ? AND p.Published = FALSE AND p.Hidden = FALSE
+	ORDER BY p.PublishAt IS NOT NULL, p.PublishAt, p.PostID DESC`, userID)
+	if err != nil {
+		slog.Error("Error querying drafts", "user_id", userID, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var post Post
+		var publishAt sql.NullTime
+		if err := rows.Scan(&post.PostID, &post.UserID, &post.Content, &post.ImageURL, &post.Timestamp, &post.PrivacySetting, &post.AllowedViewers,
+			&post.GroupID, &publishAt, &post.Nickname, &post.FirstName, &post.LastName, &post.ProfilePicture); err != nil {
+			slog.Error("Error scanning draft", "error", err)
+			return nil, err
+		}
+		post.Draft = true
+		if publishAt.Valid {
+			post.PublishAt = &publishAt.Time
+		}
+		posts = append(posts, post)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	decoratePosts(db, userID, posts)
+	return posts, nil
+}
+
+// SchedulePost sets when a draft of the user is published; a nil publishAt turns it back into a plain draft
+func SchedulePost(db *sql.DB, postID, userID int, publishAt *time.Time) error {
+	var groupID sql.NullInt64
+	err := db.QueryRow(`SELECT GroupID FROM Post WHERE PostID = ? AND UserID = ? AND Published = FALSE`, postID, userID).Scan(&groupID)
+	if err == sql.ErrNoRows {
+		return ErrPostNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := checkSchedule(db, groupID, userID, publishAt); err != nil {
+		return err
+	}
+
+	var at sql.NullString
+	if publishAt != nil {
+		at = sql.NullString{String: publishAt.UTC().Format("2006-01-02 15:04:05"), Valid: true}
+	}
+	_, err = db.Exec(`UPDATE Post SET PublishAt = ? WHERE PostID = ? AND Published = FALSE`, at, postID)
+	return err
+}
+
+// PublishDraft publishes a draft of the user right away
+func PublishDraft(db *sql.DB, postID, userID int) error {
+	var authorID int
+	err := db.QueryRow(`SELECT UserID FROM Post WHERE PostID = ? AND UserID = ? AND Published = FALSE`, postID, userID).Scan(&authorID)
+	if err == sql.ErrNoRows {
+		return ErrPostNotFound
+	} else if err != nil {
+		return err
+	}
+	return publishPost(db, postID)
+}
+
+// publishPost makes a draft visible, dated when it went out, and notifies the users mentioned in it,
+// which was held back while it was a draft
+func publishPost(db *sql.DB, postID int) error {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	result, err := db.Exec(`UPDATE Post SET Published = TRUE, Timestamp = MIN(IFNULL(PublishAt, ?), ?), PublishAt = NULL
+	WHERE PostID = ? AND Published = FALSE`, now, now, postID)
+	if err != nil {
+		slog.Error("Error publishing post", "post_id", postID, "error", err)
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrPostNotFound
+	}
+
+	var authorID int
+	if err := db.QueryRow(`SELECT UserID FROM Post WHERE PostID = ?`, postID).Scan(&authorID); err != nil {
+		return err
+	}
+	mentioned, err := mentionedUsers(db, "post", []int{postID})
+	if err != nil {
+		slog.Error("Error fetching mentions", "post_id", postID, "error", err)
+		return nil
+	}
+	var userIDs []int
+	for _, userID := range mentioned[postID] {
+		userIDs = append(userIDs, userID)
+	}
+	notifyMentions(db, authorID, postID, 0, userIDs)
+	return nil
+}
+
+// PublishScheduledPosts periodically publishes the scheduled posts that are due, in the main.go with a go routine,
+// until ctx is done. Schedules live in the datab, so posts that came due while the server was down go out when it starts.
+func PublishScheduledPosts(ctx context.Context, db *sql.DB) {
+	publishDuePosts(db)
+
+	ticker := time.NewTicker(publishInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			publishDuePosts(db)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func publishDuePosts(db *sql.DB) {
+	rows, err := db.Query(`SELECT PostID FROM Post WHERE Published = FALSE AND PublishAt <= ?`,
+		time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		slog.Error("Error fetching scheduled posts", "error", err)
+		return
+	}
+
+	var postIDs []int
+	for rows.Next() {
+		var postID int
+		if err := rows.Scan(&postID); err != nil {
+			slog.Error("Error scanning scheduled post ID", "error", err)
+			continue
+		}
+		postIDs = append(postIDs, postID)
+	}
+	rows.Close()
+
+	for _, postID := range postIDs {
+		if err := publishPost(db, postID); err != nil {
+			continue
+		}
+		slog.Info("Published scheduled post", "post_id", postID)
+	}
+}
@@ -0,0 +1,348 @@
+package model
+
+import (
+	"database/sql"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Entity types found in post and comment content
+const (
+	EntityHashtag = "hashtag"
+	EntityMention = "mention"
+)
+
+// Entity is a hashtag or mention in a piece of content. Start and End are offsets in UTF-16 code units,
+// the way JavaScript indexes strings, with End exclusive.
+type Entity struct {
+	Type   string `json:"type"`
+	Text   string `json:"text"` // tag name or nickname without the leading # or @
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+	UserID int    `json:"userID,omitempty"`
+}
+
+type TrendingTag struct {
+	Name string `json:"name"`
+	Uses int    `json:"uses"`
+}
+
+// A # or @ only starts an entity at the beginning of the text or after a non-word character, so e-mail addresses don't count
+var entityPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])([#@])([\p{L}\p{N}_]{1,50})`)
+
+// entityTables names the link tables of the content kinds that carry entities
+var entityTables = map[string]struct{ idColumn, tagTable, mentionTable string }{
+	"post":    {"PostID", "PostTags", "PostMentions"},
+	"comment": {"CommentID", "CommentTags", "CommentMentions"},
+}
+
+// parseEntities finds the hashtags and mentions in the content. Mentions are returned unresolved.
+func parseEntities(content string) []Entity {
+	entities := []Entity{}
+	for _, match := range entityPattern.FindAllStringSubmatchIndex(content, -1) {
+		// match[2] is the position of the # or @, match[5] the end of the word
+		entity := Entity{
+			Text:  content[match[4]:match[5]],
+			Start: utf16Len(content[:match[2]]),
+			End:   utf16Len(content[:match[5]]),
+		}
+		if content[match[2]] == '#' {
+			entity.Type = EntityHashtag
+			entity.Text = strings.ToLower(entity.Text)
+		} else {
+			entity.Type = EntityMention
+		}
+		entities = append(entities, entity)
+	}
+	return entities
+}
+
+func utf16Len(text string) int {
+	return len(utf16.Encode([]rune(text)))
+}
+
+// resolveEntities parses the content and keeps the mentions of the given users, keyed by lower-case nickname
+func resolveEntities(content string, mentioned map[string]int) []Entity {
+	entities := []Entity{}
+	for _, entity := range parseEntities(content) {
+		if entity.Type == EntityMention {
+			userID, ok := mentioned[strings.ToLower(entity.Text)]
+			if !ok {
+				continue
+			}
+			entity.UserID = userID
+		}
+		entities = append(entities, entity)
+	}
+	return entities
+}
+
+// linkEntities replaces the tags and mentions stored for a post or comment and returns the users that weren't
+// mentioned in it before
+func linkEntities(db *sql.DB, kind string, id int, content string) ([]int, error) {
+	tables := entityTables[kind]
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	previous := map[int]bool{}
+	rows, err := tx.Query(`SELECT UserID FROM `+tables.mentionTable+` WHERE `+tables.idColumn+` = ?`, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return nil, err
+		}
+		previous[userID] = true
+	}
+	rows.Close()
+
+	for _, table := range []string{tables.tagTable, tables.mentionTable} {
+		if _, err = tx.Exec(`DELETE FROM `+table+` WHERE `+tables.idColumn+` = ?`, id); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	var newlyMentioned []int
+	for _, entity := range parseEntities(content) {
+		switch entity.Type {
+		case EntityHashtag:
+			if _, err = tx.Exec(`INSERT OR IGNORE INTO Tags (Name) VALUES (?)`, entity.Text); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			_, err = tx.Exec(`INSERT OR IGNORE INTO `+tables.tagTable+` (`+tables.idColumn+`, TagID) SELECT ?, TagID FROM Tags WHERE Name = ?`,
+				id, entity.Text)
+		case EntityMention:
+			var userID int
+			err = tx.QueryRow(`SELECT UserID FROM User WHERE Nickname = ? COLLATE NOCASE`, entity.Text).Scan(&userID)
+			if err == sql.ErrNoRows {
+				err = nil
+				continue
+			} else if err != nil {
+				break
+			}
+			var result sql.Result
+			result, err = tx.Exec(`INSERT OR IGNORE INTO `+tables.mentionTable+` (`+tables.idColumn+`, UserID) VALUES (?, ?)`, id, userID)
+			if err == nil && !previous[userID] {
+				if affected, _ := result.RowsAffected(); affected > 0 {
+					newlyMentioned = append(newlyMentioned, userID)
+				}
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return newlyMentioned, nil
+}
+
+// mentionedUsers returns, per post or comment ID, the users mentioned in it keyed by lower-case nickname
+func mentionedUsers(db *sql.DB, kind string, ids []int) (map[int]map[string]int, error) {
+	mentioned := map[int]map[string]int{}
+	if len(ids) == 0 {
+		return mentioned, nil
+	}
+
+	tables := entityTables[kind]
+	placeholders, args := inClause(ids)
+
+	rows, err := db.Query(`SELECT m.`+tables.idColumn+`, u.UserID, u.Nickname FROM `+tables.mentionTable+` m
+	JOIN User u ON m.UserID = u.UserID
+	WHERE m.`+tables.idColumn+` IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, userID int
+		var nickname string
+		if err := rows.Scan(&id, &userID, &nickname); err != nil {
+			return nil, err
+		}
+		if mentioned[id] == nil {
+			mentioned[id] = map[string]int{}
+		}
+		mentioned[id][strings.ToLower(nickname)] = userID
+	}
+
+	return mentioned, rows.Err()
+}
+
+// attachPostEntities fills in the entities of the posts
+func attachPostEntities(db *sql.DB, posts []Post) {
+	ids := make([]int, len(posts))
+	for i, post := range posts {
+		ids[i] = post.PostID
+	}
+
+	mentioned, err := mentionedUsers(db, "post", ids)
+	if err != nil {
+		slog.Error("Error fetching post mentions", "error", err)
+	}
+	for i := range posts {
+		posts[i].Entities = resolveEntities(posts[i].Content, mentioned[posts[i].PostID])
+	}
+}
+
+// attachCommentEntities fills in the entities of the comments
+func attachCommentEntities(db *sql.DB, comments []Comment) {
+	ids := make([]int, len(comments))
+	for i, comment := range comments {
+		ids[i] = comment.CommentID
+	}
+
+	mentioned, err := mentionedUsers(db, "comment", ids)
+	if err != nil {
+		slog.Error("Error fetching comment mentions", "error", err)
+	}
+	for i := range comments {
+		comments[i].Entities = resolveEntities(comments[i].Content, mentioned[comments[i].CommentID])
+	}
+}
+
+// notifyMentions notifies the mentioned users that can see the post, the comment being on the post when commentID is set
+func notifyMentions(db *sql.DB, authorUserID, postID, commentID int, userIDs []int) {
+	content := "mentioned you in a post"
+	if commentID != 0 {
+		content = "mentioned you in a comment"
+	}
+
+	for _, userID := range userIDs {
+		if userID == authorUserID {
+			continue
+		}
+		if blocked, err := IsBlocked(db, authorUserID, userID); err != nil || blocked {
+			continue
+		}
+		if visible, err := CanViewPost(db, userID, postID); err != nil || !visible {
+			continue
+		}
+
+		CreateNotification(db, Notification{
+			UserID:      userID,
+			Type:        NotificationMention,
+			Content:     content,
+			ActorUserID: authorUserID,
+			PostID:      postID,
+			CommentID:   commentID,
+		})
+	}
+}
+
+// updateEntities re-links the entities of a post or comment after it was created or edited and notifies
+// the users mentioned for the first time. Entities are derived from the content, so failures are only logged.
+func updateEntities(db *sql.DB, kind string, id, authorUserID, postID int, content string) {
+	newlyMentioned, err := linkEntities(db, kind, id, content)
+	if err != nil {
+		slog.Error("Error linking entities", "kind", kind, "id", id, "error", err)
+		return
+	}
+
+	commentID := 0
+	if kind == "comment" {
+		commentID = id
+	}
+	notifyMentions(db, authorUserID, postID, commentID, newlyMentioned)
+}
+
+// GetTrendingTags returns the tags used most since the given time in public posts and their comments
+func GetTrendingTags(db *sql.DB, since time.Time, limit int) ([]TrendingTag, error) {
+	sinceStr := since.UTC().Format("2006-01-02 15:04:05")
+	query := `
+	SELECT t.Name, COUNT(*) AS Uses
+	FROM (
+		SELECT pt.TagID FROM PostTags pt
+		JOIN Post p ON pt.PostID = p.PostID
+		WHERE datetime(p.Timestamp) >= datetime(?) AND p.Hidden = FALSE AND p.Published = TRUE AND p.GroupID IS NULL AND p.PrivacySetting = 'public'
+		UNION ALL
+		SELECT ct.TagID FROM CommentTags ct
+		JOIN Comment c ON ct.CommentID = c.CommentID
+		JOIN Post p ON c.PostID = p.PostID
+		WHERE datetime(c.Timestamp) >= datetime(?) AND c.Hidden = FALSE AND p.Hidden = FALSE AND p.Published = TRUE AND p.GroupID IS NULL AND p.PrivacySetting = 'public'
+	) uses
+	JOIN Tags t ON uses.TagID = t.TagID
+	GROUP BY t.TagID
+	ORDER BY Uses DESC, t.Name
+	LIMIT ?`
+	rows, err := db.Query(query, sinceStr, sinceStr, limit)
+	if err != nil {
+		slog.Error("Error fetching trending tags", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []TrendingTag{}
+	for rows.Next() {
+		var tag TrendingTag
+		if err := rows.Scan(&tag.Name, &tag.Uses); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// GetTagPosts returns the posts with the tag that the viewer may see, newest first
+func GetTagPosts(db *sql.DB, viewerID int, tag string, limit, offset int) ([]Post, error) {
+	query := `SELECT p.PostID, p.UserID, p.Content, p.ImageURL, p.Timestamp, p.PrivacySetting, p.AllowedViewers, p.GroupID,
+	u.Nickname, u.FirstName, u.LastName, u.ProfilePicture
+	FROM Post p
+	JOIN User u ON p.UserID = u.UserID
+	JOIN PostTags pt ON pt.PostID = p.PostID
+	JOIN Tags t ON pt.TagID = t.TagID
+	WHERE t.Name = ? AND p.Hidden = FALSE AND p.Published = TRUE
+	AND ` + notBlockedClause("p.UserID") + `
+	AND ` + notMutedClause("p.UserID") + `
+	AND ` + postVisibleClause("p") + `
+	ORDER BY p.Timestamp DESC, p.PostID DESC
+	LIMIT ? OFFSET ?`
+
+	rows, err := db.Query(query, strings.ToLower(strings.TrimPrefix(tag, "#")),
+		viewerID, viewerID, viewerID, viewerID, viewerID, viewerID, viewerID, limit, offset)
+	if err != nil {
+		slog.Error("Error querying posts for tag", "tag", tag, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(&post.PostID, &post.UserID, &post.Content, &post.ImageURL, &post.Timestamp, &post.PrivacySetting, &post.AllowedViewers,
+			&post.GroupID, &post.Nickname, &post.FirstName, &post.LastName, &post.ProfilePicture); err != nil {
+			slog.Error("Error scanning tag post", "error", err)
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	decoratePosts(db, viewerID, posts)
+	return posts, nil
+}
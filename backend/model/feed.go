@@ -0,0 +1,156 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Home feed orderings
+const (
+	FeedModeLatest = "latest"
+	FeedModeTop    = "top"
+)
+
+const (
+	// topFeedWindow is how far back the top feed looks for posts
+	topFeedWindow = 7 * 24 * time.Hour
+	// topFeedActivityWindow is how recent a comment has to be to count towards a post's rank
+	topFeedActivityWindow = 48 * time.Hour
+)
+
+// GetHomeFeed returns the viewer's own posts, posts of the users they follow and posts of their accepted groups,
+// filtered by the feed's privacy rules. The latest mode is newest first; the top mode ranks the posts of the last
+// week by their recent comments, decayed by age; posts have no reactions yet, so comments are the only signal.
+// The returned cursor continues after the last post and is empty when there are no more posts.
+func GetHomeFeed(db *sql.DB, viewerID int, mode string, limit int, cursor string) ([]Post, string, error) {
+	query := `SELECT p.PostID, p.UserID, p.Content, p.ImageURL, p.Timestamp, p.PrivacySetting, p.AllowedViewers, p.GroupID,
+	u.Nickname, u.FirstName, u.LastName, u.ProfilePicture
+	FROM Post p
+	JOIN User u ON p.UserID = u.UserID
+	WHERE p.Hidden = FALSE AND p.Published = TRUE
+	AND (p.UserID = ?
+		OR (p.GroupID IS NULL AND EXISTS(SELECT 1 FROM UserFollowers WHERE FollowerUserID = ? AND FollowingUserID = p.UserID))
+		OR p.GroupID IN (SELECT GroupID FROM GroupMembers WHERE UserID = ? AND Accepted = TRUE))
+	AND ` + notBlockedClause("p.UserID") + `
+	AND ` + notMutedClause("p.UserID") + `
+	AND ` + postVisibleClause("p")
+	args := []interface{}{viewerID, viewerID, viewerID, viewerID, viewerID, viewerID, viewerID, viewerID, viewerID, viewerID}
+
+	offset := 0
+	switch mode {
+	case FeedModeTop:
+		// Ranks change as comments come in, so the top feed pages by position
+		if cursor != "" {
+			var err error
+			if offset, err = decodeTopFeedCursor(cursor); err != nil {
+				return nil, "", err
+			}
+		}
+		activitySince := time.Now().Add(-topFeedActivityWindow).UTC().Format("2006-01-02 15:04:05")
+		query += `
+	AND datetime(p.Timestamp) >= datetime(?)
+	ORDER BY (1.0 + (SELECT COUNT(*) FROM Comment c WHERE c.PostID = p.PostID AND c.Hidden = FALSE AND datetime(c.Timestamp) >= datetime(?)))
+		/ ((julianday('now') - julianday(p.Timestamp)) * 24 + 2) DESC, p.PostID DESC
+	LIMIT ? OFFSET ?`
+		args = append(args, time.Now().Add(-topFeedWindow).UTC().Format("2006-01-02 15:04:05"), activitySince, limit+1, offset)
+	default:
+		// Timestamps are compared and ordered to the second, as datetimes, the same as the cursor holds them
+		if cursor != "" {
+			timestamp, postID, err := decodeLatestFeedCursor(cursor)
+			if err != nil {
+				return nil, "", err
+			}
+			query += `
+	AND (datetime(p.Timestamp) < datetime(?) OR (datetime(p.Timestamp) = datetime(?) AND p.PostID < ?))`
+			args = append(args, timestamp, timestamp, postID)
+		}
+		query += `
+	ORDER BY datetime(p.Timestamp) DESC, p.PostID DESC
+	LIMIT ?`
+		args = append(args, limit+1)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		slog.Error("Error querying home feed", "user_id", viewerID, "error", err)
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(&post.PostID, &post.UserID, &post.Content, &post.ImageURL, &post.Timestamp, &post.PrivacySetting, &post.AllowedViewers,
+			&post.GroupID, &post.Nickname, &post.FirstName, &post.LastName, &post.ProfilePicture); err != nil {
+			slog.Error("Error scanning feed post", "error", err)
+			return nil, "", err
+		}
+		posts = append(posts, post)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	// The query fetches one post more than asked for to tell whether there is a next page
+	nextCursor := ""
+	if len(posts) > limit {
+		posts = posts[:limit]
+		if mode == FeedModeTop {
+			nextCursor = encodeFeedCursor("top", strconv.Itoa(offset+limit))
+		} else {
+			last := posts[limit-1]
+			nextCursor = encodeFeedCursor(last.Timestamp.UTC().Format("2006-01-02 15:04:05"), strconv.Itoa(last.PostID))
+		}
+	}
+
+	decoratePosts(db, viewerID, posts)
+	return posts, nextCursor, nil
+}
+
+func encodeFeedCursor(parts ...string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(parts, "|")))
+}
+
+func decodeFeedCursor(cursor string) ([]string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	return parts, nil
+}
+
+func decodeLatestFeedCursor(cursor string) (string, int, error) {
+	parts, err := decodeFeedCursor(cursor)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := time.Parse("2006-01-02 15:04:05", parts[0]); err != nil {
+		return "", 0, ErrInvalidCursor
+	}
+	postID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, ErrInvalidCursor
+	}
+	return parts[0], postID, nil
+}
+
+func decodeTopFeedCursor(cursor string) (int, error) {
+	parts, err := decodeFeedCursor(cursor)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := strconv.Atoi(parts[1])
+	if err != nil || parts[0] != "top" || offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return offset, nil
+}
@@ -2,7 +2,7 @@ package model
 
 import (
 	"database/sql"
-	"log"
+	"log/slog"
 	"time"
 )
 
@@ -34,6 +34,13 @@ type Event struct {
 	NotGoing      []string `json:"NotGoing"`
 }
 
+type GroupChatRelation struct {
+	GroupID     int    `json:"groupId"`
+	Name        string `json:"name"`
+	RoomID      string `json:"roomId"`
+	UnreadCount int    `json:"unreadCount"`
+}
+
 type GroupJoinRequest struct {
 	UserID  int `json:"userId"`
 	GroupID int `json:"groupId"`
@@ -59,14 +66,14 @@ func CreateGroup(db *sql.DB, group Group, invitedUserIds []int) (*Group, error)
 	statement := `INSERT INTO Cluster (Name, Description, CreatorUserID) VALUES (?, ?, ?)`
 	result, err := db.Exec(statement, group.Name, group.Description, group.CreatorUserID)
 	if err != nil {
-		log.Printf("Error creating group: %v", err)
+		slog.Error("Error creating group", "error", err)
 		return nil, err
 	}
 
 	// Get the ID of the newly created group
 	groupID, err := result.LastInsertId()
 	if err != nil {
-		log.Printf("Error getting last insert ID for group: %v", err)
+		slog.Error("Error getting last insert ID for group", "error", err)
 		return nil, err
 	}
 	group.GroupID = int(groupID)
@@ -74,20 +81,24 @@ func CreateGroup(db *sql.DB, group Group, invitedUserIds []int) (*Group, error)
 	// Insert the creator (CreatorID) into the GroupMembers table
 	_, err = db.Exec(`INSERT INTO GroupMembers (GroupID, UserID, Accepted) VALUES (?, ?, ?)`, group.GroupID, group.CreatorUserID, true)
 	if err != nil {
-		log.Printf("Error adding creator (UserID: %d) to group members: %v", group.CreatorUserID, err)
+		slog.Error("Error adding creator to group members", "user_id", group.CreatorUserID, "error", err)
 		// Decide how you want to handle the error - rollback group creation, continue with other inserts, etc.
 	}
 
 	// Insert invited users into InvitedUsers table
 	for _, userID := range invitedUserIds {
+		if blocked, err := IsBlocked(db, group.CreatorUserID, userID); err != nil || blocked {
+			slog.Debug("Skipping invitation", "user_id", userID, "group_id", group.GroupID)
+			continue
+		}
 		_, err := db.Exec(`INSERT INTO InvitedUsers (GroupID, UserID) VALUES (?, ?)`, group.GroupID, userID)
 		if err != nil {
-			log.Printf("Error inviting user (ID: %d) to group: %v", userID, err)
+			slog.Error("Error inviting user to group", "user_id", userID, "error", err)
 			// Decide how you want to handle the error - rollback group creation, continue with other inserts, etc.
 		}
 	}
 
-	log.Printf("Group created successfully with GroupID: %d", group.GroupID)
+	slog.Debug("Group created", "group_id", group.GroupID)
 	return &group, nil
 }
 
@@ -98,7 +109,7 @@ func GetGroups(db *sql.DB) ([]Group, error) {
 	query := `SELECT GroupID, Name, Description, CreatorUserID FROM Cluster`
 	rows, err := db.Query(query)
 	if err != nil {
-		log.Printf("Error querying for groups: %v", err)
+		slog.Error("Error querying for groups", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -108,7 +119,7 @@ func GetGroups(db *sql.DB) ([]Group, error) {
 		var group Group
 		err := rows.Scan(&group.GroupID, &group.Name, &group.Description, &group.CreatorUserID)
 		if err != nil {
-			log.Printf("Error scanning group: %v", err)
+			slog.Error("Error scanning group", "error", err)
 			return nil, err
 		}
 		groups = append(groups, group)
@@ -116,7 +127,7 @@ func GetGroups(db *sql.DB) ([]Group, error) {
 
 	// Check for errors from iterating over rows
 	if err := rows.Err(); err != nil {
-		log.Printf("Error iterating over rows: %v", err)
+		slog.Error("Error iterating over rows", "error", err)
 		return nil, err
 	}
 
@@ -128,7 +139,7 @@ func GetGroupByID(db *sql.DB, groupID string) (*Group, error) {
 	row := db.QueryRow("SELECT GroupID, Name, Description, CreatorUserID FROM Cluster WHERE GroupID = ?", groupID)
 	err := row.Scan(&group.GroupID, &group.Name, &group.Description, &group.CreatorUserID)
 	if err != nil {
-		log.Printf("Error fetching group by ID: %v", err)
+		slog.Error("Error fetching group by ID", "error", err)
 		return nil, err
 	}
 	return &group, nil
@@ -217,11 +228,9 @@ func GetGroupEvents(db *sql.DB, groupID string) ([]Event, error) {
 	ORDER BY e.CreatedAt DESC
 	`
 
-	log.Printf("Executing query for GroupID: %s", groupID)
-
 	rows, err := db.Query(query, groupID)
 	if err != nil {
-		log.Printf("Error querying events for group %s: %v", groupID, err)
+		slog.Error("Error querying events", "group_id", groupID, "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -233,7 +242,7 @@ func GetGroupEvents(db *sql.DB, groupID string) ([]Event, error) {
 			response sql.NullString
 		)
 		if err := rows.Scan(&event.EventID, &event.GroupID, &event.Title, &event.Description, &event.EventDateTime, &event.CreatorID, &event.CreatedAt, &event.FirstName, &event.LastName, &response); err != nil {
-			log.Printf("Error scanning event for group %s: %v", groupID, err)
+			slog.Error("Error scanning event", "group_id", groupID, "error", err)
 			continue
 		}
 
@@ -262,7 +271,7 @@ func GetGroupEvents(db *sql.DB, groupID string) ([]Event, error) {
 	}
 
 	if len(events) == 0 {
-		log.Printf("No events found for GroupID: %s", groupID)
+		slog.Debug("No events found", "group_id", groupID)
 	}
 
 	return events, nil
@@ -274,7 +283,7 @@ func JoinGroup(db *sql.DB, joinReq GroupJoinRequest) error {
 	query := `SELECT CreatorUserID FROM Cluster WHERE GroupID = ?`
 	err := db.QueryRow(query, joinReq.GroupID).Scan(&creatorUserID)
 	if err != nil {
-		log.Printf("Error finding creator user ID from Cluster: %v", err)
+		slog.Error("Error finding creator user ID from Cluster", "error", err)
 		return err
 	}
 
@@ -282,7 +291,7 @@ func JoinGroup(db *sql.DB, joinReq GroupJoinRequest) error {
 	statement := `INSERT INTO GroupJoinRequests (UserID, GroupID, GroupCreatorId) VALUES (?, ?, ?)`
 	_, err = db.Exec(statement, joinReq.UserID, joinReq.GroupID, creatorUserID)
 	if err != nil {
-		log.Printf("Error inserting join group request into datab: %v", err)
+		slog.Error("Error inserting join group request into datab", "error", err)
 		return err
 	}
 
@@ -293,7 +302,7 @@ func LeaveGroup(db *sql.DB, leaveReq GroupLeaveRequest) error {
 	statement := `DELETE FROM GroupMembers WHERE UserID = ? AND GroupID = ?`
 	_, err := db.Exec(statement, leaveReq.UserID, leaveReq.GroupID)
 	if err != nil {
-		log.Printf("Error inserting join group request into datab: %v", err)
+		slog.Error("Error inserting join group request into datab", "error", err)
 		return err
 	}
 	return nil
@@ -324,6 +333,53 @@ func GetUserGroupMemberships(db *sql.DB, userID int) (map[int]bool, error) {
 	return groupsMap, nil
 }
 
+// GetChatRoomGroup returns the group whose chat room roomID is, or sql.ErrNoRows if there is none or userID
+// isn't an accepted member of it
+func GetChatRoomGroup(db *sql.DB, roomID string, userID int) (int, error) {
+	var groupID int
+	err := db.QueryRow(`SELECT gcr.GroupID FROM GroupChatRoom gcr
+	JOIN GroupMembers gm ON gcr.GroupID = gm.GroupID
+	WHERE gcr.RoomID = ? AND gm.UserID = ? AND gm.Accepted = TRUE`, roomID, userID).Scan(&groupID)
+	return groupID, err
+}
+
+// GetUserGroupChatRelations returns the group chat rooms of every group the user is an accepted member of,
+// together with the number of messages posted by others since the user last read the room.
+func GetUserGroupChatRelations(db *sql.DB, userID int) (map[int]GroupChatRelation, error) {
+	relations := make(map[int]GroupChatRelation)
+	query := `
+	SELECT c.GroupID, c.Name, gcr.RoomID,
+				 IFNULL((SELECT COUNT(*) FROM GroupChatMessage m
+				 WHERE m.RoomID = gcr.RoomID AND m.SenderUserID != ? AND m.Timestamp > IFNULL(gr.LastReadAt, '')), 0) AS UnreadCount
+	FROM GroupMembers gm
+	JOIN Cluster c ON gm.GroupID = c.GroupID
+	LEFT JOIN GroupChatRoom gcr ON c.GroupID = gcr.GroupID
+	LEFT JOIN GroupChatRead gr ON gcr.RoomID = gr.RoomID AND gr.UserID = gm.UserID
+	WHERE gm.UserID = ? AND gm.Accepted = TRUE
+	`
+	rows, err := db.Query(query, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var relation GroupChatRelation
+		var roomID sql.NullString // The room is only created once somebody joins the group chat
+		if err := rows.Scan(&relation.GroupID, &relation.Name, &roomID, &relation.UnreadCount); err != nil {
+			return nil, err
+		}
+		relation.RoomID = roomID.String
+		relations[relation.GroupID] = relation
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return relations, nil
+}
+
 func GetUserGroupJoinRequests(db *sql.DB, userID int) (map[int]bool, error) {
 	requestsMap := make(map[int]bool)
 	query := `
@@ -349,11 +405,19 @@ func GetUserGroupJoinRequests(db *sql.DB, userID int) (map[int]bool, error) {
 	return requestsMap, nil
 }
 
-func InviteUsersToGroup(db *sql.DB, groupId int, userIds []int) error {
+// InviteUsersToGroup invites the users to the group, skipping those the inviter blocked or was blocked by
+func InviteUsersToGroup(db *sql.DB, groupId int, inviterUserID int, userIds []int) error {
 	statement := `INSERT INTO InvitedUsers (GroupID, UserID) VALUES (?, ?)`
 
 	for _, userId := range userIds {
-		_, err := db.Exec(statement, groupId, userId)
+		blocked, err := IsBlocked(db, inviterUserID, userId)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			continue
+		}
+		_, err = db.Exec(statement, groupId, userId)
 		if err != nil {
 			return err
 		}
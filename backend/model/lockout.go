@@ -0,0 +1,61 @@
+package model
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// ErrAccountLocked is returned for logins to an account locked after too many failed attempts; RetryAfter says
+// when it unlocks
+type ErrAccountLocked struct {
+	RetryAfter time.Duration
+}
+
+func (e *ErrAccountLocked) Error() string {
+	return "too many failed logins, try again later"
+}
+
+// CheckLoginLock returns ErrAccountLocked while the user's account is locked
+func CheckLoginLock(db *sql.DB, userID int, now time.Time) error {
+	var lockedUntil sql.NullTime
+	err := db.QueryRow(`SELECT LockedUntil FROM User WHERE UserID = ?`, userID).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+	if !lockedUntil.Valid {
+		return nil
+	}
+	if wait := lockedUntil.Time.Sub(now); wait > 0 {
+		return &ErrAccountLocked{RetryAfter: wait}
+	}
+	return nil
+}
+
+// RecordLoginFailure counts a failed login of the user and returns how many failed in a row
+func RecordLoginFailure(db *sql.DB, userID int) (int, error) {
+	if _, err := db.Exec(`UPDATE User SET FailedLogins = FailedLogins + 1 WHERE UserID = ?`, userID); err != nil {
+		slog.Error("Error recording failed login", "user_id", userID, "error", err)
+		return 0, err
+	}
+	var failures int
+	err := db.QueryRow(`SELECT FailedLogins FROM User WHERE UserID = ?`, userID).Scan(&failures)
+	return failures, err
+}
+
+// LockAccount stops the user from logging in until the given time
+func LockAccount(db *sql.DB, userID int, until time.Time) error {
+	_, err := db.Exec(`UPDATE User SET LockedUntil = ? WHERE UserID = ?`, until.UTC().Format("2006-01-02 15:04:05"), userID)
+	if err != nil {
+		slog.Error("Error locking account", "user_id", userID, "error", err)
+	}
+	return err
+}
+
+// ResetLoginFailures clears the failed logins of the user after a successful one
+func ResetLoginFailures(db *sql.DB, userID int) error {
+	_, err := db.Exec(`UPDATE User SET FailedLogins = 0, LockedUntil = NULL WHERE UserID = ? AND (FailedLogins != 0 OR LockedUntil IS NOT NULL)`, userID)
+	return err
+}
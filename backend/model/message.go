@@ -0,0 +1,111 @@
+package model
+
+import (
+	"database/sql"
+	"html"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+type MessageSearchResult struct {
+	MessageID            string    `json:"messageId"`
+	RoomID               string    `json:"roomId"`
+	GroupID              int       `json:"groupId,omitempty"`
+	GroupName            string    `json:"groupName,omitempty"`
+	SenderUserID         int       `json:"senderUserId"`
+	SenderFirstName      string    `json:"senderFirstName"`
+	SenderLastName       string    `json:"senderLastName"`
+	SenderNickname       string    `json:"senderNickname"`
+	SenderProfilePicture string    `json:"senderProfilePicture"`
+	Content              string    `json:"content"`
+	Highlighted          string    `json:"highlighted"`
+	Timestamp            time.Time `json:"timestamp"`
+}
+
+// SearchMessages looks for the search term in the direct messages the user sent or received
+// and in the chats of the groups the user is an accepted member of, newest first.
+func SearchMessages(db *sql.DB, userID int, term string, limit, offset int) ([]MessageSearchResult, error) {
+	pattern := "%" + escapeLike(term) + "%"
+
+	query := `
+	SELECT m.MessageID, m.RoomID, 0 AS GroupID, '' AS GroupName, m.Content, m.Timestamp,
+				 s.UserID, s.FirstName, s.LastName, s.Nickname, s.ProfilePicture
+	FROM Message m
+	JOIN User s ON m.SenderUserID = s.UserID
+	WHERE (m.SenderUserID = ? OR m.ReceiverUserID = ?) AND m.Hidden = FALSE AND m.Content LIKE ? ESCAPE '\'
+	UNION ALL
+	SELECT g.MessageID, g.RoomID, g.GroupID, c.Name, g.Content, g.Timestamp,
+				 s.UserID, s.FirstName, s.LastName, s.Nickname, s.ProfilePicture
+	FROM GroupChatMessage g
+	JOIN User s ON g.SenderUserID = s.UserID
+	JOIN Cluster c ON g.GroupID = c.GroupID
+	WHERE g.GroupID IN (SELECT GroupID FROM GroupMembers WHERE UserID = ? AND Accepted = TRUE)
+	AND g.Hidden = FALSE AND g.Content LIKE ? ESCAPE '\'
+	ORDER BY 6 DESC
+	LIMIT ? OFFSET ?`
+
+	rows, err := db.Query(query, userID, userID, pattern, userID, pattern, limit, offset)
+	if err != nil {
+		slog.Error("Error searching messages", "user_id", userID, "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []MessageSearchResult{}
+	for rows.Next() {
+		var result MessageSearchResult
+		if err := rows.Scan(&result.MessageID, &result.RoomID, &result.GroupID, &result.GroupName, &result.Content, &result.Timestamp,
+			&result.SenderUserID, &result.SenderFirstName, &result.SenderLastName, &result.SenderNickname, &result.SenderProfilePicture); err != nil {
+			slog.Error("Error scanning message search result", "error", err)
+			return nil, err
+		}
+		result.Highlighted = highlightTerm(result.Content, term)
+		results = append(results, result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// escapeLike escapes the LIKE wildcards so the term is matched literally
+func escapeLike(term string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(term)
+}
+
+// highlightTerm HTML-escapes the content and wraps every case-insensitive occurrence of term in <mark> tags
+func highlightTerm(content, term string) string {
+	if term == "" {
+		return html.EscapeString(content)
+	}
+
+	lowerContent := strings.ToLower(content)
+	lowerTerm := strings.ToLower(term)
+	// Lower-casing may change byte lengths for some runes; fall back to plain escaping in that case
+	if len(lowerContent) != len(content) || len(lowerTerm) != len(term) {
+		return html.EscapeString(content)
+	}
+
+	var builder strings.Builder
+	start := 0
+	for {
+		index := strings.Index(lowerContent[start:], lowerTerm)
+		if index < 0 {
+			builder.WriteString(html.EscapeString(content[start:]))
+			break
+		}
+		matchStart := start + index
+		matchEnd := matchStart + len(term)
+		builder.WriteString(html.EscapeString(content[start:matchStart]))
+		builder.WriteString("<mark>")
+		builder.WriteString(html.EscapeString(content[matchStart:matchEnd]))
+		builder.WriteString("</mark>")
+		start = matchEnd
+	}
+
+	return builder.String()
+}
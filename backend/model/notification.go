@@ -0,0 +1,93 @@
+package model
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// Notification types
+const (
+	NotificationMention = "mention"
+)
+
+type Notification struct {
+	NotificationID      int       `json:"notificationId"`
+	UserID              int       `json:"userID"`
+	Type                string    `json:"type"`
+	Content             string    `json:"content"`
+	ActorUserID         int       `json:"actorUserId,omitempty"`
+	ActorFirstName      string    `json:"actorFirstName,omitempty"`
+	ActorLastName       string    `json:"actorLastName,omitempty"`
+	ActorProfilePicture string    `json:"actorProfilePicture,omitempty"`
+	PostID              int       `json:"postId,omitempty"`
+	CommentID           int       `json:"commentId,omitempty"`
+	Timestamp           time.Time `json:"timestamp"`
+	Read                bool      `json:"read"`
+}
+
+func CreateNotification(db *sql.DB, notification Notification) error {
+	var postID, commentID sql.NullInt64
+	if notification.PostID != 0 {
+		postID = sql.NullInt64{Int64: int64(notification.PostID), Valid: true}
+	}
+	if notification.CommentID != 0 {
+		commentID = sql.NullInt64{Int64: int64(notification.CommentID), Valid: true}
+	}
+
+	_, err := db.Exec(`INSERT INTO Notification (UserID, Type, Content, ActorUserID, PostID, CommentID, ReadStatus) VALUES (?, ?, ?, ?, ?, ?, FALSE)`,
+		notification.UserID, notification.Type, notification.Content, notification.ActorUserID, postID, commentID)
+	if err != nil {
+		slog.Error("Error creating notification", "user_id", notification.UserID, "error", err)
+		return err
+	}
+	return nil
+}
+
+// GetNotifications returns the notifications of the user, newest first
+func GetNotifications(db *sql.DB, userID, limit, offset int) ([]Notification, error) {
+	query := `
+	SELECT n.NotificationID, n.UserID, IFNULL(n.Type, ''), IFNULL(n.Content, ''), IFNULL(n.ActorUserID, 0),
+				 IFNULL(a.FirstName, ''), IFNULL(a.LastName, ''), IFNULL(a.ProfilePicture, ''),
+				 IFNULL(n.PostID, 0), IFNULL(n.CommentID, 0), n.Timestamp, IFNULL(n.ReadStatus, FALSE)
+	FROM Notification n
+	LEFT JOIN User a ON n.ActorUserID = a.UserID
+	WHERE n.UserID = ?
+	ORDER BY n.Timestamp DESC, n.NotificationID DESC
+	LIMIT ? OFFSET ?`
+	rows, err := db.Query(query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var notification Notification
+		if err := rows.Scan(&notification.NotificationID, &notification.UserID, &notification.Type, &notification.Content,
+			&notification.ActorUserID, &notification.ActorFirstName, &notification.ActorLastName, &notification.ActorProfilePicture,
+			&notification.PostID, &notification.CommentID, &notification.Timestamp, &notification.Read); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, notification)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+// MarkNotificationsRead marks the given notifications of the user as read, or all of them when no IDs are given
+func MarkNotificationsRead(db *sql.DB, userID int, notificationIDs []int) error {
+	if len(notificationIDs) == 0 {
+		_, err := db.Exec(`UPDATE Notification SET ReadStatus = TRUE WHERE UserID = ?`, userID)
+		return err
+	}
+
+	placeholders, args := inClause(notificationIDs)
+	args = append(args, userID)
+	_, err := db.Exec(`UPDATE Notification SET ReadStatus = TRUE WHERE NotificationID IN (`+placeholders+`) AND UserID = ?`, args...)
+	return err
+}
@@ -0,0 +1,188 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrWrongPassword     = errors.New("current password is wrong")
+	ErrWeakPassword      = errors.New("password must be 8 to 72 bytes long")
+	ErrInvalidResetToken = errors.New("reset link is invalid or expired")
+)
+
+// passwordResetTTL is how long a password reset link works
+const passwordResetTTL = time.Hour
+
+// ValidatePassword checks a new password is long enough, and short enough for bcrypt to use all of it
+func ValidatePassword(password string) error {
+	if len(password) < 8 || len(password) > 72 {
+		return ErrWeakPassword
+	}
+	return nil
+}
+
+// ChangePassword sets a new password for the user after checking the current one, and ends the user's
+// sessions other than keepSessionID
+func ChangePassword(db *sql.DB, userID int, keepSessionID, currentPassword, newPassword string) error {
+	var passwordHash string
+	err := db.QueryRow(`SELECT PasswordHash FROM User WHERE UserID = ?`, userID).Scan(&passwordHash)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(currentPassword)) != nil {
+		return ErrWrongPassword
+	}
+
+	return setPassword(db, userID, newPassword, keepSessionID)
+}
+
+// setPassword stores the hash of the password and ends the user's sessions other than keepSessionID
+func setPassword(db *sql.DB, userID int, password, keepSessionID string) error {
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = updatePassword(tx, userID, hashedPassword, keepSessionID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// hashPassword validates the password and returns its bcrypt hash
+func hashPassword(password string) (string, error) {
+	if err := ValidatePassword(password); err != nil {
+		return "", err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+// updatePassword stores the password hash and ends the user's sessions other than keepSessionID, in the
+// caller's transaction
+func updatePassword(tx *sql.Tx, userID int, hashedPassword, keepSessionID string) error {
+	if _, err := tx.Exec(`UPDATE User SET PasswordHash = ? WHERE UserID = ?`, hashedPassword, userID); err != nil {
+		slog.Error("Error updating password", "user_id", userID, "error", err)
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM Sessions WHERE UserID = ? AND SessionID != ?`, userID, keepSessionID)
+	return err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreatePasswordReset issues a reset token for the user with the email and returns it, replacing the user's earlier
+// tokens. It returns an empty token without an error when no user has the email, so callers can answer the same
+// either way.
+func CreatePasswordReset(db *sql.DB, email string) (string, error) {
+	var userID int
+	err := db.QueryRow(`SELECT UserID FROM User WHERE Email = ?`, email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(bytes)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM PasswordResets WHERE UserID = ?`, userID); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	_, err = tx.Exec(`INSERT INTO PasswordResets (TokenHash, UserID, ExpiresAt) VALUES (?, ?, ?)`,
+		hashToken(token), userID, time.Now().Add(passwordResetTTL).UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		slog.Error("Error creating password reset", "user_id", userID, "error", err)
+		tx.Rollback()
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResetPassword uses up a reset token to set a new password and ends all sessions of the token's user. The token
+// is only used up together with the password change, so a failed reset leaves the link working.
+func ResetPassword(db *sql.DB, token, newPassword string) error {
+	// Hash first, bcrypt is too slow to run inside the transaction
+	hashedPassword, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var userID int
+	err = tx.QueryRow(`SELECT UserID FROM PasswordResets WHERE TokenHash = ? AND UsedAt IS NULL AND ExpiresAt > ?`,
+		hashToken(token), time.Now().UTC().Format("2006-01-02 15:04:05")).Scan(&userID)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return ErrInvalidResetToken
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Marking the token used only succeeds once, so two requests racing with the same token can't both reset
+	result, err := tx.Exec(`UPDATE PasswordResets SET UsedAt = CURRENT_TIMESTAMP WHERE TokenHash = ? AND UsedAt IS NULL`, hashToken(token))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return ErrInvalidResetToken
+	}
+
+	if err = updatePassword(tx, userID, hashedPassword, ""); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// cleanupPasswordResets removes the reset tokens that can no longer be used
+func cleanupPasswordResets(db *sql.DB) {
+	_, err := db.Exec(`DELETE FROM PasswordResets WHERE ExpiresAt < ? OR UsedAt IS NOT NULL`, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		slog.Error("Error cleaning up password resets", "error", err)
+	}
+}
@@ -0,0 +1,374 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidPoll    = errors.New("invalid poll")
+	ErrPollNotFound   = errors.New("poll not found")
+	ErrPollClosed     = errors.New("poll is closed")
+	ErrAlreadyVoted   = errors.New("already voted")
+	ErrInvalidVote    = errors.New("invalid vote")
+	ErrNotGroupMember = errors.New("not a member of the group")
+)
+
+// NewPoll is a poll as sent along with a new post
+type NewPoll struct {
+	Question       string     `json:"question"`
+	Options        []string   `json:"options"`
+	MultipleChoice bool       `json:"multipleChoice"`
+	ClosesAt       *time.Time `json:"closesAt"`
+}
+
+type Poll struct {
+	PollID         int          `json:"pollId"`
+	PostID         int          `json:"postId"`
+	Question       string       `json:"question"`
+	MultipleChoice bool         `json:"multipleChoice"`
+	ClosesAt       *time.Time   `json:"closesAt,omitempty"`
+	Closed         bool         `json:"closed"`
+	HasVoted       bool         `json:"hasVoted"`
+	MyVotes        []int        `json:"myVotes"`
+	ResultsVisible bool         `json:"resultsVisible"`
+	TotalVoters    int          `json:"totalVoters"`
+	Options        []PollOption `json:"options"`
+}
+
+type PollOption struct {
+	OptionID int    `json:"optionId"`
+	Text     string `json:"text"`
+	// Votes is left out until the viewer voted or the poll closed
+	Votes *int `json:"votes,omitempty"`
+}
+
+// ValidatePoll trims the poll and checks it has a question, 2 to 10 distinct options and a close time in the future
+func ValidatePoll(poll *NewPoll) error {
+	poll.Question = strings.TrimSpace(poll.Question)
+	if poll.Question == "" || len(poll.Question) > 500 {
+		return ErrInvalidPoll
+	}
+	if len(poll.Options) < 2 || len(poll.Options) > 10 {
+		return ErrInvalidPoll
+	}
+
+	seen := map[string]bool{}
+	for i, option := range poll.Options {
+		option = strings.TrimSpace(option)
+		if option == "" || len(option) > 255 || seen[strings.ToLower(option)] {
+			return ErrInvalidPoll
+		}
+		seen[strings.ToLower(option)] = true
+		poll.Options[i] = option
+	}
+
+	if poll.ClosesAt != nil && !poll.ClosesAt.After(time.Now()) {
+		return ErrInvalidPoll
+	}
+	return nil
+}
+
+// CreatePoll attaches a validated poll to a post
+func CreatePoll(db *sql.DB, postID int, poll NewPoll) error {
+	var closesAt sql.NullString
+	if poll.ClosesAt != nil {
+		closesAt = sql.NullString{String: poll.ClosesAt.UTC().Format("2006-01-02 15:04:05"), Valid: true}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`INSERT INTO Polls (PostID, Question, MultipleChoice, ClosesAt) VALUES (?, ?, ?, ?)`,
+		postID, poll.Question, poll.MultipleChoice, closesAt)
+	if err != nil {
+		slog.Error("Error creating poll", "post_id", postID, "error", err)
+		tx.Rollback()
+		return err
+	}
+
+	pollID, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for position, option := range poll.Options {
+		if _, err = tx.Exec(`INSERT INTO PollOptions (PollID, Position, Text) VALUES (?, ?, ?)`, pollID, position, option); err != nil {
+			slog.Error("Error creating poll option", "poll_id", pollID, "error", err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetPoll returns the poll as the viewer sees it
+func GetPoll(db *sql.DB, pollID, viewerID int) (*Poll, error) {
+	polls, err := loadPolls(db, viewerID, "PollID", []int{pollID})
+	if err != nil {
+		return nil, err
+	}
+	for _, poll := range polls {
+		return poll, nil
+	}
+	return nil, ErrPollNotFound
+}
+
+// GetPollForPost returns the poll of a post as the viewer sees it
+func GetPollForPost(db *sql.DB, postID, viewerID int) (*Poll, error) {
+	polls, err := loadPolls(db, viewerID, "PostID", []int{postID})
+	if err != nil {
+		return nil, err
+	}
+	for _, poll := range polls {
+		return poll, nil
+	}
+	return nil, ErrPollNotFound
+}
+
+// VotePoll records the user's one vote on a poll of a post they can see and returns the ID of the post.
+// A single choice poll takes exactly one option, a multiple choice poll one or more.
+func VotePoll(db *sql.DB, pollID, userID int, optionIDs []int) (int, error) {
+	var postID int
+	var multipleChoice bool
+	var closesAt sql.NullTime
+	var groupID sql.NullInt64
+	err := db.QueryRow(`SELECT pl.PostID, pl.MultipleChoice, pl.ClosesAt, p.GroupID FROM Polls pl
+	JOIN Post p ON pl.PostID = p.PostID
+	WHERE pl.PollID = ?`, pollID).Scan(&postID, &multipleChoice, &closesAt, &groupID)
+	if err == sql.ErrNoRows {
+		return 0, ErrPollNotFound
+	} else if err != nil {
+		return 0, err
+	}
+
+	visible, err := CanViewPost(db, userID, postID)
+	if err != nil {
+		return 0, err
+	}
+	if !visible {
+		return 0, ErrPollNotFound
+	}
+
+	// Authors see their own group posts even after leaving, but only members get a say
+	if groupID.Valid {
+		var member bool
+		err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM GroupMembers WHERE GroupID = ? AND UserID = ? AND Accepted = TRUE)`,
+			groupID.Int64, userID).Scan(&member)
+		if err != nil {
+			return 0, err
+		}
+		if !member {
+			return 0, ErrNotGroupMember
+		}
+	}
+
+	if closesAt.Valid && !time.Now().Before(closesAt.Time) {
+		return 0, ErrPollClosed
+	}
+
+	chosen := map[int]bool{}
+	for _, optionID := range optionIDs {
+		chosen[optionID] = true
+	}
+	if len(chosen) == 0 || len(chosen) != len(optionIDs) || (!multipleChoice && len(chosen) != 1) {
+		return 0, ErrInvalidVote
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var voted bool
+	if err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM PollVotes WHERE PollID = ? AND UserID = ?)`, pollID, userID).Scan(&voted); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if voted {
+		tx.Rollback()
+		return 0, ErrAlreadyVoted
+	}
+
+	for optionID := range chosen {
+		// The option has to belong to this poll
+		result, err := tx.Exec(`INSERT INTO PollVotes (PollID, OptionID, UserID)
+		SELECT PollID, OptionID, ? FROM PollOptions WHERE OptionID = ? AND PollID = ?`, userID, optionID, pollID)
+		if err != nil {
+			slog.Error("Error voting on poll", "poll_id", pollID, "error", err)
+			tx.Rollback()
+			return 0, err
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			tx.Rollback()
+			return 0, ErrInvalidVote
+		}
+	}
+
+	return postID, tx.Commit()
+}
+
+// GetPollResults returns the poll with all its tallies and no viewer's votes, for ViewPoll to show to each viewer
+func GetPollResults(db *sql.DB, pollID int) (*Poll, error) {
+	polls, err := loadPollResults(db, 0, "PollID", []int{pollID})
+	if err != nil {
+		return nil, err
+	}
+	for _, poll := range polls {
+		return poll, nil
+	}
+	return nil, ErrPollNotFound
+}
+
+// ViewPoll returns the poll of GetPollResults as the viewer sees it, or nil if they can't see its post
+func ViewPoll(db *sql.DB, results *Poll, viewerID int) (*Poll, error) {
+	visible, err := CanViewPost(db, viewerID, results.PostID)
+	if err != nil || !visible {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT OptionID FROM PollVotes WHERE PollID = ? AND UserID = ?`, results.PollID, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	poll := *results
+	poll.MyVotes = []int{}
+	for rows.Next() {
+		var optionID int
+		if err := rows.Scan(&optionID); err != nil {
+			return nil, err
+		}
+		poll.MyVotes = append(poll.MyVotes, optionID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	poll.HasVoted = len(poll.MyVotes) > 0
+	poll.Options = append([]PollOption(nil), results.Options...)
+	hideResults(&poll)
+	return &poll, nil
+}
+
+// attachPolls fills in the polls of the posts as the viewer sees them
+func attachPolls(db *sql.DB, viewerID int, posts []Post) {
+	ids := make([]int, len(posts))
+	for i, post := range posts {
+		ids[i] = post.PostID
+	}
+
+	polls, err := loadPolls(db, viewerID, "PostID", ids)
+	if err != nil {
+		slog.Error("Error fetching polls", "error", err)
+		return
+	}
+	for _, poll := range polls {
+		for i := range posts {
+			if posts[i].PostID == poll.PostID {
+				posts[i].Poll = poll
+			}
+		}
+	}
+}
+
+// loadPolls loads the polls whose PollID or PostID column is in ids, with the results hidden from a viewer
+// that hasn't voted on an open poll
+func loadPolls(db *sql.DB, viewerID int, column string, ids []int) (map[int]*Poll, error) {
+	polls, err := loadPollResults(db, viewerID, column, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, poll := range polls {
+		hideResults(poll)
+	}
+	return polls, nil
+}
+
+// hideResults leaves the tallies out unless the viewer voted or the poll closed
+func hideResults(poll *Poll) {
+	poll.ResultsVisible = poll.HasVoted || poll.Closed
+	if !poll.ResultsVisible {
+		for i := range poll.Options {
+			poll.Options[i].Votes = nil
+		}
+	}
+}
+
+// loadPollResults loads the polls like loadPolls, with all the tallies
+func loadPollResults(db *sql.DB, viewerID int, column string, ids []int) (map[int]*Poll, error) {
+	polls := map[int]*Poll{}
+	if len(ids) == 0 {
+		return polls, nil
+	}
+
+	placeholders, args := inClause(ids)
+	rows, err := db.Query(`SELECT PollID, PostID, Question, MultipleChoice, ClosesAt,
+	(SELECT COUNT(DISTINCT UserID) FROM PollVotes v WHERE v.PollID = Polls.PollID)
+	FROM Polls WHERE `+column+` IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	var pollIDs []int
+	for rows.Next() {
+		var poll Poll
+		var closesAt sql.NullTime
+		if err := rows.Scan(&poll.PollID, &poll.PostID, &poll.Question, &poll.MultipleChoice, &closesAt, &poll.TotalVoters); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if closesAt.Valid {
+			poll.ClosesAt = &closesAt.Time
+			poll.Closed = !time.Now().Before(closesAt.Time)
+		}
+		poll.MyVotes = []int{}
+		poll.Options = []PollOption{}
+		polls[poll.PollID] = &poll
+		pollIDs = append(pollIDs, poll.PollID)
+	}
+	rows.Close()
+	if len(pollIDs) == 0 {
+		return polls, nil
+	}
+
+	placeholders, args = inClause(pollIDs)
+	args = append([]interface{}{viewerID}, args...)
+	rows, err = db.Query(`SELECT o.PollID, o.OptionID, o.Text,
+	(SELECT COUNT(*) FROM PollVotes v WHERE v.OptionID = o.OptionID),
+	EXISTS(SELECT 1 FROM PollVotes v WHERE v.OptionID = o.OptionID AND v.UserID = ?)
+	FROM PollOptions o
+	WHERE o.PollID IN (`+placeholders+`)
+	ORDER BY o.PollID, o.Position`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pollID, votes int
+		var option PollOption
+		var mine bool
+		if err := rows.Scan(&pollID, &option.OptionID, &option.Text, &votes, &mine); err != nil {
+			return nil, err
+		}
+		poll := polls[pollID]
+		if mine {
+			poll.MyVotes = append(poll.MyVotes, option.OptionID)
+			poll.HasVoted = true
+		}
+		option.Votes = &votes
+		poll.Options = append(poll.Options, option)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return polls, nil
+}
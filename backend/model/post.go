@@ -2,10 +2,31 @@ package model
 
 import (
 	"database/sql"
-	"log"
+	"errors"
+	"log/slog"
 	"time"
 )
 
+// ErrPostNotFound is returned when a post doesn't exist or the user may not change it
+var ErrPostNotFound = errors.New("post not found")
+
+// postVisibleClause returns a condition that keeps the posts the viewer may see: their own posts, public posts,
+// private posts of users they follow, almost private posts that list them, and posts of groups they are an
+// accepted member of. The condition takes the viewer's user ID four times as arguments. AllowedViewers that isn't
+// valid JSON lists nobody, rather than failing the whole query.
+func postVisibleClause(postAlias string) string {
+	return `(` + postAlias + `.UserID = ?
+		OR (` + postAlias + `.GroupID IS NULL AND (` + postAlias + `.PrivacySetting = 'public'
+			OR (` + postAlias + `.PrivacySetting = 'private'
+				AND EXISTS(SELECT 1 FROM UserFollowers WHERE FollowerUserID = ? AND FollowingUserID = ` + postAlias + `.UserID))
+			OR (` + postAlias + `.PrivacySetting = 'almost_private'
+				AND CASE WHEN json_valid(` + postAlias + `.AllowedViewers)
+					THEN EXISTS(SELECT 1 FROM json_each(` + postAlias + `.AllowedViewers) WHERE json_each.value = ?)
+					ELSE FALSE END)))
+		OR (` + postAlias + `.GroupID IS NOT NULL
+			AND EXISTS(SELECT 1 FROM GroupMembers WHERE GroupID = ` + postAlias + `.GroupID AND UserID = ? AND Accepted = TRUE)))`
+}
+
 type Post struct {
 	PostID         int           `json:"postID"`
 	UserID         int           `json:"userID"`
@@ -19,22 +40,49 @@ type Post struct {
 	LastName       string        `json:"lastName"`
 	ProfilePicture string        `json:"profilePicture"`
 	GroupID        sql.NullInt64 `json:"groupID,omitempty"`
+	Entities       []Entity      `json:"entities"`
+	RepostOfPostID int           `json:"repostOfPostId,omitempty"`
+	RepostOf       *Post         `json:"repostOf,omitempty"`
+	// OriginalUnavailable is set on reposts whose original was deleted or can't be seen by the viewer
+	OriginalUnavailable bool  `json:"originalUnavailable,omitempty"`
+	ShareCount          int   `json:"shareCount"`
+	Poll                *Poll `json:"poll,omitempty"`
+	// Draft is set on posts that aren't published yet, PublishAt on the drafts scheduled to be
+	Draft     bool       `json:"draft,omitempty"`
+	PublishAt *time.Time `json:"publishAt,omitempty"`
 }
 
 // CreatePost inserts a new post into the datab and returns the post with user details
 func CreatePost(db *sql.DB, post Post) (*Post, error) {
+	post.Draft = false
+	post.PublishAt = nil
+	return insertPost(db, post)
+}
+
+// insertPost inserts a post, published unless it is a draft, and returns it with user details
+func insertPost(db *sql.DB, post Post) (*Post, error) {
 	// Insert the new post into the datab
-	statement := `INSERT INTO Post (UserID, Content, PrivacySetting, ImageURL, AllowedViewers, GroupID) VALUES (?, ?, ?, ?, ?, ?)`
-	result, err := db.Exec(statement, post.UserID, post.Content, post.PrivacySetting, post.ImageURL, post.AllowedViewers, post.GroupID)
+	var repostOf sql.NullInt64
+	if post.RepostOfPostID != 0 {
+		repostOf = sql.NullInt64{Int64: int64(post.RepostOfPostID), Valid: true}
+	}
+	var publishAt sql.NullString
+	if post.PublishAt != nil {
+		publishAt = sql.NullString{String: post.PublishAt.UTC().Format("2006-01-02 15:04:05"), Valid: true}
+	}
+	statement := `INSERT INTO Post (UserID, Content, PrivacySetting, ImageURL, AllowedViewers, GroupID, RepostOfPostID, Published, PublishAt)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := db.Exec(statement, post.UserID, post.Content, post.PrivacySetting, post.ImageURL, post.AllowedViewers, post.GroupID, repostOf,
+		!post.Draft, publishAt)
 	if err != nil {
-		log.Printf("Error creating post with image: %v", err)
+		slog.Error("Error creating post with image", "error", err)
 		return nil, err
 	}
 
 	// Get the ID of the newly created post
 	postID, err := result.LastInsertId()
 	if err != nil {
-		log.Printf("Error getting last insert ID: %v", err)
+		slog.Error("Error getting last insert ID", "error", err)
 		return nil, err
 	}
 	post.PostID = int(postID)
@@ -51,26 +99,35 @@ func CreatePost(db *sql.DB, post Post) (*Post, error) {
 		&post.Nickname, &post.FirstName, &post.LastName, &post.ProfilePicture,
 	)
 	if err != nil {
-		log.Printf("Error retrieving new post: %v", err)
+		slog.Error("Error retrieving new post", "error", err)
 		return nil, err
 	}
 
-	log.Printf("Post with image created successfully with PostID: %d", post.PostID)
+	updateEntities(db, "post", post.PostID, post.UserID, post.PostID, post.Content)
+	posts := []Post{post}
+	decoratePosts(db, post.UserID, posts)
+	post = posts[0]
+
+	slog.Debug("Post created", "post_id", post.PostID)
 	return &post, nil // Return the full post object
 }
 
-func GetPosts(db *sql.DB) ([]Post, error) {
+// GetPosts returns the posts outside groups the viewer may see, leaving out authors the viewer blocked, was blocked by or muted
+func GetPosts(db *sql.DB, viewerID int) ([]Post, error) {
 	var posts []Post
 	query := `SELECT p.PostID, p.UserID, p.Content, p.ImageURL, p.Timestamp, p.PrivacySetting, p.AllowedViewers,
 	u.Nickname, u.FirstName, u.LastName, u.ProfilePicture
 	FROM Post p
 	JOIN User u ON p.UserID = u.UserID
-	WHERE p.GroupID IS NULL
+	WHERE p.GroupID IS NULL AND p.Hidden = FALSE AND p.Published = TRUE
+	AND ` + notBlockedClause("p.UserID") + `
+	AND ` + notMutedClause("p.UserID") + `
+	AND ` + postVisibleClause("p") + `
 	ORDER BY p.Timestamp DESC`
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, viewerID, viewerID, viewerID, viewerID, viewerID, viewerID, viewerID)
 	if err != nil {
-		log.Printf("Error querying posts: %v", err)
+		slog.Error("Error querying posts", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -79,27 +136,29 @@ func GetPosts(db *sql.DB) ([]Post, error) {
 		var post Post
 		if err := rows.Scan(&post.PostID, &post.UserID, &post.Content, &post.ImageURL, &post.Timestamp, &post.PrivacySetting, &post.AllowedViewers,
 			&post.Nickname, &post.FirstName, &post.LastName, &post.ProfilePicture); err != nil {
-			log.Printf("Error scanning post: %v", err)
+			slog.Error("Error scanning post", "error", err)
 			continue
 		}
 		posts = append(posts, post)
 	}
 
+	decoratePosts(db, viewerID, posts)
 	return posts, nil
 }
 
-func GetGroupPosts(db *sql.DB, groupID string) ([]Post, error) {
+func GetGroupPosts(db *sql.DB, groupID string, viewerID int) ([]Post, error) {
 	var posts []Post
 	query := `SELECT p.PostID, p.UserID, p.Content, p.ImageURL, p.Timestamp, p.PrivacySetting, p.AllowedViewers,
 			  u.Nickname, u.FirstName, u.LastName, u.ProfilePicture
 			  FROM Post p
 			  JOIN User u ON p.UserID = u.UserID
-			  WHERE p.GroupID = ?
+			  WHERE p.GroupID = ? AND p.Hidden = FALSE AND p.Published = TRUE
+			  AND ` + notBlockedClause("p.UserID") + `
 			  ORDER BY p.Timestamp DESC`
 
-	rows, err := db.Query(query, groupID)
+	rows, err := db.Query(query, groupID, viewerID, viewerID)
 	if err != nil {
-		log.Printf("Error querying group posts: %v", err)
+		slog.Error("Error querying group posts", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -108,12 +167,81 @@ func GetGroupPosts(db *sql.DB, groupID string) ([]Post, error) {
 		var post Post
 		if err := rows.Scan(&post.PostID, &post.UserID, &post.Content, &post.ImageURL, &post.Timestamp, &post.PrivacySetting, &post.AllowedViewers,
 			&post.Nickname, &post.FirstName, &post.LastName, &post.ProfilePicture); err != nil {
-			log.Printf("Error scanning group post: %v", err)
+			slog.Error("Error scanning group post", "error", err)
 			continue
 		}
 		posts = append(posts, post)
 	}
 
-	log.Println("Fetching posts for group %v", groupID)
+	decoratePosts(db, viewerID, posts)
+	slog.Debug("Fetching group posts", "group_id", groupID)
 	return posts, nil
 }
+
+// UpdatePost changes the content of a post written by the user
+func UpdatePost(db *sql.DB, postID, userID int, content string) error {
+	result, err := db.Exec(`UPDATE Post SET Content = ? WHERE PostID = ? AND UserID = ?`, content, postID, userID)
+	if err != nil {
+		slog.Error("Error updating post", "post_id", postID, "error", err)
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrPostNotFound
+	}
+
+	updateEntities(db, "post", postID, userID, postID, content)
+	return nil
+}
+
+// DeletePost removes a post written by the user together with its comments
+func DeletePost(db *sql.DB, postID, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM Post WHERE PostID = ? AND UserID = ?`, postID, userID)
+	if err != nil {
+		slog.Error("Error deleting post", "post_id", postID, "error", err)
+		tx.Rollback()
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return ErrPostNotFound
+	}
+
+	statements := []string{
+		`DELETE FROM CommentTags WHERE CommentID IN (SELECT CommentID FROM Comment WHERE PostID = ?)`,
+		`DELETE FROM CommentMentions WHERE CommentID IN (SELECT CommentID FROM Comment WHERE PostID = ?)`,
+		`DELETE FROM Comment WHERE PostID = ?`,
+		`DELETE FROM PostTags WHERE PostID = ?`,
+		`DELETE FROM PostMentions WHERE PostID = ?`,
+		`DELETE FROM Bookmarks WHERE PostID = ?`,
+		`DELETE FROM PollVotes WHERE PollID IN (SELECT PollID FROM Polls WHERE PostID = ?)`,
+		`DELETE FROM PollOptions WHERE PollID IN (SELECT PollID FROM Polls WHERE PostID = ?)`,
+		`DELETE FROM Polls WHERE PostID = ?`,
+	}
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement, postID); err != nil {
+			slog.Error("Error deleting post", "post_id", postID, "error", err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CanViewPost reports whether the viewer may see the published post under the feed's privacy and group membership rules
+func CanViewPost(db *sql.DB, viewerID, postID int) (bool, error) {
+	var visible bool
+	query := `SELECT EXISTS(SELECT 1 FROM Post p WHERE p.PostID = ? AND p.Hidden = FALSE AND p.Published = TRUE
+	AND ` + notBlockedClause("p.UserID") + `
+	AND ` + postVisibleClause("p") + `)`
+	err := db.QueryRow(query, postID, viewerID, viewerID, viewerID, viewerID, viewerID, viewerID).Scan(&visible)
+	if err != nil {
+		return false, err
+	}
+	return visible, nil
+}
@@ -2,7 +2,7 @@ package model
 
 import (
 	"database/sql"
-	"log"
+	"log/slog"
 	"time"
 )
 
@@ -13,22 +13,24 @@ type FollowingUser struct {
 	RelationType string `json:"relationType"`
 }
 
-func FetchPostsByUserID(db *sql.DB, userID int) ([]Post, error) {
-	log.Printf("Fetching posts for userID: %d", userID) // Log the start of the operation
+// FetchPostsByUserID returns the published posts of the user that the viewer may see, by the rules of the feed
+// (postVisibleClause). A viewer of 0, someone signed out, only sees public posts. Blocks are left to the caller.
+func FetchPostsByUserID(db *sql.DB, userID, viewerID int) ([]Post, error) {
 
 	query := `SELECT p.PostID, p.UserID, p.Content, p.ImageURL, p.Timestamp, p.PrivacySetting, p.AllowedViewers,
 		u.Nickname, u.FirstName, u.LastName, u.ProfilePicture
 		FROM Post p
 		JOIN User u ON p.UserID = u.UserID
-		WHERE p.UserID = ?`
+		WHERE p.UserID = ? AND p.Hidden = FALSE AND p.Published = TRUE
+		AND ` + postVisibleClause("p") + `
+		ORDER BY p.Timestamp DESC`
 
-	rows, err := db.Query(query, userID)
+	rows, err := db.Query(query, userID, viewerID, viewerID, viewerID, viewerID)
 	if err != nil {
-		log.Printf("Error executing the query for userID %d: %v", userID, err)
+		slog.Error("Error querying posts of user", "user_id", userID, "error", err)
 		return nil, err
 	}
 	defer rows.Close()
-	log.Printf("Query executed successfully for userID: %d", userID) // Log successful execution of the query
 
 	var posts []Post
 
@@ -40,7 +42,7 @@ func FetchPostsByUserID(db *sql.DB, userID int) ([]Post, error) {
 		err = rows.Scan(&post.PostID, &post.UserID, &post.Content, &post.ImageURL, &timestamp, &post.PrivacySetting, &post.AllowedViewers,
 			&post.Nickname, &post.FirstName, &post.LastName, &post.ProfilePicture)
 		if err != nil {
-			log.Printf("Error scanning row for userID %d: %v", userID, err)
+			slog.Error("Error scanning post", "user_id", userID, "error", err)
 			return nil, err
 		}
 
@@ -52,7 +54,8 @@ func FetchPostsByUserID(db *sql.DB, userID int) ([]Post, error) {
 		posts = append(posts, post)
 	}
 
-	log.Printf("Successfully fetched %d posts for userID: %d", len(posts), userID) // Log the number of posts fetched
+	decoratePosts(db, viewerID, posts)
+	slog.Debug("Fetched posts of user", "user_id", userID, "count", len(posts))
 
 	return posts, nil
 }
@@ -65,7 +68,7 @@ func FetchFollowingByUserID(db *sql.DB, userID int) ([]FollowingUser, error) {
 
 	rows, err := db.Query(query, userID)
 	if err != nil {
-		log.Printf("Error executing the query: %v", err)
+		slog.Error("Error executing the query", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -75,7 +78,7 @@ func FetchFollowingByUserID(db *sql.DB, userID int) ([]FollowingUser, error) {
 	for rows.Next() {
 		var user FollowingUser
 		if err := rows.Scan(&user.UserID, &user.FirstName, &user.LastName); err != nil {
-			log.Printf("Error scanning row: %v", err)
+			slog.Error("Error scanning row", "error", err)
 			return nil, err
 		}
 		user.RelationType = "following" // Since this query fetches whom the user is following
@@ -93,7 +96,7 @@ func FetchFollowersByUserID(db *sql.DB, userID int) ([]FollowingUser, error) {
 
 	rows, err := db.Query(query, userID)
 	if err != nil {
-		log.Printf("Error executing the query: %v", err)
+		slog.Error("Error executing the query", "error", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -103,7 +106,7 @@ func FetchFollowersByUserID(db *sql.DB, userID int) ([]FollowingUser, error) {
 	for rows.Next() {
 		var user FollowingUser
 		if err := rows.Scan(&user.UserID, &user.FirstName, &user.LastName); err != nil {
-			log.Printf("Error scanning row: %v", err)
+			slog.Error("Error scanning row", "error", err)
 			return nil, err
 		}
 		user.RelationType = "follower" // Since this query fetches who is following the user
@@ -0,0 +1,330 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// User roles
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Moderation actions that can be taken on a report
+const (
+	ActionHide    = "hide"
+	ActionSuspend = "suspend"
+	ActionDismiss = "dismiss"
+)
+
+var (
+	ErrInvalidReport   = errors.New("invalid report")
+	ErrReportNotFound  = errors.New("report not found")
+	ErrInvalidAction   = errors.New("invalid moderation action")
+	ErrTargetNotFound  = errors.New("report target not found")
+	ErrReportResolved  = errors.New("report already resolved")
+	reportReasonCodes  = map[string]bool{"spam": true, "harassment": true, "hate": true, "violence": true, "nudity": true, "self_harm": true, "misinformation": true, "impersonation": true, "other": true}
+	reportTargetTypes  = map[string]bool{"post": true, "comment": true, "message": true, "user": true}
+	moderationSetRoles = map[string]bool{RoleUser: true, RoleModerator: true, RoleAdmin: true}
+)
+
+type Report struct {
+	ReportID          int        `json:"reportId"`
+	ReporterUserID    int        `json:"reporterUserId"`
+	ReporterFirstName string     `json:"reporterFirstName"`
+	ReporterLastName  string     `json:"reporterLastName"`
+	TargetType        string     `json:"targetType"`
+	TargetID          string     `json:"targetId"`
+	ReasonCode        string     `json:"reasonCode"`
+	Details           string     `json:"details"`
+	Status            string     `json:"status"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	ResolvedByUserID  int        `json:"resolvedByUserId,omitempty"`
+	ResolvedAt        *time.Time `json:"resolvedAt,omitempty"`
+}
+
+type ModerationAction struct {
+	ActionID           int       `json:"actionId"`
+	ModeratorUserID    int       `json:"moderatorUserId"`
+	ModeratorFirstName string    `json:"moderatorFirstName"`
+	ModeratorLastName  string    `json:"moderatorLastName"`
+	ReportID           int       `json:"reportId,omitempty"`
+	Action             string    `json:"action"`
+	TargetType         string    `json:"targetType"`
+	TargetID           string    `json:"targetId"`
+	Note               string    `json:"note"`
+	CreatedAt          time.Time `json:"createdAt"`
+}
+
+func GetUserRole(db *sql.DB, userID int) (string, error) {
+	var role sql.NullString
+	err := db.QueryRow(`SELECT Role FROM User WHERE UserID = ?`, userID).Scan(&role)
+	if err != nil {
+		return "", err
+	}
+	if !role.Valid || role.String == "" {
+		return RoleUser, nil
+	}
+	return role.String, nil
+}
+
+func IsUserSuspended(db *sql.DB, userID int) (bool, error) {
+	var suspended sql.NullBool
+	err := db.QueryRow(`SELECT Suspended FROM User WHERE UserID = ?`, userID).Scan(&suspended)
+	if err != nil {
+		return false, err
+	}
+	return suspended.Valid && suspended.Bool, nil
+}
+
+func CreateReport(db *sql.DB, report Report) (*Report, error) {
+	if !reportTargetTypes[report.TargetType] || !reportReasonCodes[report.ReasonCode] || report.TargetID == "" {
+		return nil, ErrInvalidReport
+	}
+
+	if _, err := contentAuthor(db, report.TargetType, report.TargetID); err == sql.ErrNoRows {
+		return nil, ErrTargetNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Exec(`INSERT INTO Reports (ReporterUserID, TargetType, TargetID, ReasonCode, Details) VALUES (?, ?, ?, ?, ?)`,
+		report.ReporterUserID, report.TargetType, report.TargetID, report.ReasonCode, report.Details)
+	if err != nil {
+		slog.Error("Error creating report", "error", err)
+		return nil, err
+	}
+
+	reportID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	report.ReportID = int(reportID)
+	report.Status = "open"
+
+	slog.Info("Report created", "report_id", report.ReportID, "target_type", report.TargetType, "target_id", report.TargetID)
+	return &report, nil
+}
+
+// GetReports returns the reports with the given status, oldest first so the queue is worked in order
+func GetReports(db *sql.DB, status string, limit, offset int) ([]Report, error) {
+	query := `
+	SELECT r.ReportID, r.ReporterUserID, u.FirstName, u.LastName, r.TargetType, r.TargetID, r.ReasonCode, IFNULL(r.Details, ''),
+				 r.Status, r.CreatedAt, IFNULL(r.ResolvedByUserID, 0), r.ResolvedAt
+	FROM Reports r
+	JOIN User u ON r.ReporterUserID = u.UserID
+	WHERE r.Status = ?
+	ORDER BY r.CreatedAt, r.ReportID
+	LIMIT ? OFFSET ?`
+	rows, err := db.Query(query, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		var report Report
+		var resolvedAt sql.NullTime
+		if err := rows.Scan(&report.ReportID, &report.ReporterUserID, &report.ReporterFirstName, &report.ReporterLastName,
+			&report.TargetType, &report.TargetID, &report.ReasonCode, &report.Details, &report.Status, &report.CreatedAt,
+			&report.ResolvedByUserID, &resolvedAt); err != nil {
+			return nil, err
+		}
+		if resolvedAt.Valid {
+			report.ResolvedAt = &resolvedAt.Time
+		}
+		reports = append(reports, report)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+// ResolveReport applies the moderation action to the reported target, resolves every open report
+// about the same target and records the action in the audit trail.
+func ResolveReport(db *sql.DB, reportID, moderatorUserID int, action, note string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var targetType, targetID, status string
+	err = tx.QueryRow(`SELECT TargetType, TargetID, Status FROM Reports WHERE ReportID = ?`, reportID).Scan(&targetType, &targetID, &status)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return ErrReportNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if status != "open" {
+		tx.Rollback()
+		return ErrReportResolved
+	}
+
+	newStatus := "actioned"
+	switch action {
+	case ActionHide:
+		err = hideContent(tx, targetType, targetID)
+	case ActionSuspend:
+		var authorID int
+		authorID, err = contentAuthor(tx, targetType, targetID)
+		if err == nil {
+			err = suspendUser(tx, authorID)
+		}
+	case ActionDismiss:
+		newStatus = "dismissed"
+	default:
+		err = ErrInvalidAction
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE Reports SET Status = ?, ResolvedByUserID = ?, ResolvedAt = CURRENT_TIMESTAMP
+	WHERE Status = 'open' AND (ReportID = ? OR (TargetType = ? AND TargetID = ?))`,
+		newStatus, moderatorUserID, reportID, targetType, targetID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO ModerationActions (ModeratorUserID, ReportID, Action, TargetType, TargetID, Note) VALUES (?, ?, ?, ?, ?, ?)`,
+		moderatorUserID, reportID, action, targetType, targetID, note)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	slog.Info("Moderation action applied", "moderator_id", moderatorUserID, "action", action, "report_id", reportID)
+	return tx.Commit()
+}
+
+// IsRole tells whether role is one SetUserRole takes
+func IsRole(role string) bool {
+	return moderationSetRoles[role]
+}
+
+// SetUserRole changes the role of a user and records the change in the audit trail
+func SetUserRole(db *sql.DB, adminUserID, userID int, role string) error {
+	if !moderationSetRoles[role] {
+		return ErrInvalidAction
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`UPDATE User SET Role = ? WHERE UserID = ?`, role, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return ErrTargetNotFound
+	}
+
+	_, err = tx.Exec(`INSERT INTO ModerationActions (ModeratorUserID, Action, TargetType, TargetID, Note) VALUES (?, 'setRole', 'user', ?, ?)`,
+		adminUserID, strconv.Itoa(userID), role)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func GetModerationActions(db *sql.DB, limit, offset int) ([]ModerationAction, error) {
+	query := `
+	SELECT a.ActionID, a.ModeratorUserID, CASE WHEN a.ModeratorUserID = ? THEN 'Console' ELSE IFNULL(u.FirstName, 'Deleted user') END,
+				 IFNULL(u.LastName, ''), IFNULL(a.ReportID, 0), a.Action, a.TargetType, a.TargetID, IFNULL(a.Note, ''), a.CreatedAt
+	FROM ModerationActions a
+	LEFT JOIN User u ON a.ModeratorUserID = u.UserID
+	ORDER BY a.CreatedAt DESC, a.ActionID DESC
+	LIMIT ? OFFSET ?`
+	rows, err := db.Query(query, ConsoleUserID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []ModerationAction{}
+	for rows.Next() {
+		var action ModerationAction
+		if err := rows.Scan(&action.ActionID, &action.ModeratorUserID, &action.ModeratorFirstName, &action.ModeratorLastName,
+			&action.ReportID, &action.Action, &action.TargetType, &action.TargetID, &action.Note, &action.CreatedAt); err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
+
+// rowQuerier is a datab or a transaction
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// contentAuthor returns the user responsible for the reported target
+func contentAuthor(db rowQuerier, targetType, targetID string) (int, error) {
+	var authorID int
+	var err error
+	switch targetType {
+	case "post":
+		err = db.QueryRow(`SELECT UserID FROM Post WHERE PostID = ?`, targetID).Scan(&authorID)
+	case "comment":
+		err = db.QueryRow(`SELECT UserID FROM Comment WHERE CommentID = ?`, targetID).Scan(&authorID)
+	case "message":
+		err = db.QueryRow(`SELECT SenderUserID FROM Message WHERE MessageID = ?
+		UNION ALL SELECT SenderUserID FROM GroupChatMessage WHERE MessageID = ?`, targetID, targetID).Scan(&authorID)
+	case "user":
+		err = db.QueryRow(`SELECT UserID FROM User WHERE UserID = ?`, targetID).Scan(&authorID)
+	default:
+		err = ErrInvalidReport
+	}
+	return authorID, err
+}
+
+func hideContent(tx *sql.Tx, targetType, targetID string) error {
+	var err error
+	switch targetType {
+	case "post":
+		_, err = tx.Exec(`UPDATE Post SET Hidden = TRUE WHERE PostID = ?`, targetID)
+	case "comment":
+		_, err = tx.Exec(`UPDATE Comment SET Hidden = TRUE WHERE CommentID = ?`, targetID)
+	case "message":
+		if _, err = tx.Exec(`UPDATE Message SET Hidden = TRUE WHERE MessageID = ?`, targetID); err == nil {
+			_, err = tx.Exec(`UPDATE GroupChatMessage SET Hidden = TRUE WHERE MessageID = ?`, targetID)
+		}
+	default:
+		// Profiles can't be hidden, only their owner suspended
+		err = ErrInvalidAction
+	}
+	return err
+}
+
+// suspendUser suspends the account and ends all of its sessions
+func suspendUser(tx *sql.Tx, userID int) error {
+	if _, err := tx.Exec(`UPDATE User SET Suspended = TRUE WHERE UserID = ?`, userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM Sessions WHERE UserID = ?`, userID)
+	return err
+}
@@ -0,0 +1,161 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+)
+
+// ErrCannotRepost is returned when the original post isn't public, so its audience can't be widened by a repost
+var ErrCannotRepost = errors.New("post can't be reposted")
+
+// CreateRepost shares a post with the user's own audience, with optional quote text as the content.
+// Reposting a repost shares its original. Only public posts outside groups can be reposted.
+func CreateRepost(db *sql.DB, repost Post) (*Post, error) {
+	var authorID, repostOf int
+	var groupID sql.NullInt64
+	var privacy string
+	err := db.QueryRow(`SELECT UserID, GroupID, PrivacySetting, IFNULL(RepostOfPostID, 0) FROM Post WHERE PostID = ? AND Hidden = FALSE AND Published = TRUE`,
+		repost.RepostOfPostID).Scan(&authorID, &groupID, &privacy, &repostOf)
+	if err == sql.ErrNoRows {
+		return nil, ErrPostNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	// A plain repost has nothing of its own to share, so share what it points at
+	if repostOf != 0 {
+		var content string
+		if err := db.QueryRow(`SELECT Content FROM Post WHERE PostID = ?`, repost.RepostOfPostID).Scan(&content); err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(content) == "" {
+			repost.RepostOfPostID = repostOf
+			return CreateRepost(db, repost)
+		}
+	}
+
+	visible, err := CanViewPost(db, repost.UserID, repost.RepostOfPostID)
+	if err != nil {
+		return nil, err
+	}
+	if !visible {
+		return nil, ErrPostNotFound
+	}
+	if groupID.Valid || privacy != "public" {
+		return nil, ErrCannotRepost
+	}
+
+	return CreatePost(db, repost)
+}
+
+// decoratePosts fills in what the feed shows alongside the posts: entities, share counts, embedded originals and polls
+func decoratePosts(db *sql.DB, viewerID int, posts []Post) {
+	attachPostEntities(db, posts)
+	attachReposts(db, viewerID, posts)
+	attachPolls(db, viewerID, posts)
+}
+
+// attachReposts fills in the share counts of the posts and embeds the originals of reposts the viewer may still see
+func attachReposts(db *sql.DB, viewerID int, posts []Post) {
+	if len(posts) == 0 {
+		return
+	}
+
+	ids := make([]int, len(posts))
+	for i, post := range posts {
+		ids[i] = post.PostID
+	}
+	placeholders, args := inClause(ids)
+
+	// Reading the references here keeps every post query free of the repost columns
+	repostOf := map[int]int{}
+	shareCounts := map[int]int{}
+	rows, err := db.Query(`SELECT p.PostID, IFNULL(p.RepostOfPostID, 0),
+	(SELECT COUNT(*) FROM Post r WHERE r.RepostOfPostID = p.PostID AND r.Hidden = FALSE AND r.Published = TRUE)
+	FROM Post p WHERE p.PostID IN (`+placeholders+`)`, args...)
+	if err != nil {
+		slog.Error("Error fetching reposts", "error", err)
+		return
+	}
+	for rows.Next() {
+		var postID, originalID, shareCount int
+		if err := rows.Scan(&postID, &originalID, &shareCount); err != nil {
+			slog.Error("Error scanning reposts", "error", err)
+			rows.Close()
+			return
+		}
+		repostOf[postID] = originalID
+		shareCounts[postID] = shareCount
+	}
+	rows.Close()
+
+	var originalIDs []int
+	for _, originalID := range repostOf {
+		if originalID != 0 {
+			originalIDs = append(originalIDs, originalID)
+		}
+	}
+
+	originals := map[int]*Post{}
+	if len(originalIDs) > 0 {
+		placeholders, args := inClause(originalIDs)
+		query := `SELECT p.PostID, p.UserID, p.Content, p.ImageURL, p.Timestamp, p.PrivacySetting, p.AllowedViewers, p.GroupID,
+		u.Nickname, u.FirstName, u.LastName, u.ProfilePicture,
+		(SELECT COUNT(*) FROM Post r WHERE r.RepostOfPostID = p.PostID AND r.Hidden = FALSE AND r.Published = TRUE)
+		FROM Post p
+		JOIN User u ON p.UserID = u.UserID
+		WHERE p.PostID IN (` + placeholders + `) AND p.Hidden = FALSE AND p.Published = TRUE
+		AND ` + notBlockedClause("p.UserID") + `
+		AND ` + postVisibleClause("p")
+		args = append(args, viewerID, viewerID, viewerID, viewerID, viewerID, viewerID)
+
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			slog.Error("Error fetching repost originals", "error", err)
+			return
+		}
+		var found []Post
+		for rows.Next() {
+			var original Post
+			if err := rows.Scan(&original.PostID, &original.UserID, &original.Content, &original.ImageURL, &original.Timestamp,
+				&original.PrivacySetting, &original.AllowedViewers, &original.GroupID,
+				&original.Nickname, &original.FirstName, &original.LastName, &original.ProfilePicture, &original.ShareCount); err != nil {
+				slog.Error("Error scanning repost original", "error", err)
+				rows.Close()
+				return
+			}
+			found = append(found, original)
+		}
+		rows.Close()
+
+		attachPostEntities(db, found)
+		for i := range found {
+			originals[found[i].PostID] = &found[i]
+		}
+	}
+
+	for i := range posts {
+		posts[i].ShareCount = shareCounts[posts[i].PostID]
+		originalID := repostOf[posts[i].PostID]
+		if originalID == 0 {
+			continue
+		}
+		posts[i].RepostOfPostID = originalID
+		if original, ok := originals[originalID]; ok {
+			posts[i].RepostOf = original
+		} else {
+			posts[i].OriginalUnavailable = true
+		}
+	}
+}
+
+// inClause returns the placeholders and arguments for an IN (...) list of IDs
+func inClause(ids []int) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", "), args
+}
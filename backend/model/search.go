@@ -0,0 +1,438 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"html"
+	"log/slog"
+	"social-network/backend/datab"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Content types that can be searched
+const (
+	SearchTypePost    = "post"
+	SearchTypeComment = "comment"
+	SearchTypeGroup   = "group"
+)
+
+// ErrInvalidCursor is returned when a search cursor can't be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Markers put around matches by snippet(), replaced by <mark> tags once the snippet is HTML-escaped
+const (
+	snippetOpen  = "\x01"
+	snippetClose = "\x02"
+)
+
+type UserSearchResult struct {
+	UserID         int    `json:"userID"`
+	FirstName      string `json:"firstName"`
+	LastName       string `json:"lastName"`
+	Nickname       string `json:"nickname,omitempty"`
+	ProfilePicture string `json:"profilePicture,omitempty"`
+	AboutMe        string `json:"aboutMe,omitempty"`
+	ProfilePrivacy string `json:"profilePrivacy"`
+	IsFollowing    bool   `json:"isFollowing"`
+	SharesGroup    bool   `json:"sharesGroup"`
+}
+
+// searchWords splits free text into the words to search for. Anything that isn't a letter or digit is dropped so
+// user input can't inject FTS5 syntax or LIKE wildcards.
+func searchWords(text string) []string {
+	var words []string
+	for _, word := range strings.Fields(text) {
+		word = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, word)
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// ftsQuery turns free text into an FTS5 query where every word must match as a prefix.
+// An empty string is returned when nothing searchable is left.
+func ftsQuery(text string) string {
+	var terms []string
+	for _, word := range searchWords(text) {
+		terms = append(terms, `"`+word+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// likeClause returns a condition, and its arguments, where every word appears in one of the columns. It stands in
+// for an FTS5 match when the binary is built without FTS5.
+func likeClause(words []string, columns ...string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	for _, word := range words {
+		var matches []string
+		for _, column := range columns {
+			matches = append(matches, `IFNULL(`+column+`, '') LIKE ?`)
+			args = append(args, "%"+word+"%")
+		}
+		conditions = append(conditions, "("+strings.Join(matches, " OR ")+")")
+	}
+	return "(" + strings.Join(conditions, " AND ") + ")", args
+}
+
+// hidePrivateProfile keeps only what identifies the user, their names, when the result is a private profile the
+// viewer doesn't follow
+func hidePrivateProfile(result *UserSearchResult, viewerID int) {
+	if result.ProfilePrivacy == "Private" && !result.IsFollowing && result.UserID != viewerID {
+		result.AboutMe = ""
+		result.ProfilePicture = ""
+	}
+}
+
+// SearchUsers ranks users matching the text by nickname, name and about-me. Users the viewer follows
+// and members of groups the viewer belongs to are boosted. About-me of a private profile is only searched, and
+// it and the picture only returned, when the viewer follows its owner.
+func SearchUsers(db *sql.DB, viewerID int, text string, limit, offset int) ([]UserSearchResult, error) {
+	results := []UserSearchResult{}
+
+	words := searchWords(text)
+	if len(words) == 0 {
+		return results, nil
+	}
+	fts5, err := datab.HasFTS5(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Private profiles not followed by the viewer may only match on their names
+	var from, match, nameMatch, order string
+	var matchArgs, nameMatchArgs []interface{}
+	if fts5 {
+		from = `UserSearch
+	JOIN User u ON u.UserID = UserSearch.rowid`
+		match, matchArgs = `UserSearch MATCH ?`, []interface{}{ftsQuery(text)}
+		nameMatch = `u.UserID IN (SELECT rowid FROM UserSearch WHERE UserSearch MATCH ?)`
+		nameMatchArgs = []interface{}{"{Nickname FirstName LastName} : (" + ftsQuery(text) + ")"}
+		// bm25 scores are negative, so multiplying by the boost moves related users up
+		order = `bm25(UserSearch, 10.0, 5.0, 5.0, 1.0) * (1 + following + 0.5 * sharesGroup), u.UserID`
+	} else {
+		from = `User u`
+		match, matchArgs = likeClause(words, "u.Nickname", "u.FirstName", "u.LastName", "u.AboutMe")
+		nameMatch, nameMatchArgs = likeClause(words, "u.Nickname", "u.FirstName", "u.LastName")
+		order = `following + 0.5 * sharesGroup DESC, u.UserID`
+	}
+
+	query := `
+	SELECT u.UserID, u.FirstName, u.LastName, IFNULL(u.Nickname, ''), IFNULL(u.ProfilePicture, ''), IFNULL(u.AboutMe, ''),
+				 IFNULL(u.ProfilePrivacy, ''),
+				 EXISTS(SELECT 1 FROM UserFollowers WHERE FollowerUserID = ? AND FollowingUserID = u.UserID) AS following,
+				 EXISTS(SELECT 1 FROM GroupMembers mine
+					 JOIN GroupMembers theirs ON theirs.GroupID = mine.GroupID
+					 WHERE mine.UserID = ? AND mine.Accepted = TRUE AND theirs.UserID = u.UserID AND theirs.Accepted = TRUE) AS sharesGroup
+	FROM ` + from + `
+	WHERE ` + match + `
+	AND IFNULL(u.Suspended, FALSE) = FALSE
+	AND ` + notBlockedClause("u.UserID") + `
+	AND (u.ProfilePrivacy != 'Private' OR u.UserID = ? OR following OR ` + nameMatch + `)
+	ORDER BY ` + order + `
+	LIMIT ? OFFSET ?`
+
+	args := append([]interface{}{viewerID, viewerID}, matchArgs...)
+	args = append(args, viewerID, viewerID, viewerID)
+	args = append(args, nameMatchArgs...)
+	args = append(args, limit, offset)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		slog.Error("Error searching users", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result UserSearchResult
+		if err := rows.Scan(&result.UserID, &result.FirstName, &result.LastName, &result.Nickname, &result.ProfilePicture,
+			&result.AboutMe, &result.ProfilePrivacy, &result.IsFollowing, &result.SharesGroup); err != nil {
+			slog.Error("Error scanning user search result", "error", err)
+			return nil, err
+		}
+		hidePrivateProfile(&result, viewerID)
+		results = append(results, result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+type ContentSearchResult struct {
+	Type           string     `json:"type"`
+	ID             int        `json:"id"`
+	PostID         int        `json:"postId,omitempty"`
+	GroupID        int        `json:"groupId,omitempty"`
+	Title          string     `json:"title,omitempty"`
+	Snippet        string     `json:"snippet"`
+	UserID         int        `json:"userID"`
+	FirstName      string     `json:"firstName"`
+	LastName       string     `json:"lastName"`
+	ProfilePicture string     `json:"profilePicture,omitempty"`
+	Timestamp      *time.Time `json:"timestamp,omitempty"`
+	rank           float64
+}
+
+// searchCursor is the position after the last result of a page, in ranking order
+type searchCursor struct {
+	rank      float64
+	queryType string
+	id        int
+}
+
+func encodeSearchCursor(result ContentSearchResult) string {
+	raw := strconv.FormatFloat(result.rank, 'g', -1, 64) + "|" + result.Type + "|" + strconv.Itoa(result.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeSearchCursor(cursor string) (*searchCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 {
+		return nil, ErrInvalidCursor
+	}
+	rank, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &searchCursor{rank: rank, queryType: parts[1], id: id}, nil
+}
+
+// highlightSnippet HTML-escapes a snippet and turns the match markers into <mark> tags
+func highlightSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	return strings.NewReplacer(snippetOpen, "<mark>", snippetClose, "</mark>").Replace(escaped)
+}
+
+// markWords cuts a snippet of about 100 characters around the first of the words in the text and puts the match
+// markers around the words in it, like snippet() does with FTS5
+func markWords(text string, words []string) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		// Lowercasing changed the length, so positions can't be shared; fall back to matching the text as it is
+		lower = runes
+	}
+	lowerWords := make([][]rune, len(words))
+	for i, word := range words {
+		lowerWords[i] = []rune(strings.ToLower(word))
+	}
+	matchAt := func(i int) int {
+		for _, word := range lowerWords {
+			if i+len(word) <= len(lower) && string(lower[i:i+len(word)]) == string(word) {
+				return len(word)
+			}
+		}
+		return 0
+	}
+
+	first := 0
+	for i := range lower {
+		if matchAt(i) > 0 {
+			first = i
+			break
+		}
+	}
+	start := first - 30
+	if start < 0 {
+		start = 0
+	}
+	end := start + 100
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	var snippet strings.Builder
+	if start > 0 {
+		snippet.WriteString("...")
+	}
+	for i := start; i < end; {
+		if n := matchAt(i); n > 0 {
+			snippet.WriteString(snippetOpen + string(runes[i:i+n]) + snippetClose)
+			i += n
+			continue
+		}
+		snippet.WriteRune(runes[i])
+		i++
+	}
+	if end < len(runes) {
+		snippet.WriteString("...")
+	}
+	return snippet.String()
+}
+
+// SearchContent searches posts, comments and groups of the given types, best matches first. Posts and comments
+// follow the same privacy and group membership rules as the feed. The returned cursor continues after the last
+// result and is empty when there are no more results.
+func SearchContent(db *sql.DB, viewerID int, text string, types []string, limit int, cursor string) ([]ContentSearchResult, string, error) {
+	results := []ContentSearchResult{}
+
+	words := searchWords(text)
+	if len(words) == 0 {
+		return results, "", nil
+	}
+	fts5, err := datab.HasFTS5(db)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var after *searchCursor
+	if cursor != "" {
+		if after, err = decodeSearchCursor(cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Each type is matched through its FTS5 index, or without FTS5 by plain matching, unranked, on the text
+	// columns, whose text then comes back to cut the snippet from
+	match := func(index string, columns ...string) (string, []interface{}) {
+		if fts5 {
+			return index + ` MATCH ?`, []interface{}{ftsQuery(text)}
+		}
+		return likeClause(words, columns...)
+	}
+	source := func(index, table, key string) string {
+		if fts5 {
+			return index + `
+			JOIN ` + table + ` ON ` + key + ` = ` + index + `.rowid`
+		}
+		return table
+	}
+	snippet := func(index string, column int, text string) string {
+		if fts5 {
+			return `snippet(` + index + `, ` + strconv.Itoa(column) + `, '` + snippetOpen + `', '` + snippetClose + `', '...', 16)`
+		}
+		return text
+	}
+	rank := func(bm25 string) string {
+		if fts5 {
+			return bm25
+		}
+		return `0`
+	}
+
+	var branches []string
+	var args []interface{}
+	for _, searchType := range types {
+		switch searchType {
+		case SearchTypePost:
+			condition, matchArgs := match("PostSearch", "p.Content")
+			branches = append(branches, `
+			SELECT 'post' AS Type, p.PostID AS ID, p.PostID AS PostID, IFNULL(p.GroupID, 0) AS GroupID, '' AS Title,
+						 `+snippet("PostSearch", 0, "p.Content")+` AS Snippet,
+						 u.UserID, u.FirstName, u.LastName, IFNULL(u.ProfilePicture, '') AS ProfilePicture, strftime('%Y-%m-%dT%H:%M:%SZ', p.Timestamp) AS Timestamp,
+						 `+rank("bm25(PostSearch)")+` AS Rank
+			FROM `+source("PostSearch", "Post p", "p.PostID")+`
+			JOIN User u ON p.UserID = u.UserID
+			WHERE `+condition+` AND p.Hidden = FALSE AND p.Published = TRUE
+			AND `+notBlockedClause("p.UserID")+`
+			AND `+postVisibleClause("p"))
+			args = append(args, matchArgs...)
+			args = append(args, viewerID, viewerID, viewerID, viewerID, viewerID, viewerID)
+		case SearchTypeComment:
+			condition, matchArgs := match("CommentSearch", "c.Content")
+			branches = append(branches, `
+			SELECT 'comment', c.CommentID, c.PostID, IFNULL(p.GroupID, 0), '',
+						 `+snippet("CommentSearch", 0, "c.Content")+`,
+						 u.UserID, u.FirstName, u.LastName, IFNULL(u.ProfilePicture, ''), strftime('%Y-%m-%dT%H:%M:%SZ', c.Timestamp),
+						 `+rank("bm25(CommentSearch)")+`
+			FROM `+source("CommentSearch", "Comment c", "c.CommentID")+`
+			JOIN Post p ON c.PostID = p.PostID
+			JOIN User u ON c.UserID = u.UserID
+			WHERE `+condition+` AND c.Hidden = FALSE AND p.Hidden = FALSE AND p.Published = TRUE
+			AND `+notBlockedClause("c.UserID")+`
+			AND `+notBlockedClause("p.UserID")+`
+			AND `+postVisibleClause("p"))
+			args = append(args, matchArgs...)
+			args = append(args, viewerID, viewerID, viewerID, viewerID, viewerID, viewerID, viewerID, viewerID)
+		case SearchTypeGroup:
+			// Groups are listed to everyone, only their posts are restricted to members
+			condition, matchArgs := match("GroupSearch", "g.Name", "g.Description")
+			branches = append(branches, `
+			SELECT 'group', g.GroupID, 0, g.GroupID, g.Name,
+						 `+snippet("GroupSearch", -1, "IFNULL(g.Description, '')")+`,
+						 u.UserID, u.FirstName, u.LastName, IFNULL(u.ProfilePicture, ''), NULL,
+						 `+rank("bm25(GroupSearch, 5.0, 1.0)")+`
+			FROM `+source("GroupSearch", "Cluster g", "g.GroupID")+`
+			JOIN User u ON g.CreatorUserID = u.UserID
+			WHERE `+condition)
+			args = append(args, matchArgs...)
+		}
+	}
+	if len(branches) == 0 {
+		return results, "", nil
+	}
+
+	query := `SELECT Type, ID, PostID, GroupID, Title, Snippet, UserID, FirstName, LastName, ProfilePicture, Timestamp, Rank
+	FROM (` + strings.Join(branches, "\n\t\t\tUNION ALL") + `)`
+	if after != nil {
+		query += `
+	WHERE (Rank, Type, ID) > (?, ?, ?)`
+		args = append(args, after.rank, after.queryType, after.id)
+	}
+	// One extra row tells whether there is a next page
+	query += `
+	ORDER BY Rank, Type, ID
+	LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		slog.Error("Error searching content", "error", err)
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result ContentSearchResult
+		var timestamp sql.NullString
+		if err := rows.Scan(&result.Type, &result.ID, &result.PostID, &result.GroupID, &result.Title, &result.Snippet,
+			&result.UserID, &result.FirstName, &result.LastName, &result.ProfilePicture, &timestamp, &result.rank); err != nil {
+			slog.Error("Error scanning content search result", "error", err)
+			return nil, "", err
+		}
+		if !fts5 {
+			result.Snippet = markWords(result.Snippet, words)
+		}
+		result.Snippet = highlightSnippet(result.Snippet)
+		// Column types are lost in the union, so timestamps come back as text
+		if timestamp.Valid {
+			if parsed, err := time.Parse(time.RFC3339, timestamp.String); err == nil {
+				result.Timestamp = &parsed
+			}
+		}
+		results = append(results, result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if len(results) > limit {
+		results = results[:limit]
+		nextCursor = encodeSearchCursor(results[limit-1])
+	}
+
+	return results, nextCursor, nil
+}
@@ -1,11 +1,14 @@
 package model
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
-	"log"
+	"log/slog"
 	"time"
+
+	"social-network/backend/logging"
 )
 
 // GenerateSessionID generates a random session ID
@@ -13,37 +16,37 @@ func GenerateSessionID() (string, error) {
 	bytes := make([]byte, 16)
 	_, err := rand.Read(bytes)
 	if err != nil {
-		log.Printf("Error generating session ID: %v", err)
+		slog.Error("Error generating session ID", "error", err)
 		return "", err
 	}
 	return hex.EncodeToString(bytes), nil
 }
 
 // CreateSession inserts a new session into the datab or updates the existing one
-func CreateSession(db *sql.DB, sessionID string, userID int, expiration time.Time) error {
+func CreateSession(ctx context.Context, db *sql.DB, sessionID string, userID int, expiration time.Time) error {
 	// Check if a session already exists for the user
 	var existingSessionID string
-	err := db.QueryRow("SELECT SessionID FROM Sessions WHERE UserID = ?", userID).Scan(&existingSessionID)
+	err := db.QueryRowContext(ctx, "SELECT SessionID FROM Sessions WHERE UserID = ?", userID).Scan(&existingSessionID)
 
 	if err == sql.ErrNoRows {
 		// No existing session, create a new one
-		_, err = db.Exec("INSERT INTO Sessions (UserID, SessionID, ExpiresAt) VALUES (?, ?, ?)", userID, sessionID, expiration)
+		_, err = db.ExecContext(ctx, "INSERT INTO Sessions (UserID, SessionID, ExpiresAt) VALUES (?, ?, ?)", userID, sessionID, expiration)
 		if err != nil {
-			log.Printf("Error creating new session: %v", err)
+			slog.ErrorContext(ctx, "Error creating new session", "user_id", userID, "error", err)
 			return err
 		}
-		log.Println("New session created successfully")
+		slog.DebugContext(ctx, "New session created", "user_id", userID, "fingerprint", logging.Fingerprint(sessionID))
 	} else if err == nil {
 		// Existing session found, update it
-		_, err = db.Exec("UPDATE Sessions SET SessionID = ?, ExpiresAt = ? WHERE UserID = ?", sessionID, expiration, userID)
+		_, err = db.ExecContext(ctx, "UPDATE Sessions SET SessionID = ?, ExpiresAt = ? WHERE UserID = ?", sessionID, expiration, userID)
 		if err != nil {
-			log.Printf("Error updating existing session: %v", err)
+			slog.ErrorContext(ctx, "Error updating existing session", "user_id", userID, "error", err)
 			return err
 		}
-		log.Println("Existing session updated successfully")
+		slog.DebugContext(ctx, "Existing session replaced", "user_id", userID, "fingerprint", logging.Fingerprint(sessionID))
 	} else {
 		// Some other error occurred
-		log.Printf("Error checking for existing session: %v", err)
+		slog.ErrorContext(ctx, "Error checking for existing session", "user_id", userID, "error", err)
 		return err
 	}
 
@@ -51,11 +54,11 @@ func CreateSession(db *sql.DB, sessionID string, userID int, expiration time.Tim
 }
 
 // ValidateSession checks if a session is valid and not expired
-func ValidateSession(db *sql.DB, sessionID string) (bool, error) {
+func ValidateSession(ctx context.Context, db *sql.DB, sessionID string) (bool, error) {
 	var expiresAt time.Time
 
-	err := db.QueryRow("SELECT ExpiresAt FROM Sessions WHERE SessionID = ?", sessionID).Scan(&expiresAt)
-	log.Printf("Validating session with ID: %s, expires at: %v", sessionID, expiresAt) // Moved after DB read
+	err := db.QueryRowContext(ctx, "SELECT ExpiresAt FROM Sessions WHERE SessionID = ?", sessionID).Scan(&expiresAt)
+	slog.DebugContext(ctx, "Validating session", "fingerprint", logging.Fingerprint(sessionID), "expires_at", expiresAt)
 
 	if err != nil {
 		return false, err
@@ -67,20 +70,20 @@ func ValidateSession(db *sql.DB, sessionID string) (bool, error) {
 	return true, nil // Session is valid
 }
 
-// ExtendSessionExpiry updates the expiry time of a session
-func ExtendSessionExpiry(db *sql.DB, sessionID string) error {
+// ExtendSessionExpiry updates the expiry time of a session to ttl from now
+func ExtendSessionExpiry(ctx context.Context, db *sql.DB, sessionID string, ttl time.Duration) error {
 	var expiresAt time.Time
 
 	// Reading old expiry time for logging
-	err := db.QueryRow("SELECT ExpiresAt FROM Sessions WHERE SessionID = ?", sessionID).Scan(&expiresAt)
+	err := db.QueryRowContext(ctx, "SELECT ExpiresAt FROM Sessions WHERE SessionID = ?", sessionID).Scan(&expiresAt)
 	if err != nil {
 		return err
 	}
 
-	newExpiresAt := time.Now().Add(45 * time.Minute)
-	log.Printf("Old expiry time: %v, New expiry time: %v", expiresAt, newExpiresAt)
+	newExpiresAt := time.Now().Add(ttl)
+	slog.DebugContext(ctx, "Extending session", "fingerprint", logging.Fingerprint(sessionID), "old_expiry", expiresAt, "new_expiry", newExpiresAt)
 
-	_, err = db.Exec("UPDATE Sessions SET ExpiresAt = ? WHERE SessionID = ?", newExpiresAt, sessionID)
+	_, err = db.ExecContext(ctx, "UPDATE Sessions SET ExpiresAt = ? WHERE SessionID = ?", newExpiresAt, sessionID)
 	return err
 }
 
@@ -90,28 +93,37 @@ func DeleteSession(db *sql.DB, sessionID string) error {
 	return err
 }
 
-// CleanExpiredSessions periodically cleans up expired sessions in the main.go with a go routine
-func CleanExpiredSessions(db *sql.DB) {
+// CleanExpiredSessions periodically cleans up expired sessions in the main.go with a go routine, every interval,
+// until ctx is done
+func CleanExpiredSessions(ctx context.Context, db *sql.DB, interval time.Duration) {
 	// Immediately perform cleanup before starting the ticker
 	cleanupExpiredSessions(db)
+	cleanupPasswordResets(db)
+	cleanupEmailVerifications(db)
+	cleanupLoginChallenges(db)
 
-	ticker := time.NewTicker(10 * time.Minute)
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
 			cleanupExpiredSessions(db)
+			cleanupPasswordResets(db)
+			cleanupEmailVerifications(db)
+			cleanupLoginChallenges(db)
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
 func cleanupExpiredSessions(db *sql.DB) {
-	log.Println("Checking for expired sessions...")
+	slog.Debug("Checking for expired sessions")
 
 	rows, err := db.Query("SELECT SessionID FROM Sessions WHERE ExpiresAt < ?", time.Now())
 	if err != nil {
-		log.Printf("Error fetching expired sessions: %v", err)
+		slog.Error("Error fetching expired sessions", "error", err)
 		return
 	}
 	defer rows.Close()
@@ -120,25 +132,30 @@ func cleanupExpiredSessions(db *sql.DB) {
 	for rows.Next() {
 		var sessionID string
 		if err := rows.Scan(&sessionID); err != nil {
-			log.Printf("Error scanning session ID: %v", err)
+			slog.Error("Error scanning session ID", "error", err)
 			continue
 		}
 		sessionIDs = append(sessionIDs, sessionID)
 	}
 
-	if len(sessionIDs) == 0 {
-		log.Println("No expired sessions found.")
-	} else {
-		log.Printf("Found %d expired sessions. Deleting...", len(sessionIDs))
-	}
-
+	deleted := 0
 	for _, id := range sessionIDs {
 		if err := DeleteSession(db, id); err != nil {
-			log.Printf("Error deleting session %s: %v", id, err)
+			slog.Error("Error deleting expired session", "fingerprint", logging.Fingerprint(id), "error", err)
 		} else {
-			log.Printf("Successfully deleted expired session: %s", id)
+			deleted++
 		}
 	}
+	if len(sessionIDs) > 0 {
+		slog.Info("Deleted expired sessions", "count", deleted)
+	}
+}
+
+// CountActiveSessions returns the number of sessions that haven't expired
+func CountActiveSessions(ctx context.Context, db *sql.DB) (int, error) {
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM Sessions WHERE ExpiresAt >= ?", time.Now()).Scan(&count)
+	return count, err
 }
 
 func GetUserIDBySessionID(db *sql.DB, sessionID string) (int, error) {
@@ -0,0 +1,296 @@
+package model
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"social-network/backend/totp"
+)
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled = errors.New("two-factor authentication is not being set up")
+	ErrInvalidCode          = errors.New("invalid code")
+	ErrInvalidChallenge     = errors.New("login expired, sign in again")
+)
+
+const (
+	twoFactorIssuer = "Social Network"
+	// loginChallengeTTL and loginChallengeAttempts limit how long and how often the second login step can be tried
+	loginChallengeTTL      = 5 * time.Minute
+	loginChallengeAttempts = 5
+	recoveryCodeCount      = 10
+)
+
+// The functions here take the current time from the caller, so they can be run against a fixed clock
+
+type TwoFactorEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
+
+// GetTwoFactorStatus returns whether the user has two-factor authentication on and how many recovery codes are unused
+func GetTwoFactorStatus(db *sql.DB, userID int) (*TwoFactorStatus, error) {
+	var status TwoFactorStatus
+	err := db.QueryRow(`SELECT TOTPEnabled,
+	(SELECT COUNT(*) FROM RecoveryCodes WHERE UserID = User.UserID AND UsedAt IS NULL)
+	FROM User WHERE UserID = ?`, userID).Scan(&status.Enabled, &status.RecoveryCodesLeft)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// EnrollTwoFactor gives the user a new secret to add to their authenticator app. It isn't asked for at login
+// until ConfirmTwoFactor checked a first code.
+func EnrollTwoFactor(db *sql.DB, userID int) (*TwoFactorEnrollment, error) {
+	var email string
+	var enabled bool
+	err := db.QueryRow(`SELECT Email, TOTPEnabled FROM User WHERE UserID = ?`, userID).Scan(&email, &enabled)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`UPDATE User SET TOTPSecret = ?, TOTPLastStep = 0 WHERE UserID = ? AND TOTPEnabled = FALSE`, secret, userID); err != nil {
+		slog.Error("Error saving two-factor secret", "user_id", userID, "error", err)
+		return nil, err
+	}
+
+	return &TwoFactorEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(secret, twoFactorIssuer, email),
+	}, nil
+}
+
+// ConfirmTwoFactor turns two-factor authentication on when the code matches the enrolled secret and returns
+// the recovery codes, which are only ever shown this once
+func ConfirmTwoFactor(db *sql.DB, userID int, code string, now time.Time) ([]string, error) {
+	var secret sql.NullString
+	var enabled bool
+	err := db.QueryRow(`SELECT TOTPSecret, TOTPEnabled FROM User WHERE UserID = ?`, userID).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if !secret.Valid {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	step, ok := totp.Validate(secret.String, code, now)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = tx.Exec(`UPDATE User SET TOTPEnabled = TRUE, TOTPLastStep = ? WHERE UserID = ?`, step, userID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// replaceRecoveryCodes generates a new set of recovery codes for the user, dropping the old ones
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM RecoveryCodes WHERE UserID = ?`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		bytes := make([]byte, 7)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(bytes))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		if _, err := tx.Exec(`INSERT INTO RecoveryCodes (UserID, CodeHash) VALUES (?, ?)`, userID, hashToken(code)); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor turns two-factor authentication off, or cancels an enrollment, after checking the password
+func DisableTwoFactor(db *sql.DB, userID int, password string) error {
+	var passwordHash string
+	err := db.QueryRow(`SELECT PasswordHash FROM User WHERE UserID = ?`, userID).Scan(&passwordHash)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+		return ErrWrongPassword
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	statements := []string{
+		`UPDATE User SET TOTPSecret = NULL, TOTPEnabled = FALSE, TOTPLastStep = 0 WHERE UserID = ?`,
+		`DELETE FROM RecoveryCodes WHERE UserID = ?`,
+		`DELETE FROM LoginChallenges WHERE UserID = ?`,
+	}
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement, userID); err != nil {
+			slog.Error("Error disabling two-factor authentication", "user_id", userID, "error", err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CreateLoginChallenge starts the second login step for a user whose password was correct and returns its token
+func CreateLoginChallenge(db *sql.DB, userID int, now time.Time) (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(bytes)
+
+	_, err := db.Exec(`INSERT INTO LoginChallenges (TokenHash, UserID, ExpiresAt) VALUES (?, ?, ?)`,
+		hashToken(token), userID, now.Add(loginChallengeTTL).UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		slog.Error("Error creating login challenge", "user_id", userID, "error", err)
+		return "", err
+	}
+	return token, nil
+}
+
+// CompleteLoginChallenge checks the authenticator code, or a recovery code when code is empty, for the login
+// challenge and returns the ID of the user to sign in. Each code works once, and a challenge takes a few attempts.
+// With ErrInvalidCode it still returns the user's ID, so the failure can count against the account.
+func CompleteLoginChallenge(db *sql.DB, challenge, code, recoveryCode string, now time.Time) (int, error) {
+	// The attempt is counted before the code is checked, so concurrent requests can't try more codes than allowed
+	result, err := db.Exec(`UPDATE LoginChallenges SET Attempts = Attempts + 1 WHERE TokenHash = ? AND Attempts < ? AND ExpiresAt > ?`,
+		hashToken(challenge), loginChallengeAttempts, now.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return 0, err
+	} else if updated == 0 {
+		db.Exec(`DELETE FROM LoginChallenges WHERE TokenHash = ?`, hashToken(challenge))
+		return 0, ErrInvalidChallenge
+	}
+
+	var userID int
+	err = db.QueryRow(`SELECT UserID FROM LoginChallenges WHERE TokenHash = ?`, hashToken(challenge)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidChallenge
+	} else if err != nil {
+		return 0, err
+	}
+
+	var valid bool
+	if code != "" {
+		valid, err = useTOTPCode(db, userID, code, now)
+	} else {
+		valid, err = useRecoveryCode(db, userID, recoveryCode, now)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !valid {
+		return userID, ErrInvalidCode
+	}
+
+	if _, err := db.Exec(`DELETE FROM LoginChallenges WHERE TokenHash = ?`, hashToken(challenge)); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// useTOTPCode accepts an authenticator code newer than the last one the user signed in with
+func useTOTPCode(db *sql.DB, userID int, code string, now time.Time) (bool, error) {
+	var secret sql.NullString
+	var lastStep int64
+	err := db.QueryRow(`SELECT TOTPSecret, TOTPLastStep FROM User WHERE UserID = ? AND TOTPEnabled = TRUE`, userID).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret.String, code, now)
+	if !ok || step <= lastStep {
+		return false, nil
+	}
+
+	// Moving the last step only succeeds once, so the same code can't sign in twice
+	result, err := db.Exec(`UPDATE User SET TOTPLastStep = ? WHERE UserID = ? AND TOTPLastStep < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected == 1, nil
+}
+
+// useRecoveryCode uses up one of the user's recovery codes, given with or without the dash
+func useRecoveryCode(db *sql.DB, userID int, code string, now time.Time) (bool, error) {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if code == "" {
+		return false, nil
+	}
+
+	result, err := db.Exec(`UPDATE RecoveryCodes SET UsedAt = ? WHERE UserID = ? AND CodeHash = ? AND UsedAt IS NULL`,
+		now.UTC().Format("2006-01-02 15:04:05"), userID, hashToken(code))
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected == 1, nil
+}
+
+// cleanupLoginChallenges removes the login challenges that expired
+func cleanupLoginChallenges(db *sql.DB) {
+	_, err := db.Exec(`DELETE FROM LoginChallenges WHERE ExpiresAt < ?`, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		slog.Error("Error cleaning up login challenges", "error", err)
+	}
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openTwoFactorDB returns an in-memory datab with the tables the login challenges use and a user without
+// two-factor authentication, so every code is wrong
+func openTwoFactorDB(t *testing.T) (*sql.DB, int) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`
+	CREATE TABLE User (UserID INTEGER PRIMARY KEY, TOTPSecret TEXT, TOTPEnabled BOOLEAN NOT NULL DEFAULT FALSE, TOTPLastStep INTEGER NOT NULL DEFAULT 0);
+	CREATE TABLE LoginChallenges (TokenHash TEXT PRIMARY KEY, UserID INTEGER NOT NULL, ExpiresAt DATETIME NOT NULL,
+		Attempts INTEGER NOT NULL DEFAULT 0);
+	INSERT INTO User (UserID) VALUES (1);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, 1
+}
+
+func TestLoginChallengeExpires(t *testing.T) {
+	db, userID := openTwoFactorDB(t)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	challenge, err := CreateLoginChallenge(db, userID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompleteLoginChallenge(db, challenge, "000000", "", now.Add(loginChallengeTTL-time.Second)); err != ErrInvalidCode {
+		t.Fatalf("before expiry: got %v, want ErrInvalidCode", err)
+	}
+	if _, err := CompleteLoginChallenge(db, challenge, "000000", "", now.Add(loginChallengeTTL+time.Second)); err != ErrInvalidChallenge {
+		t.Fatalf("after expiry: got %v, want ErrInvalidChallenge", err)
+	}
+}
+
+func TestLoginChallengeAttempts(t *testing.T) {
+	db, userID := openTwoFactorDB(t)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	challenge, err := CreateLoginChallenge(db, userID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < loginChallengeAttempts; i++ {
+		gotUserID, err := CompleteLoginChallenge(db, challenge, "000000", "", now)
+		if err != ErrInvalidCode || gotUserID != userID {
+			t.Fatalf("attempt %d: got %d, %v, want %d, ErrInvalidCode", i+1, gotUserID, err, userID)
+		}
+	}
+	if _, err := CompleteLoginChallenge(db, challenge, "000000", "", now); err != ErrInvalidChallenge {
+		t.Fatalf("attempt past the limit: got %v, want ErrInvalidChallenge", err)
+	}
+}
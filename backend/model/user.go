@@ -2,7 +2,7 @@ package model
 
 import (
 	"database/sql"
-	"log"
+	"log/slog"
 	"time"
 )
 
@@ -20,6 +20,9 @@ type User struct {
 	Gender         string    `json:"gender"`
 	CreatedAt      time.Time `json:"createdAt"`
 	ProfilePrivacy string    `json:"profilePrivacy"`
+	DMPolicy       string    `json:"dmPolicy"`
+	Role           string    `json:"role"`
+	Verified       bool      `json:"verified"`
 }
 
 type UserRelation struct {
@@ -35,26 +38,48 @@ type UserRelation struct {
 func RegisterUser(db *sql.DB, user *User) error {
 	query := `INSERT INTO User (Email, PasswordHash, FirstName, LastName, DateOfBirth, ProfilePicture, Nickname, AboutMe, Gender, ProfilePrivacy) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	_, err := db.Exec(query, user.Email, user.PasswordHash, user.FirstName, user.LastName, user.DateOfBirth, user.ProfilePicture, user.Nickname, user.AboutMe, user.Gender, user.ProfilePrivacy)
+	result, err := db.Exec(query, user.Email, user.PasswordHash, user.FirstName, user.LastName, user.DateOfBirth, user.ProfilePicture, user.Nickname, user.AboutMe, user.Gender, user.ProfilePrivacy)
 	if err != nil {
-		log.Printf("Failed to insert user data to datab: %v", err)
+		slog.Error("Failed to insert user data to datab", "error", err)
 		return err
 	}
-	log.Println("Inserted user data to datab")
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	user.UserID = int(userID)
+	slog.Debug("Inserted user")
 	return nil
 }
 
+// userColumns are the columns scanned by scanUser
+const userColumns = `UserID, Email, PasswordHash, FirstName, LastName, DateOfBirth, ProfilePicture, Nickname, AboutMe, Gender, CreatedAt, ProfilePrivacy,
+	IFNULL(DMPolicy, 'everyone'), IFNULL(Role, 'user'), Verified`
+
 func GetUserByCredential(db *sql.DB, credential string) (*User, error) {
-	var user User
-	query := `SELECT UserID, Email, PasswordHash, FirstName, LastName, DateOfBirth, ProfilePicture, Nickname, AboutMe, Gender, CreatedAt, ProfilePrivacy
+	user, err := scanUser(db.QueryRow(`SELECT `+userColumns+`
 	FROM User 
-	WHERE Email = ? OR Nickname = ?`
-	err := db.QueryRow(query, credential, credential).Scan(
+	WHERE Email = ? OR Nickname = ?`, credential, credential))
+	if err != nil {
+		slog.Error("Error querying user by credential", "error", err)
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUserByID returns the user with the ID, or sql.ErrNoRows
+func GetUserByID(db *sql.DB, userID int) (*User, error) {
+	return scanUser(db.QueryRow(`SELECT `+userColumns+` FROM User WHERE UserID = ?`, userID))
+}
+
+func scanUser(row *sql.Row) (*User, error) {
+	var user User
+	err := row.Scan(
 		&user.UserID, &user.Email, &user.PasswordHash, &user.FirstName, &user.LastName,
 		&user.DateOfBirth, &user.ProfilePicture, &user.Nickname, &user.AboutMe, &user.Gender, &user.CreatedAt, &user.ProfilePrivacy,
+		&user.DMPolicy, &user.Role, &user.Verified,
 	)
 	if err != nil {
-		log.Printf("Error querying user with credential '%s': %v", credential, err)
 		return nil, err
 	}
 	return &user, nil
@@ -180,8 +205,10 @@ func GetPendingFollowRequests(db *sql.DB, userID int) (map[int]string, error) {
 	return pendingRequestsMap, nil
 }
 
-func FetchAllUsers(db *sql.DB) ([]User, error) {
-	rows, err := db.Query(`SELECT UserID, FirstName, LastName, ProfilePicture, ProfilePrivacy FROM User`)
+// FetchAllUsers returns every user except those the viewer blocked or was blocked by
+func FetchAllUsers(db *sql.DB, viewerID int) ([]User, error) {
+	rows, err := db.Query(`SELECT UserID, FirstName, LastName, ProfilePicture, ProfilePrivacy FROM User
+	WHERE `+notBlockedClause("User.UserID"), viewerID, viewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -193,7 +220,7 @@ func FetchAllUsers(db *sql.DB) ([]User, error) {
 		// Corrected: Removed user.Email from the Scan method
 		if err := rows.Scan(&user.UserID, &user.FirstName, &user.LastName, &user.ProfilePicture, &user.ProfilePrivacy); err != nil {
 			// Adding detailed error log
-			log.Printf("Error scanning user row: %v", err)
+			slog.Error("Error scanning user row", "error", err)
 			return nil, err
 		}
 		users = append(users, user)
@@ -201,7 +228,7 @@ func FetchAllUsers(db *sql.DB) ([]User, error) {
 
 	if err = rows.Err(); err != nil {
 		// Adding detailed error log
-		log.Printf("Error iterating through user rows: %v", err)
+		slog.Error("Error iterating through user rows", "error", err)
 		return nil, err
 	}
 
@@ -214,14 +241,22 @@ func GetUserDetails(db *sql.DB, userID int) (string, string, error) {
 	row := db.QueryRow(query, userID)
 	err := row.Scan(&firstName, &lastName)
 	if err != nil {
-		log.Println("Error fetching user details:", err)
+		slog.Error("Error fetching user details", "error", err)
 		return "", "", err
 	}
 	return firstName, lastName, nil
 }
 
 func FollowUser(db *sql.DB, followerId, followingId int) error {
-	_, err := db.Exec("INSERT INTO UserFollowers (FollowerUserID, FollowingUserID) VALUES (?, ?)", followerId, followingId)
+	blocked, err := IsBlocked(db, followerId, followingId)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+
+	_, err = db.Exec("INSERT INTO UserFollowers (FollowerUserID, FollowingUserID) VALUES (?, ?)", followerId, followingId)
 	return err
 }
 
@@ -0,0 +1,241 @@
+package model
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	netmail "net/mail"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidEmail             = errors.New("invalid email address")
+	ErrEmailTaken               = errors.New("email already in use")
+	ErrInvalidVerificationToken = errors.New("verification link is invalid or expired")
+	ErrAlreadyVerified          = errors.New("email address is already verified")
+	ErrUnverified               = errors.New("verify your email address first")
+)
+
+// ErrTooManyVerificationEmails is returned when verification e-mails are asked for too often; RetryAfter says when
+// the next one can be sent
+type ErrTooManyVerificationEmails struct {
+	RetryAfter time.Duration
+}
+
+func (e *ErrTooManyVerificationEmails) Error() string {
+	return "too many verification emails, try again later"
+}
+
+const (
+	// verificationTTL is how long a verification link works
+	verificationTTL = 48 * time.Hour
+	// unverifiedGracePeriod is how long a new account can post and send private messages before verifying its email
+	unverifiedGracePeriod = 24 * time.Hour
+	// verificationResendInterval and verificationDailyLimit limit the verification e-mails sent to a user
+	verificationResendInterval = time.Minute
+	verificationDailyLimit     = 5
+)
+
+// ValidateEmail checks the address is a bare e-mail address like name@example.com
+func ValidateEmail(email string) error {
+	address, err := netmail.ParseAddress(email)
+	if err != nil || address.Address != email || len(email) > 255 {
+		return ErrInvalidEmail
+	}
+	return nil
+}
+
+// RequireVerified returns ErrUnverified when the user hasn't verified their email and their grace period is over
+func RequireVerified(db *sql.DB, userID int) error {
+	var verified bool
+	var createdAt time.Time
+	err := db.QueryRow(`SELECT Verified, CreatedAt FROM User WHERE UserID = ?`, userID).Scan(&verified, &createdAt)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+	if !verified && time.Since(createdAt) > unverifiedGracePeriod {
+		return ErrUnverified
+	}
+	return nil
+}
+
+// CreateEmailVerification issues a token confirming the email for the user and returns it. Tokens for other
+// addresses the user was changing to stop working. Sending is limited to one e-mail a minute and five a day.
+func CreateEmailVerification(db *sql.DB, userID int, email string) (string, error) {
+	var sentToday int
+	var firstSent, lastSent sql.NullString
+	err := db.QueryRow(`SELECT COUNT(*), MIN(SentAt), MAX(SentAt) FROM EmailVerificationSends WHERE UserID = ? AND SentAt > ?`,
+		userID, time.Now().Add(-24*time.Hour).UTC().Format("2006-01-02 15:04:05")).Scan(&sentToday, &firstSent, &lastSent)
+	if err != nil {
+		return "", err
+	}
+	if lastSent.Valid {
+		first, _ := time.Parse("2006-01-02 15:04:05", firstSent.String)
+		last, _ := time.Parse("2006-01-02 15:04:05", lastSent.String)
+		if wait := time.Until(last.Add(verificationResendInterval)); wait > 0 {
+			return "", &ErrTooManyVerificationEmails{RetryAfter: wait}
+		}
+		if sentToday >= verificationDailyLimit {
+			return "", &ErrTooManyVerificationEmails{RetryAfter: time.Until(first.Add(24 * time.Hour))}
+		}
+	}
+
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(bytes)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM EmailVerifications WHERE UserID = ? AND Email != ?`, userID, email); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	_, err = tx.Exec(`INSERT INTO EmailVerifications (TokenHash, UserID, Email, ExpiresAt) VALUES (?, ?, ?, ?)`,
+		hashToken(token), userID, email, time.Now().Add(verificationTTL).UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		slog.Error("Error creating email verification", "user_id", userID, "error", err)
+		tx.Rollback()
+		return "", err
+	}
+	// Counted apart from the tokens, which the statement above and VerifyEmail delete
+	if _, err = tx.Exec(`INSERT INTO EmailVerificationSends (UserID) VALUES (?)`, userID); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResendVerification issues a new token for the user's current, unverified email and returns it with the address
+func ResendVerification(db *sql.DB, userID int) (string, string, error) {
+	var email string
+	var verified bool
+	err := db.QueryRow(`SELECT Email, Verified FROM User WHERE UserID = ?`, userID).Scan(&email, &verified)
+	if err == sql.ErrNoRows {
+		return "", "", ErrUserNotFound
+	} else if err != nil {
+		return "", "", err
+	}
+	if verified {
+		return "", "", ErrAlreadyVerified
+	}
+
+	token, err := CreateEmailVerification(db, userID, email)
+	return token, email, err
+}
+
+// VerifyEmail uses a verification token: the address it was sent to becomes the user's verified email
+func VerifyEmail(db *sql.DB, token string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var userID int
+	var email string
+	err = tx.QueryRow(`SELECT UserID, Email FROM EmailVerifications WHERE TokenHash = ? AND ExpiresAt > ?`,
+		hashToken(token), time.Now().UTC().Format("2006-01-02 15:04:05")).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return ErrInvalidVerificationToken
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Someone may have taken a changed-to address since the change was asked for
+	var taken bool
+	if err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM User WHERE (Email = ? OR Nickname = ?) AND UserID != ?)`,
+		email, email, userID).Scan(&taken); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if taken {
+		tx.Rollback()
+		return ErrEmailTaken
+	}
+
+	if _, err = tx.Exec(`UPDATE User SET Email = ?, Verified = TRUE WHERE UserID = ?`, email, userID); err != nil {
+		slog.Error("Error verifying email", "user_id", userID, "error", err)
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM EmailVerifications WHERE UserID = ?`, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ChangeEmail checks the user's password and issues a token for the new address, which replaces the current one
+// once verified. It returns the token.
+func ChangeEmail(db *sql.DB, userID int, password, newEmail string) (string, error) {
+	if err := ValidateEmail(newEmail); err != nil {
+		return "", err
+	}
+
+	var passwordHash, email string
+	err := db.QueryRow(`SELECT PasswordHash, Email FROM User WHERE UserID = ?`, userID).Scan(&passwordHash, &email)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	} else if err != nil {
+		return "", err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+		return "", ErrWrongPassword
+	}
+	if newEmail == email {
+		return "", ErrEmailTaken
+	}
+
+	// Same as UserExists at registration, so logins by email or nickname stay unambiguous
+	var taken bool
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM User WHERE (Email = ? OR Nickname = ?) AND UserID != ?)`,
+		newEmail, newEmail, userID).Scan(&taken); err != nil {
+		return "", err
+	}
+	if taken {
+		return "", ErrEmailTaken
+	}
+
+	return CreateEmailVerification(db, userID, newEmail)
+}
+
+// pendingEmail returns the address the user is changing to, if any
+func pendingEmail(db *sql.DB, userID int, currentEmail string) string {
+	var email string
+	err := db.QueryRow(`SELECT Email FROM EmailVerifications WHERE UserID = ? AND Email != ? AND ExpiresAt > ?
+	ORDER BY CreatedAt DESC LIMIT 1`, userID, currentEmail, time.Now().UTC().Format("2006-01-02 15:04:05")).Scan(&email)
+	if err != nil && err != sql.ErrNoRows {
+		slog.Error("Error fetching pending email", "user_id", userID, "error", err)
+	}
+	return email
+}
+
+// cleanupEmailVerifications removes the verification tokens that expired and the sends older than the daily limit
+// looks back
+func cleanupEmailVerifications(db *sql.DB) {
+	_, err := db.Exec(`DELETE FROM EmailVerifications WHERE ExpiresAt < ?`, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		slog.Error("Error cleaning up email verifications", "error", err)
+	}
+	_, err = db.Exec(`DELETE FROM EmailVerificationSends WHERE SentAt < ?`, time.Now().Add(-24*time.Hour).UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		slog.Error("Error cleaning up email verification sends", "error", err)
+	}
+}
@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config holds every limit of the server
+type Config struct {
+	LoginPerIP      Limit // login attempts from one address
+	LoginPerAccount Limit // login attempts for one email or nickname
+	RegisterPerIP   Limit // accounts created from one address
+	PostsPerUser    Limit // posts and reposts
+	CommentsPerUser Limit
+	MessagesPerUser Limit // chat messages, over all the user's connections
+
+	// WSMessagesPerConn limits every websocket message of a connection. Messages over it are dropped, and the
+	// connection is closed after WSMaxViolations of them in a row.
+	WSMessagesPerConn Limit
+	WSMaxViolations   int
+
+	// After LockoutThreshold failed logins in a row an account is locked for LockoutBase, twice as long for each
+	// failure after that, up to LockoutMax
+	LockoutThreshold int
+	LockoutBase      time.Duration
+	LockoutMax       time.Duration
+
+	// TrustProxy takes client addresses from X-Forwarded-For, for running behind a reverse proxy
+	TrustProxy bool
+}
+
+// DefaultConfig returns limits that real users don't run into
+func DefaultConfig() Config {
+	return Config{
+		LoginPerIP:        Limit{Events: 20, Per: 10 * time.Minute},
+		LoginPerAccount:   Limit{Events: 10, Per: 10 * time.Minute},
+		RegisterPerIP:     Limit{Events: 5, Per: time.Hour},
+		PostsPerUser:      Limit{Events: 10, Per: 10 * time.Minute},
+		CommentsPerUser:   Limit{Events: 30, Per: 10 * time.Minute},
+		MessagesPerUser:   Limit{Events: 30, Per: time.Minute},
+		WSMessagesPerConn: Limit{Events: 50, Per: 10 * time.Second},
+		WSMaxViolations:   20,
+		LockoutThreshold:  5,
+		LockoutBase:       time.Minute,
+		LockoutMax:        time.Hour,
+	}
+}
+
+// ConfigFromEnv returns the default config with the limits set in the environment, like RATE_LIMIT_LOGIN_IP=20/10m
+func ConfigFromEnv() (Config, error) {
+	return ConfigFrom(os.Getenv)
+}
+
+// ConfigFrom is ConfigFromEnv with the variables looked up by getenv
+func ConfigFrom(getenv func(string) string) (Config, error) {
+	config := DefaultConfig()
+
+	limits := map[string]*Limit{
+		"RATE_LIMIT_LOGIN_IP":      &config.LoginPerIP,
+		"RATE_LIMIT_LOGIN_ACCOUNT": &config.LoginPerAccount,
+		"RATE_LIMIT_REGISTER_IP":   &config.RegisterPerIP,
+		"RATE_LIMIT_POSTS":         &config.PostsPerUser,
+		"RATE_LIMIT_COMMENTS":      &config.CommentsPerUser,
+		"RATE_LIMIT_MESSAGES":      &config.MessagesPerUser,
+		"RATE_LIMIT_WS_MESSAGES":   &config.WSMessagesPerConn,
+	}
+	for name, limit := range limits {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := ParseLimit(value)
+		if err != nil {
+			return config, fmt.Errorf("%s: %v", name, err)
+		}
+		*limit = parsed
+	}
+
+	ints := map[string]*int{
+		"RATE_LIMIT_WS_MAX_VIOLATIONS": &config.WSMaxViolations,
+		"LOCKOUT_THRESHOLD":            &config.LockoutThreshold,
+	}
+	for name, n := range ints {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return config, fmt.Errorf("%s: %q is not a number", name, value)
+		}
+		*n = parsed
+	}
+
+	durations := map[string]*time.Duration{
+		"LOCKOUT_BASE": &config.LockoutBase,
+		"LOCKOUT_MAX":  &config.LockoutMax,
+	}
+	for name, d := range durations {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return config, fmt.Errorf("%s: %q is not a duration", name, value)
+		}
+		*d = parsed
+	}
+
+	config.TrustProxy = getenv("TRUST_PROXY") == "true"
+	return config, nil
+}
+
+// LockoutFor returns how long an account is locked after the number of failed logins in a row
+func (c Config) LockoutFor(failures int) time.Duration {
+	if c.LockoutThreshold <= 0 || failures < c.LockoutThreshold {
+		return 0
+	}
+	lockout := c.LockoutBase
+	for i := c.LockoutThreshold; i < failures && lockout < c.LockoutMax; i++ {
+		lockout *= 2
+	}
+	if lockout > c.LockoutMax {
+		lockout = c.LockoutMax
+	}
+	return lockout
+}
+
+// Limiters are the limiters of the server, built from a Config
+type Limiters struct {
+	Config       Config
+	LoginIP      *Limiter
+	LoginAccount *Limiter
+	Register     *Limiter
+	Posts        *Limiter
+	Comments     *Limiter
+	Messages     *Limiter
+}
+
+func New(config Config) *Limiters {
+	return &Limiters{
+		Config:       config,
+		LoginIP:      NewLimiter(config.LoginPerIP),
+		LoginAccount: NewLimiter(config.LoginPerAccount),
+		Register:     NewLimiter(config.RegisterPerIP),
+		Posts:        NewLimiter(config.PostsPerUser),
+		Comments:     NewLimiter(config.CommentsPerUser),
+		Messages:     NewLimiter(config.MessagesPerUser),
+	}
+}
+
+// UserKey is the limiter key of a user
+func UserKey(userID int) string {
+	return strconv.Itoa(userID)
+}
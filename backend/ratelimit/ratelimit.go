@@ -0,0 +1,150 @@
+// Package ratelimit limits how often clients can do something, with a token bucket per client
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"social-network/backend/apierror"
+)
+
+// Limit allows Events per Per on average, and bursts of up to Events at once. A limit without events lets everything through.
+type Limit struct {
+	Events int
+	Per    time.Duration
+}
+
+// ParseLimit reads a limit written like "20/10m", or "off"
+func ParseLimit(s string) (Limit, error) {
+	if s == "off" || s == "0" {
+		return Limit{}, nil
+	}
+	events, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("limit %q is not like 20/10m", s)
+	}
+	n, err := strconv.Atoi(events)
+	if err != nil || n < 0 {
+		return Limit{}, fmt.Errorf("limit %q has an invalid number of events", s)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("limit %q has an invalid period", s)
+	}
+	return Limit{Events: n, Per: d}, nil
+}
+
+func (l Limit) String() string {
+	if l.Events <= 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Events, l.Per)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter keeps a token bucket for each key, such as an IP address or a user ID
+type Limiter struct {
+	limit     Limit
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+func NewLimiter(limit Limit) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token for the key. When there is none left it returns false and how long until there is.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	return l.allowAt(key, time.Now())
+}
+
+func (l *Limiter) allowAt(key string, now time.Time) (bool, time.Duration) {
+	if l == nil || l.limit.Events <= 0 {
+		return true, 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.prune(now)
+
+	burst := float64(l.limit.Events)
+	rate := burst / l.limit.Per.Seconds() // tokens per second
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// prune drops the buckets that have filled up again, they are the same as new ones
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.limit.Per {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.limit.Per {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+// ClientIP returns the address the request came from. Behind a reverse proxy, trustProxy takes it from
+// X-Forwarded-For instead: the last entry, which the proxy appended. The ones before it come from the client,
+// which can make them up.
+func ClientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			entries := strings.Split(forwarded[len(forwarded)-1], ",")
+			if last := strings.TrimSpace(entries[len(entries)-1]); last != "" {
+				return last
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// SetRetryAfter sets the Retry-After header in whole seconds and returns them
+func SetRetryAfter(w http.ResponseWriter, retryAfter time.Duration) int {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return seconds
+}
+
+// TooManyRequests answers 429 with a Retry-After
+func TooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := SetRetryAfter(w, retryAfter)
+	apierror.Write(w, http.StatusTooManyRequests, "rate_limited", "Too many requests, try again later",
+		map[string]int{"retryAfter": seconds})
+}
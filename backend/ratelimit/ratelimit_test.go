@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	l := NewLimiter(Limit{Events: 3, Per: 3 * time.Second})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allowAt("a", now); !ok {
+			t.Fatalf("event %d of the burst was refused", i+1)
+		}
+	}
+	ok, retryAfter := l.allowAt("a", now)
+	if ok {
+		t.Fatal("event past the burst was allowed")
+	}
+	if retryAfter != time.Second {
+		t.Errorf("retry after %v, want 1s", retryAfter)
+	}
+
+	// Other keys have their own bucket
+	if ok, _ := l.allowAt("b", now); !ok {
+		t.Error("another key was refused")
+	}
+
+	// One token comes back a second
+	if ok, _ := l.allowAt("a", now.Add(500*time.Millisecond)); ok {
+		t.Error("allowed before a token came back")
+	}
+	if ok, _ := l.allowAt("a", now.Add(1500*time.Millisecond)); !ok {
+		t.Error("refused after a token came back")
+	}
+
+	// The bucket doesn't fill past the burst
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allowAt("a", later); !ok {
+			t.Fatalf("event %d after refilling was refused", i+1)
+		}
+	}
+	if ok, _ := l.allowAt("a", later); ok {
+		t.Error("bucket filled past the burst")
+	}
+}
+
+func TestLimiterOff(t *testing.T) {
+	var nilLimiter *Limiter
+	for _, l := range []*Limiter{NewLimiter(Limit{}), nilLimiter} {
+		for i := 0; i < 100; i++ {
+			if ok, _ := l.allowAt("a", time.Now()); !ok {
+				t.Fatal("a limiter without events refused one")
+			}
+		}
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("20/10m")
+	if err != nil || limit != (Limit{Events: 20, Per: 10 * time.Minute}) {
+		t.Errorf("ParseLimit(20/10m) = %v, %v", limit, err)
+	}
+	if limit, err := ParseLimit("off"); err != nil || limit.Events != 0 {
+		t.Errorf("ParseLimit(off) = %v, %v", limit, err)
+	}
+	for _, s := range []string{"20", "x/10m", "-1/10m", "20/0s", "20/soon"} {
+		if _, err := ParseLimit(s); err == nil {
+			t.Errorf("ParseLimit(%s) didn't fail", s)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Add("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+	r.Header.Add("X-Forwarded-For", "3.3.3.3, 4.4.4.4")
+
+	if ip := ClientIP(r, false); ip != "10.0.0.1" {
+		t.Errorf("without a proxy got %s, want the remote address", ip)
+	}
+	if ip := ClientIP(r, true); ip != "4.4.4.4" {
+		t.Errorf("behind a proxy got %s, want the entry the proxy appended", ip)
+	}
+
+	r.Header.Del("X-Forwarded-For")
+	if ip := ClientIP(r, true); ip != "10.0.0.1" {
+		t.Errorf("without X-Forwarded-For got %s, want the remote address", ip)
+	}
+}
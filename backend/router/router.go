@@ -0,0 +1,208 @@
+// Package router dispatches requests by method and path. Paths can have parameters like /api/posts/{postID}
+// and end in /* to match everything below them.
+package router
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"social-network/backend/apierror"
+)
+
+type route struct {
+	pattern  string
+	segments []string
+	prefix   bool // the pattern ended in /*
+	handlers map[string]http.Handler
+}
+
+// Router is an http.Handler choosing the handler of a request by its method and path. Static segments win over
+// parameters, and parameters over /* prefixes.
+type Router struct {
+	routes []*route
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers the handler for the method and pattern
+func (rt *Router) Handle(method, pattern string, handler http.Handler) {
+	prefix := strings.HasSuffix(pattern, "/*")
+	segments := split(strings.TrimSuffix(pattern, "*"))
+
+	for _, route := range rt.routes {
+		if route.prefix == prefix && equal(route.segments, segments) {
+			if _, ok := route.handlers[method]; ok {
+				panic("router: " + method + " " + pattern + " registered twice")
+			}
+			route.handlers[method] = handler
+			return
+		}
+	}
+	rt.routes = append(rt.routes, &route{
+		pattern:  pattern,
+		segments: segments,
+		prefix:   prefix,
+		handlers: map[string]http.Handler{method: handler},
+	})
+}
+
+func (rt *Router) Get(pattern string, handler http.HandlerFunc) {
+	rt.Handle(http.MethodGet, pattern, handler)
+}
+
+func (rt *Router) Post(pattern string, handler http.HandlerFunc) {
+	rt.Handle(http.MethodPost, pattern, handler)
+}
+
+func (rt *Router) Put(pattern string, handler http.HandlerFunc) {
+	rt.Handle(http.MethodPut, pattern, handler)
+}
+
+func (rt *Router) Patch(pattern string, handler http.HandlerFunc) {
+	rt.Handle(http.MethodPatch, pattern, handler)
+}
+
+func (rt *Router) Delete(pattern string, handler http.HandlerFunc) {
+	rt.Handle(http.MethodDelete, pattern, handler)
+}
+
+// Methods returns the methods the path can be requested with, for CORS preflight requests
+func (rt *Router) Methods(path string) []string {
+	route, _ := rt.match(path)
+	if route == nil {
+		return nil
+	}
+	return methods(route)
+}
+
+// Pattern returns the pattern of the route the path matches, like /api/posts/{postID}, or "" when none does
+func (rt *Router) Pattern(path string) string {
+	route, _ := rt.match(path)
+	if route == nil {
+		return ""
+	}
+	return route.pattern
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, params := rt.match(r.URL.Path)
+	if route == nil {
+		apierror.HTTPError(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	handler, ok := route.handlers[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		handler, ok = route.handlers[http.MethodGet]
+	}
+	if !ok {
+		w.Header().Set("Allow", strings.Join(methods(route), ", "))
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		apierror.HTTPError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if len(params) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), paramsKey{}, params))
+	}
+	handler.ServeHTTP(w, r)
+}
+
+type paramsKey struct{}
+
+// Param returns the path parameter of the request, or "" when its route has none by that name
+func Param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
+// match finds the most specific route of the path and its parameters
+func (rt *Router) match(path string) (*route, map[string]string) {
+	segments := split(path)
+
+	var best *route
+	var bestParams map[string]string
+	bestScore := -1
+	for _, route := range rt.routes {
+		params, score, ok := route.match(segments)
+		if ok && score > bestScore {
+			best, bestParams, bestScore = route, params, score
+		}
+	}
+	return best, bestParams
+}
+
+// match reports whether the route matches the path segments, with a score that is higher the more of them
+// are static
+func (route *route) match(segments []string) (map[string]string, int, bool) {
+	if len(segments) < len(route.segments) || (!route.prefix && len(segments) != len(route.segments)) {
+		return nil, 0, false
+	}
+
+	var params map[string]string
+	score := 0
+	for i, segment := range route.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if segments[i] == "" {
+				return nil, 0, false
+			}
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[segment[1:len(segment)-1]] = segments[i]
+			score += 2
+			continue
+		}
+		if segment != segments[i] {
+			return nil, 0, false
+		}
+		score += 3
+	}
+	// A prefix only matches what no exact route does
+	if !route.prefix {
+		score++
+	}
+	return params, score, true
+}
+
+func methods(route *route) []string {
+	var list []string
+	for method := range route.handlers {
+		list = append(list, method)
+	}
+	if _, ok := route.handlers[http.MethodGet]; ok {
+		if _, ok := route.handlers[http.MethodHead]; !ok {
+			list = append(list, http.MethodHead)
+		}
+	}
+	list = append(list, http.MethodOptions)
+	sort.Strings(list)
+	return list
+}
+
+func split(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
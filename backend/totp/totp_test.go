@@ -0,0 +1,60 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, the ASCII string "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// rfcVectors are the SHA-1 rows of RFC 6238 appendix B, cut to our 6 digits
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCode(t *testing.T) {
+	for _, vector := range rfcVectors {
+		code, err := Code(rfcSecret, Step(time.Unix(vector.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", vector.unix, err)
+		}
+		if code != vector.code {
+			t.Errorf("Code at %d = %s, want %s", vector.unix, code, vector.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, vector := range rfcVectors {
+		now := time.Unix(vector.unix, 0)
+		step, ok := Validate(rfcSecret, vector.code, now)
+		if !ok || step != Step(now) {
+			t.Errorf("Validate at %d = %d, %v, want %d, true", vector.unix, step, ok, Step(now))
+		}
+	}
+
+	now := time.Unix(1111111111, 0)
+	for _, drift := range []time.Duration{-Period, Period} {
+		if _, ok := Validate(rfcSecret, "050471", now.Add(drift)); !ok {
+			t.Errorf("Validate rejected a code one step off by %v", drift)
+		}
+	}
+	if _, ok := Validate(rfcSecret, "050471", now.Add(2*Period)); ok {
+		t.Error("Validate accepted a code two steps old")
+	}
+	if _, ok := Validate(rfcSecret, "050 471", now); !ok {
+		t.Error("Validate rejected a code with a space")
+	}
+	if _, ok := Validate(rfcSecret, "05047", now); ok {
+		t.Error("Validate accepted a code of 5 digits")
+	}
+}